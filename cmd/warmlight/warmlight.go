@@ -45,11 +45,14 @@ func main() {
 	botConfig := &bot.Config{
 		IsDev:                          config.Bot.IsDev,
 		LogPath:                        config.Bot.LogPath,
+		Mode:                           config.Bot.Mode,
 		WebhookAddress:                 config.Bot.WebhookURL,
+		WebhookSecret:                  config.Bot.WebhookSecret,
 		CertFilePath:                   config.Bot.CertFilePath,
 		PrivKeyFilePath:                config.Bot.PrivKeyFilePath,
 		DefaultActiveSourceTimeoutMins: config.Bot.DefaultActiveSourceTimeoutMins,
 		DeactivatorIntervalMins:        config.Bot.DeactivatorIntervalMins,
+		ListenAddress:                  config.Bot.ListenAddress,
 		Port:                           config.Bot.Port,
 	}
 
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aigic8/warmlight/internal/db/base"
@@ -21,23 +23,51 @@ type UserState = base.UserState
 type Output = base.Output
 type Source = base.Source
 type SourceKind = base.SourceKind
-type QuoteSearchResult = base.SearchQuotesRow
 type CreateQuoteResult = base.CreateQuoteRow
 type Library = base.Library
+type Digest = base.Digest
+type ReviewCard = base.ReviewCard
+type Tag = base.Tag
+type Activity = base.Activity
+type ActivityKind = base.ActivityKind
 
 const SourceKindUnknown = base.SourceKindUnknown
 const SourceKindBook = base.SourceKindBook
 const SourceKindPerson = base.SourceKindPerson
 const SourceKindArticle = base.SourceKindArticle
 
+const ActivityKindCreateQuote = base.ActivityKindCreateQuote
+const ActivityKindEditSource = base.ActivityKindEditSource
+const ActivityKindMergeTags = base.ActivityKindMergeTags
+
 const UserStateNormal = base.UserStateNormal
 const UserStateEditingSource = base.UserStateEditingSource
 const UserStateChangingLibrary = base.UserStateChangingLibrary
 const UserStateConfirmingLibraryChange = base.UserStateConfirmingLibraryChange
+const UserStateEditingQuote = base.UserStateEditingQuote
+const UserStateEditingOutputFilters = base.UserStateEditingOutputFilters
+const UserStateImportingLibrary = base.UserStateImportingLibrary
+const UserStateEditingDigest = base.UserStateEditingDigest
+const UserStateEditingTag = base.UserStateEditingTag
+const UserStateMergingSource = base.UserStateMergingSource
+const UserStateConfirmingSourceChange = base.UserStateConfirmingSourceChange
+const UserStateConfirmingQuote = base.UserStateConfirmingQuote
 
 const ChangeLibraryMergeMode = "merge"
 const ChangeLibraryDeleteMode = "delete"
 
+const ChangeSourceMergeMode = "merge"
+const ChangeSourceDeleteMode = "delete"
+
+const EditTagRenameMode = "rename"
+const EditTagMergeMode = "merge"
+
+// ConfirmQuoteSourcesMode, ConfirmQuoteTagsMode and ConfirmQuoteMainSourceMode are the part of the draft
+// which the next message of the user changes, the draft waits to be saved or discarded without a mode.
+const ConfirmQuoteSourcesMode = "sources"
+const ConfirmQuoteTagsMode = "tags"
+const ConfirmQuoteMainSourceMode = "mainSource"
+
 type DB struct {
 	pool    *pgxpool.Pool
 	q       *base.Queries
@@ -46,6 +76,28 @@ type DB struct {
 
 var VALID_SOURCE_KINDS []string = []string{"unknown", "book", "person", "article"}
 
+// SEARCH_LANGUAGES are the text search configurations of postgres which stem words of a
+// language. Other languages use FALLBACK_SEARCH_LANGUAGE, which matches words as they are.
+var SEARCH_LANGUAGES []string = []string{
+	"arabic", "danish", "dutch", "english", "finnish", "french", "german", "greek", "hungarian", "indonesian", "irish",
+	"italian", "lithuanian", "nepali", "norwegian", "portuguese", "romanian", "russian", "spanish", "swedish", "tamil", "turkish",
+}
+
+const DEFAULT_SEARCH_LANGUAGE = "english"
+const FALLBACK_SEARCH_LANGUAGE = "simple"
+
+// SearchLanguage returns the search language of a language name and reports whether
+// the language is supported, FALLBACK_SEARCH_LANGUAGE is returned if it's not.
+func SearchLanguage(language string) (string, bool) {
+	language = strings.ToLower(strings.TrimSpace(language))
+	for _, searchLanguage := range SEARCH_LANGUAGES {
+		if language == searchLanguage {
+			return language, true
+		}
+	}
+	return FALLBACK_SEARCH_LANGUAGE, language == FALLBACK_SEARCH_LANGUAGE
+}
+
 type (
 	SourceBookData struct {
 		Author       string `json:"author,omitempty"`
@@ -80,8 +132,181 @@ type (
 		LibraryID int64  `json:"libraryID"`
 		Mode      string `json:"mode"`
 	}
+
+	StateEditingQuoteData struct {
+		QuoteID int64 `json:"quoteID"`
+	}
+
+	StateEditingOutputFiltersData struct {
+		OutputChatID int64 `json:"outputChatID"`
+	}
+
+	StateMergingSourceData struct {
+		SourceID int64 `json:"sourceID"`
+	}
+
+	// TargetSourceID is the source which SourceID is merged into, it's not used when deleting
+	StateConfirmingSourceChangeData struct {
+		SourceID       int64  `json:"sourceID"`
+		TargetSourceID int64  `json:"targetSourceID,omitempty"`
+		Mode           string `json:"mode"`
+	}
+
+	StateEditingTagData struct {
+		TagID int64  `json:"tagID"`
+		Mode  string `json:"mode"`
+	}
+
+	StateConfirmingQuoteData struct {
+		Draft QuoteDraft `json:"draft"`
+		Mode  string     `json:"mode,omitempty"`
+	}
+)
+
+// QuoteDraft is a quote which is not saved yet, until the user confirms it.
+type QuoteDraft struct {
+	Text         string       `json:"text"`
+	MainSource   string       `json:"mainSource,omitempty"`
+	Sources      []string     `json:"sources,omitempty"`
+	Tags         []string     `json:"tags,omitempty"`
+	OriginURL    string       `json:"originURL,omitempty"`
+	TextEntities []TextEntity `json:"textEntities,omitempty"`
+	Location     string       `json:"location,omitempty"`
+	Note         string       `json:"note,omitempty"`
+	// MessageID is the message the draft is made from, buttons of older drafts are ignored with it
+	MessageID int `json:"messageID"`
+}
+
+// Activity data keeps what is needed to undo the activity.
+type (
+	ActivityCreateQuoteData struct {
+		QuoteID int64 `json:"quoteID"`
+	}
+
+	// Name, Kind and Data are of the source before it was edited
+	ActivityEditSourceData struct {
+		SourceID int64           `json:"sourceID"`
+		Name     string          `json:"name"`
+		Kind     SourceKind      `json:"kind"`
+		Data     json.RawMessage `json:"data,omitempty"`
+	}
+
+	// QuoteIDs are the quotes which had the tag FromTagName, AddedQuoteIDs are the ones among
+	// them which did not have the tag ToTagID before the merge
+	ActivityMergeTagsData struct {
+		FromTagName   string  `json:"fromTagName"`
+		ToTagID       int64   `json:"toTagID"`
+		QuoteIDs      []int64 `json:"quoteIDs"`
+		AddedQuoteIDs []int64 `json:"addedQuoteIDs"`
+	}
 )
 
+// QuoteSyntax is the keywords a user writes quotes with. Empty keywords are the default ones.
+type QuoteSyntax struct {
+	SourcesPrefix string `json:"sourcesPrefix,omitempty"`
+	TagMarker     string `json:"tagMarker,omitempty"`
+	PagePrefix    string `json:"pagePrefix,omitempty"`
+	NotePrefix    string `json:"notePrefix,omitempty"`
+}
+
+// OutputFilters are the rules a quote should pass to be published to an output.
+// Empty include lists accept everything.
+type OutputFilters struct {
+	IncludeTags        []string `json:"includeTags,omitempty"`
+	ExcludeTags        []string `json:"excludeTags,omitempty"`
+	IncludeSources     []string `json:"includeSources,omitempty"`
+	ExcludeSources     []string `json:"excludeSources,omitempty"`
+	IncludeSourceKinds []string `json:"includeSourceKinds,omitempty"`
+	ExcludeSourceKinds []string `json:"excludeSourceKinds,omitempty"`
+}
+
+type QuoteWithData struct {
+	ID           int64
+	LibraryID    int64
+	Text         string
+	MainSource   sql.NullString
+	Tags         []string
+	Sources      []string
+	OriginURL    sql.NullString
+	TextEntities []TextEntity
+	// Location is where the quote is in its main source, like a page
+	Location  sql.NullString
+	Note      sql.NullString
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TextEntity is formatting of a part of the text of a quote, like bold text or a link. It is the
+// same as Telegram message entities, so Offset and Length are in UTF-16 code units of the text.
+type TextEntity struct {
+	Type     string `json:"type"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+	URL      string `json:"url,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+const DEFAULT_DIGEST_SEND_AT = "09:00"
+const DEFAULT_DIGEST_TIMEZONE = "UTC"
+const DEFAULT_DIGEST_COUNT = 1
+
+// DEFAULT_REVIEW_EASE_FACTOR is the ease factor of new review cards, as in SM-2.
+const DEFAULT_REVIEW_EASE_FACTOR = 2.5
+
+// ReviewSchedule is the state of a review card after it is reviewed.
+type ReviewSchedule struct {
+	Repetitions  int32
+	IntervalDays int32
+	EaseFactor   float64
+	DueAt        time.Time
+}
+
+// DigestSettings are the options of a digest users can change. OutputChatID
+// is the output the digest is sent to, the chat of user is used if it's not valid.
+type DigestSettings struct {
+	IsActive     bool
+	SendAt       string
+	Timezone     string
+	Count        int32
+	OutputChatID sql.NullInt64
+	Filters      OutputFilters
+}
+
+// QuoteDetails are the data of a quote which are saved with it besides its tags and sources.
+type QuoteDetails struct {
+	// OriginURL is only set when the quote is created
+	OriginURL    string
+	TextEntities []TextEntity
+	// Location is where the quote is in its main source, like a page
+	Location string
+	Note     string
+}
+
+// ImportedQuote is a quote read from an import file.
+type ImportedQuote struct {
+	Text         string
+	MainSource   string
+	Tags         []string
+	Sources      []ImportedSource
+	TextEntities []TextEntity
+	Location     string
+	Note         string
+}
+
+// ImportedSource kind and data are only set for sources which are created
+// by the import or whose kind is still unknown.
+type ImportedSource struct {
+	Name string
+	Kind SourceKind
+	Data pgtype.JSON
+}
+
+type ImportResult struct {
+	Created    int
+	Skipped    int
+	Duplicates int
+}
+
 func NewDB(URL string, timeout time.Duration) (*DB, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
@@ -181,6 +406,101 @@ func (db *DB) SetUserStateConfirmingLibraryChange(userID int64, libraryID int64,
 	return &user, nil
 }
 
+func (db *DB) SetUserStateEditingQuote(userID int64, quoteID int64) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+
+	data := StateEditingQuoteData{QuoteID: quoteID}
+	dataBytes, err := json.Marshal(&data)
+	if err != nil {
+		return nil, err
+	}
+	stateData := pgtype.JSON{Bytes: dataBytes, Status: pgtype.Present}
+
+	user, err := db.q.SetUserState(ctx, base.SetUserStateParams{ID: userID, State: UserStateEditingQuote, StateData: stateData})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (db *DB) SetUserStateMergingSource(userID int64, sourceID int64) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+
+	data := StateMergingSourceData{SourceID: sourceID}
+	dataBytes, err := json.Marshal(&data)
+	if err != nil {
+		return nil, err
+	}
+	stateData := pgtype.JSON{Bytes: dataBytes, Status: pgtype.Present}
+
+	user, err := db.q.SetUserState(ctx, base.SetUserStateParams{ID: userID, State: UserStateMergingSource, StateData: stateData})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (db *DB) SetUserStateConfirmingSourceChange(userID int64, sourceID int64, targetSourceID int64, mode string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+
+	data := StateConfirmingSourceChangeData{SourceID: sourceID, TargetSourceID: targetSourceID, Mode: mode}
+	dataBytes, err := json.Marshal(&data)
+	if err != nil {
+		return nil, err
+	}
+	stateData := pgtype.JSON{Bytes: dataBytes, Status: pgtype.Present}
+
+	user, err := db.q.SetUserState(ctx, base.SetUserStateParams{ID: userID, State: UserStateConfirmingSourceChange, StateData: stateData})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (db *DB) SetUserStateEditingTag(userID int64, tagID int64, mode string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+
+	data := StateEditingTagData{TagID: tagID, Mode: mode}
+	dataBytes, err := json.Marshal(&data)
+	if err != nil {
+		return nil, err
+	}
+	stateData := pgtype.JSON{Bytes: dataBytes, Status: pgtype.Present}
+
+	user, err := db.q.SetUserState(ctx, base.SetUserStateParams{ID: userID, State: UserStateEditingTag, StateData: stateData})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (db *DB) SetUserStateConfirmingQuote(userID int64, draft *QuoteDraft, mode string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+
+	data := StateConfirmingQuoteData{Draft: *draft, Mode: mode}
+	dataBytes, err := json.Marshal(&data)
+	if err != nil {
+		return nil, err
+	}
+	stateData := pgtype.JSON{Bytes: dataBytes, Status: pgtype.Present}
+
+	user, err := db.q.SetUserState(ctx, base.SetUserStateParams{ID: userID, State: UserStateConfirmingQuote, StateData: stateData})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
 func (db *DB) GetOrCreateUser(ID, ChatID int64, firstName string) (*User, bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
@@ -411,7 +731,52 @@ func (db *DB) DeleteLibraryToken(libraryID int64) (*Library, error) {
 	return &library, nil
 }
 
-func (db *DB) CreateQuoteWithData(libraryID int64, text, mainSource string, tagNames []string, sourceNames []string) (*CreateQuoteResult, error) {
+// SetLibrarySearchLanguage changes the language quotes of the library are searched with,
+// language should be one of SEARCH_LANGUAGES or FALLBACK_SEARCH_LANGUAGE.
+func (db *DB) SetLibrarySearchLanguage(libraryID int64, language string) (*Library, error) {
+	c, err := db.pool.Acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer c.Release()
+
+	tx, err := c.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(context.Background())
+		} else {
+			tx.Commit(context.Background())
+		}
+	}()
+
+	q := db.q.WithTx(tx)
+
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	library, err := q.SetLibrarySearchLanguage(ctx, base.SetLibrarySearchLanguageParams{SearchLanguage: language, ID: libraryID})
+	if err != nil {
+		return nil, err
+	}
+
+	// search tokens of every quote are generated again, so it can take longer
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 4*db.Timeout)
+	defer cancel2()
+	err = q.SetLibraryQuotesSearchConfig(ctx2, base.SetLibraryQuotesSearchConfigParams{SearchLanguage: language, LibraryID: libraryID})
+	if err != nil {
+		return nil, err
+	}
+
+	return &library, nil
+}
+
+// CreateQuoteWithData creates the quote with its tags, sources and details and logs
+// its creation in the activities of the user, all in a single transaction.
+// details can be nil.
+func (db *DB) CreateQuoteWithData(userID int64, libraryID int64, text, mainSource string, tagNames []string, sourceNames []string, details *QuoteDetails) (*CreateQuoteResult, error) {
 	c, err := db.pool.Acquire(context.Background())
 	if err != nil {
 		return nil, err
@@ -444,312 +809,1811 @@ func (db *DB) CreateQuoteWithData(libraryID int64, text, mainSource string, tagN
 		return nil, err
 	}
 
-	for _, name := range tagNames {
-		ctx, cancel := context.WithTimeout(context.Background(), db.Timeout*2)
-		defer cancel()
-		tagID, err := q.GetOrCreateTag(ctx, base.GetOrCreateTagParams{LibraryID: libraryID, Name: name})
-		if err != nil {
-			return nil, err
-		}
-		err = q.CreateQuotesTags(ctx, base.CreateQuotesTagsParams{Quote: quote.ID, Tag: tagID, LibraryID: libraryID})
-		if err != nil {
-			return nil, err
-		}
+	if err = db.addQuoteTagsAndSources(q, libraryID, quote.ID, tagNames, sourceNames); err != nil {
+		return nil, err
 	}
 
-	for _, name := range sourceNames {
-		ctx, cancel := context.WithTimeout(context.Background(), db.Timeout*2)
-		defer cancel()
-		sourceID, err := q.GetOrCreateSource(ctx, base.GetOrCreateSourceParams{LibraryID: libraryID, Name: name})
-		if err != nil {
-			return nil, err
-		}
-		err = q.CreateQuotesSources(ctx, base.CreateQuotesSourcesParams{Quote: quote.ID, Source: sourceID, LibraryID: libraryID})
-		if err != nil {
+	if details != nil && details.OriginURL != "" {
+		ctx2, cancel2 := context.WithTimeout(context.Background(), db.Timeout)
+		defer cancel2()
+		if err = q.SetQuoteOriginURL(ctx2, base.SetQuoteOriginURLParams{LibraryID: libraryID, ID: quote.ID, OriginUrl: nullString(details.OriginURL)}); err != nil {
 			return nil, err
 		}
 	}
 
+	if err = db.setQuoteDetails(q, libraryID, quote.ID, details); err != nil {
+		return nil, err
+	}
+
+	if _, err = db.createActivity(q, userID, libraryID, ActivityKindCreateQuote, &ActivityCreateQuoteData{QuoteID: quote.ID}); err != nil {
+		return nil, err
+	}
+
 	return &quote, nil
 }
 
-func (db *DB) CreateSource(libraryID int64, name string) (*Source, error) {
+// setQuoteDetails sets the text entities, location and note of the quote. Nothing is changed if details is nil.
+func (db *DB) setQuoteDetails(q *base.Queries, libraryID int64, quoteID int64, details *QuoteDetails) error {
+	if details == nil {
+		return nil
+	}
+
+	textEntities, err := textEntitiesJSON(details.TextEntities)
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	source, err := db.q.CreateSource(ctx, base.CreateSourceParams{LibraryID: libraryID, Name: name})
-	if err != nil {
-		return nil, err
+	if err = q.SetQuoteTextEntities(ctx, base.SetQuoteTextEntitiesParams{LibraryID: libraryID, ID: quoteID, TextEntities: textEntities}); err != nil {
+		return err
 	}
 
-	return &source, nil
+	ctx2, cancel2 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel2()
+	return q.SetQuoteDetails(ctx2, base.SetQuoteDetailsParams{LibraryID: libraryID, ID: quoteID, Location: nullString(details.Location), Note: nullString(details.Note)})
 }
 
-func (db *DB) GetSource(libraryID int64, name string) (*Source, error) {
+func (db *DB) SetUserStateEditingDigest(userID int64) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	source, err := db.q.GetSource(ctx, base.GetSourceParams{LibraryID: libraryID, Name: name})
+	user, err := db.q.SetUserState(ctx, base.SetUserStateParams{ID: userID, State: UserStateEditingDigest, StateData: pgtype.JSON{Status: pgtype.Null}})
 	if err != nil {
 		return nil, err
 	}
 
-	return &source, nil
+	return &user, nil
 }
 
-func (db *DB) GetSourceByID(libraryID int64, sourceID int64) (*Source, error) {
-	// TODO: test GetSourceByID
+func (db *DB) SetUserStateImportingLibrary(userID int64) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	source, err := db.q.GetSourceByID(ctx, base.GetSourceByIDParams{LibraryID: libraryID, ID: sourceID})
+	user, err := db.q.SetUserState(ctx, base.SetUserStateParams{ID: userID, State: UserStateImportingLibrary, StateData: pgtype.JSON{Status: pgtype.Null}})
 	if err != nil {
 		return nil, err
 	}
 
-	return &source, nil
+	return &user, nil
 }
 
-func (db *DB) SetSourceBook(libraryID int64, sourceID int64, sourceData *SourceBookData) (*Source, error) {
-	var err error
-	data := pgtype.JSON{Status: pgtype.Null}
-	if sourceData != nil {
-		data.Status = pgtype.Present
-		data.Bytes, err = json.Marshal(sourceData)
-		if err != nil {
-			return nil, err
-		}
+// ImportQuotes creates quotes with their tags and sources in a single transaction.
+// Quotes without text are skipped and quotes which already exist in the library
+// (or are repeated in quotes) are counted as duplicates.
+func (db *DB) ImportQuotes(libraryID int64, quotes []ImportedQuote) (*ImportResult, error) {
+	newQuotes, res := prepareImport(quotes)
+	if len(newQuotes) == 0 {
+		return res, nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
-	defer cancel()
-	source, err := db.q.SetSourceData(ctx, base.SetSourceDataParams{LibraryID: libraryID, ID: sourceID, Kind: base.SourceKindBook, Data: data})
+	c, err := db.pool.Acquire(context.Background())
 	if err != nil {
 		return nil, err
 	}
+	defer c.Release()
 
-	return &source, nil
-}
+	tx, err := c.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
 
-func (db *DB) SetSourceArticle(libraryID int64, sourceID int64, sourceData *SourceArticleData) (*Source, error) {
-	var err error
-	data := pgtype.JSON{Status: pgtype.Null}
-	if sourceData != nil {
-		data.Status = pgtype.Present
-		data.Bytes, err = json.Marshal(sourceData)
+	defer func() {
 		if err != nil {
-			return nil, err
+			tx.Rollback(context.Background())
+		} else {
+			tx.Commit(context.Background())
 		}
+	}()
+
+	q := db.q.WithTx(tx)
+
+	texts := make([]string, 0, len(newQuotes))
+	for _, quote := range newQuotes {
+		texts = append(texts, quote.Text)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	source, err := db.q.SetSourceData(ctx, base.SetSourceDataParams{LibraryID: libraryID, ID: sourceID, Kind: base.SourceKindArticle, Data: data})
+	var existingTexts []string
+	existingTexts, err = q.GetExistingQuoteTexts(ctx, base.GetExistingQuoteTextsParams{LibraryID: libraryID, Texts: texts})
 	if err != nil {
 		return nil, err
 	}
 
-	return &source, nil
-}
+	existing := map[string]bool{}
+	for _, text := range existingTexts {
+		existing[text] = true
+	}
+
+	tagIDs := map[string]int64{}
+	sourceIDs := map[string]int64{}
+	quotesTags := []base.CopyQuotesTagsParams{}
+	quotesSources := []base.CopyQuotesSourcesParams{}
+	for _, quote := range newQuotes {
+		if existing[quote.Text] {
+			res.Duplicates++
+			continue
+		}
+
+		var created base.CreateQuoteRow
+		if created, err = db.importQuote(q, libraryID, &quote, tagIDs, sourceIDs); err != nil {
+			return nil, err
+		}
+
+		for _, name := range quote.Tags {
+			quotesTags = append(quotesTags, base.CopyQuotesTagsParams{Quote: created.ID, Tag: tagIDs[name], LibraryID: libraryID})
+		}
+		for _, source := range quote.Sources {
+			quotesSources = append(quotesSources, base.CopyQuotesSourcesParams{Quote: created.ID, Source: sourceIDs[source.Name], LibraryID: libraryID})
+		}
+		res.Created++
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), db.Timeout*2)
+	defer cancel2()
+	if _, err = q.CopyQuotesTags(ctx2, quotesTags); err != nil {
+		return nil, err
+	}
+
+	ctx3, cancel3 := context.WithTimeout(context.Background(), db.Timeout*2)
+	defer cancel3()
+	if _, err = q.CopyQuotesSources(ctx3, quotesSources); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// importQuote creates the quote and the tags and sources in tagIDs and sourceIDs
+// which are not created yet. Associations are not created.
+func (db *DB) importQuote(q *base.Queries, libraryID int64, quote *ImportedQuote, tagIDs, sourceIDs map[string]int64) (base.CreateQuoteRow, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	created, err := q.CreateQuote(ctx, base.CreateQuoteParams{LibraryID: libraryID, Text: quote.Text, MainSource: nullString(quote.MainSource)})
+	if err != nil {
+		return created, err
+	}
+
+	if len(quote.TextEntities) != 0 {
+		textEntities, err := textEntitiesJSON(quote.TextEntities)
+		if err != nil {
+			return created, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+		defer cancel()
+		if err = q.SetQuoteTextEntities(ctx, base.SetQuoteTextEntitiesParams{LibraryID: libraryID, ID: created.ID, TextEntities: textEntities}); err != nil {
+			return created, err
+		}
+	}
+
+	if quote.Location != "" || quote.Note != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+		defer cancel()
+		err = q.SetQuoteDetails(ctx, base.SetQuoteDetailsParams{LibraryID: libraryID, ID: created.ID, Location: nullString(quote.Location), Note: nullString(quote.Note)})
+		if err != nil {
+			return created, err
+		}
+	}
+
+	for _, name := range quote.Tags {
+		if _, ok := tagIDs[name]; ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+		defer cancel()
+		if tagIDs[name], err = q.GetOrCreateTag(ctx, base.GetOrCreateTagParams{LibraryID: libraryID, Name: name}); err != nil {
+			return created, err
+		}
+	}
+
+	for _, source := range quote.Sources {
+		if _, ok := sourceIDs[source.Name]; ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+		defer cancel()
+		sourceID, err := q.GetOrCreateSource(ctx, base.GetOrCreateSourceParams{LibraryID: libraryID, Name: source.Name})
+		if err != nil {
+			return created, err
+		}
+		sourceIDs[source.Name] = sourceID
+
+		if source.Kind == "" || source.Kind == SourceKindUnknown {
+			continue
+		}
+		err = q.SetSourceDataIfUnknown(ctx, base.SetSourceDataIfUnknownParams{Kind: source.Kind, Data: source.Data, LibraryID: libraryID, ID: sourceID})
+		if err != nil {
+			return created, err
+		}
+	}
+
+	return created, nil
+}
+
+// prepareImport trims texts of quotes and removes repeated tags and sources of each
+// quote. Returns quotes which should be imported and the result with skipped
+// quotes and the duplicates in quotes counted.
+func prepareImport(quotes []ImportedQuote) ([]ImportedQuote, *ImportResult) {
+	res := ImportResult{}
+	seen := map[string]bool{}
+	prepared := make([]ImportedQuote, 0, len(quotes))
+	for _, quote := range quotes {
+		text := quote.Text
+		quote.Text = strings.TrimSpace(text)
+		if quote.Text == "" {
+			res.Skipped++
+			continue
+		}
+		start := strings.Index(text, quote.Text)
+		quote.TextEntities = SliceTextEntities(text, quote.TextEntities, start, start+len(quote.Text))
+		if seen[quote.Text] {
+			res.Duplicates++
+			continue
+		}
+		seen[quote.Text] = true
+		quote.Location = strings.TrimSpace(quote.Location)
+		quote.Note = strings.TrimSpace(quote.Note)
+
+		seenTags := map[string]bool{}
+		tags := []string{}
+		for _, tag := range quote.Tags {
+			if tag != "" && !seenTags[tag] {
+				seenTags[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+		quote.Tags = tags
+
+		seenSources := map[string]bool{}
+		sources := []ImportedSource{}
+		for _, source := range quote.Sources {
+			if source.Name != "" && !seenSources[source.Name] {
+				seenSources[source.Name] = true
+				sources = append(sources, source)
+			}
+		}
+		// like quotes sent to the bot, the main source is always one of the sources
+		if quote.MainSource != "" && !seenSources[quote.MainSource] {
+			sources = append([]ImportedSource{{Name: quote.MainSource}}, sources...)
+		}
+		quote.Sources = sources
+
+		prepared = append(prepared, quote)
+	}
+
+	return prepared, &res
+}
+
+func (db *DB) SetUserStateEditingOutputFilters(userID int64, outputChatID int64) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+
+	data := StateEditingOutputFiltersData{OutputChatID: outputChatID}
+	dataBytes, err := json.Marshal(&data)
+	if err != nil {
+		return nil, err
+	}
+	stateData := pgtype.JSON{Bytes: dataBytes, Status: pgtype.Present}
+
+	user, err := db.q.SetUserState(ctx, base.SetUserStateParams{ID: userID, State: UserStateEditingOutputFilters, StateData: stateData})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (db *DB) GetQuoteWithData(libraryID int64, quoteID int64) (*QuoteWithData, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	quote, err := db.q.GetQuote(ctx, base.GetQuoteParams{LibraryID: libraryID, ID: quoteID})
+	if err != nil {
+		return nil, err
+	}
+
+	res := QuoteWithData{
+		ID:         quote.ID,
+		LibraryID:  quote.LibraryID,
+		Text:       quote.Text,
+		MainSource: quote.MainSource,
+		OriginURL:  quote.OriginUrl,
+		Location:   quote.Location,
+		Note:       quote.Note,
+		CreatedAt:  quote.CreatedAt,
+		UpdatedAt:  quote.UpdatedAt,
+	}
+	if res.TextEntities, err = parseTextEntities(quote.TextEntities); err != nil {
+		return nil, err
+	}
+	if res.Tags, res.Sources, err = db.getQuoteTagsAndSources(db.q, libraryID, quoteID); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (db *DB) GetQuoteByText(libraryID int64, text string) (*QuoteWithData, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	quote, err := db.q.GetQuoteByText(ctx, base.GetQuoteByTextParams{LibraryID: libraryID, Text: text})
+	if err != nil {
+		return nil, err
+	}
+
+	res := QuoteWithData{
+		ID:         quote.ID,
+		LibraryID:  quote.LibraryID,
+		Text:       quote.Text,
+		MainSource: quote.MainSource,
+		OriginURL:  quote.OriginUrl,
+		Location:   quote.Location,
+		Note:       quote.Note,
+		CreatedAt:  quote.CreatedAt,
+		UpdatedAt:  quote.UpdatedAt,
+	}
+	if res.TextEntities, err = parseTextEntities(quote.TextEntities); err != nil {
+		return nil, err
+	}
+	if res.Tags, res.Sources, err = db.getQuoteTagsAndSources(db.q, libraryID, quote.ID); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (db *DB) GetQuoteSources(libraryID int64, quoteID int64) ([]Source, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	return db.q.GetQuoteSources(ctx, base.GetQuoteSourcesParams{LibraryID: libraryID, Quote: quoteID})
+}
+
+// GetLibraryQuotesWithData returns every quote of a library with its tags and sources, ordered by creation.
+func (db *DB) GetLibraryQuotesWithData(libraryID int64) ([]QuoteWithData, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel()
+	quotes, err := db.q.GetLibraryQuotes(ctx, libraryID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel2()
+	quotesTags, err := db.q.GetLibraryQuotesTags(ctx2, libraryID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx3, cancel3 := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel3()
+	quotesSources, err := db.q.GetLibraryQuotesSources(ctx3, libraryID)
+	if err != nil {
+		return nil, err
+	}
+
+	tagsByQuote := map[int64][]string{}
+	for _, qt := range quotesTags {
+		tagsByQuote[qt.Quote] = append(tagsByQuote[qt.Quote], qt.Name)
+	}
+	sourcesByQuote := map[int64][]string{}
+	for _, qs := range quotesSources {
+		sourcesByQuote[qs.Quote] = append(sourcesByQuote[qs.Quote], qs.Name)
+	}
+
+	res := make([]QuoteWithData, 0, len(quotes))
+	for _, quote := range quotes {
+		textEntities, err := parseTextEntities(quote.TextEntities)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, QuoteWithData{
+			ID:           quote.ID,
+			LibraryID:    quote.LibraryID,
+			Text:         quote.Text,
+			MainSource:   quote.MainSource,
+			Tags:         tagsByQuote[quote.ID],
+			Sources:      sourcesByQuote[quote.ID],
+			OriginURL:    quote.OriginUrl,
+			TextEntities: textEntities,
+			Location:     quote.Location,
+			Note:         quote.Note,
+			CreatedAt:    quote.CreatedAt,
+			UpdatedAt:    quote.UpdatedAt,
+		})
+	}
+
+	return res, nil
+}
+
+// UpdateQuoteWithData updates the quote with its tags, sources and details in a single transaction.
+// The origin URL of the quote is not changed and details can be nil.
+func (db *DB) UpdateQuoteWithData(libraryID int64, quoteID int64, text, mainSource string, tagNames []string, sourceNames []string, details *QuoteDetails) (*QuoteWithData, error) {
+	c, err := db.pool.Acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer c.Release()
+
+	tx, err := c.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(context.Background())
+		} else {
+			tx.Commit(context.Background())
+		}
+	}()
+
+	q := db.q.WithTx(tx)
+
+	mainSourceSql := sql.NullString{}
+	if mainSource != "" {
+		mainSourceSql = sql.NullString{Valid: true, String: mainSource}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	quote, err := q.UpdateQuote(ctx, base.UpdateQuoteParams{LibraryID: libraryID, ID: quoteID, Text: text, MainSource: mainSourceSql})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel2()
+	if err = q.DeleteQuotesTagsOfQuote(ctx2, base.DeleteQuotesTagsOfQuoteParams{LibraryID: libraryID, Quote: quoteID}); err != nil {
+		return nil, err
+	}
+
+	ctx3, cancel3 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel3()
+	if err = q.DeleteQuotesSourcesOfQuote(ctx3, base.DeleteQuotesSourcesOfQuoteParams{LibraryID: libraryID, Quote: quoteID}); err != nil {
+		return nil, err
+	}
+
+	if err = db.addQuoteTagsAndSources(q, libraryID, quoteID, tagNames, sourceNames); err != nil {
+		return nil, err
+	}
+
+	ctx4, cancel4 := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel4()
+	if err = q.DeleteOrphanTagsInLibrary(ctx4, libraryID); err != nil {
+		return nil, err
+	}
+
+	if err = db.setQuoteDetails(q, libraryID, quoteID, details); err != nil {
+		return nil, err
+	}
+
+	res := QuoteWithData{
+		ID:         quote.ID,
+		LibraryID:  quote.LibraryID,
+		Text:       quote.Text,
+		MainSource: quote.MainSource,
+		Tags:       tagNames,
+		Sources:    sourceNames,
+		OriginURL:  quote.OriginUrl,
+		Location:   quote.Location,
+		Note:       quote.Note,
+		CreatedAt:  quote.CreatedAt,
+		UpdatedAt:  quote.UpdatedAt,
+	}
+	if details != nil {
+		res.TextEntities = details.TextEntities
+		res.Location = nullString(details.Location)
+		res.Note = nullString(details.Note)
+	}
+
+	return &res, nil
+}
+
+// SetQuoteOriginURL sets the link to the original message of a quote, for quotes forwarded from channels.
+func (db *DB) SetQuoteOriginURL(libraryID int64, quoteID int64, originURL string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	return db.q.SetQuoteOriginURL(ctx, base.SetQuoteOriginURLParams{LibraryID: libraryID, ID: quoteID, OriginUrl: nullString(originURL)})
+}
+
+// SetQuoteDetails sets the location of a quote in its main source and the note of the user about it.
+// Empty location or note is removed.
+func (db *DB) SetQuoteDetails(libraryID int64, quoteID int64, location, note string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	return db.q.SetQuoteDetails(ctx, base.SetQuoteDetailsParams{LibraryID: libraryID, ID: quoteID, Location: nullString(location), Note: nullString(note)})
+}
+
+// SetQuoteTextEntities sets the formatting of the text of a quote, entities are removed if it is empty.
+func (db *DB) SetQuoteTextEntities(libraryID int64, quoteID int64, entities []TextEntity) error {
+	textEntities, err := textEntitiesJSON(entities)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	return db.q.SetQuoteTextEntities(ctx, base.SetQuoteTextEntitiesParams{LibraryID: libraryID, ID: quoteID, TextEntities: textEntities})
+}
+
+// DeleteQuote deletes a quote with its tag and source associations.
+// Tags which are no longer used by any quote in the library are deleted.
+func (db *DB) DeleteQuote(libraryID int64, quoteID int64) error {
+	c, err := db.pool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer c.Release()
+
+	tx, err := c.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(context.Background())
+		} else {
+			tx.Commit(context.Background())
+		}
+	}()
+
+	err = db.deleteQuote(db.q.WithTx(tx), libraryID, quoteID)
+	return err
+}
+
+func (db *DB) deleteQuote(q *base.Queries, libraryID int64, quoteID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	if err := q.DeleteQuotesTagsOfQuote(ctx, base.DeleteQuotesTagsOfQuoteParams{LibraryID: libraryID, Quote: quoteID}); err != nil {
+		return err
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel2()
+	if err := q.DeleteQuotesSourcesOfQuote(ctx2, base.DeleteQuotesSourcesOfQuoteParams{LibraryID: libraryID, Quote: quoteID}); err != nil {
+		return err
+	}
+
+	ctx3, cancel3 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel3()
+	if err := q.DeleteQuote(ctx3, base.DeleteQuoteParams{LibraryID: libraryID, ID: quoteID}); err != nil {
+		return err
+	}
+
+	ctx4, cancel4 := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel4()
+	return q.DeleteOrphanTagsInLibrary(ctx4, libraryID)
+}
+
+func (db *DB) addQuoteTagsAndSources(q *base.Queries, libraryID, quoteID int64, tagNames []string, sourceNames []string) error {
+	for _, name := range tagNames {
+		ctx, cancel := context.WithTimeout(context.Background(), db.Timeout*2)
+		defer cancel()
+		tagID, err := q.GetOrCreateTag(ctx, base.GetOrCreateTagParams{LibraryID: libraryID, Name: name})
+		if err != nil {
+			return err
+		}
+		err = q.CreateQuotesTags(ctx, base.CreateQuotesTagsParams{Quote: quoteID, Tag: tagID, LibraryID: libraryID})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range sourceNames {
+		ctx, cancel := context.WithTimeout(context.Background(), db.Timeout*2)
+		defer cancel()
+		sourceID, err := q.GetOrCreateSource(ctx, base.GetOrCreateSourceParams{LibraryID: libraryID, Name: name})
+		if err != nil {
+			return err
+		}
+		err = q.CreateQuotesSources(ctx, base.CreateQuotesSourcesParams{Quote: quoteID, Source: sourceID, LibraryID: libraryID})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) getQuoteTagsAndSources(q *base.Queries, libraryID, quoteID int64) ([]string, []string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	tags, err := q.GetQuoteTagNames(ctx, base.GetQuoteTagNamesParams{LibraryID: libraryID, Quote: quoteID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel2()
+	sources, err := q.GetQuoteSourceNames(ctx2, base.GetQuoteSourceNamesParams{LibraryID: libraryID, Quote: quoteID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tags, sources, nil
+}
+
+type TagWithCount struct {
+	ID         int64
+	Name       string
+	QuoteCount int64
+}
+
+type QueryTagsParams struct {
+	LibraryID int64
+	Limit     int32
+	BaseID    int64
+	Before    bool
+}
+
+// QueryTags returns a page of tags of the library with the number of quotes
+// they are used in. Tags are ordered by ID, and like QuerySources, BaseID is
+// the ID of the last tag of the previous page (or the first tag of the next
+// page if Before is true).
+func (db *DB) QueryTags(p QueryTagsParams) ([]TagWithCount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	if p.Before {
+		rows, err := db.q.QueryTagsBefore(ctx, base.QueryTagsBeforeParams{LibraryID: p.LibraryID, ID: p.BaseID, Limit: p.Limit})
+		if err != nil {
+			return nil, err
+		}
+
+		tags := make([]TagWithCount, 0, len(rows))
+		for _, row := range rows {
+			tags = append(tags, TagWithCount{ID: row.ID, Name: row.Name, QuoteCount: row.QuoteCount})
+		}
+		return tags, nil
+	}
+
+	rows, err := db.q.QueryTagsAfter(ctx, base.QueryTagsAfterParams{LibraryID: p.LibraryID, ID: p.BaseID, Limit: p.Limit})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]TagWithCount, 0, len(rows))
+	for _, row := range rows {
+		tags = append(tags, TagWithCount{ID: row.ID, Name: row.Name, QuoteCount: row.QuoteCount})
+	}
+	return tags, nil
+}
+
+func (db *DB) GetTag(libraryID int64, name string) (*Tag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	tag, err := db.q.GetTagByName(ctx, base.GetTagByNameParams{LibraryID: libraryID, Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+func (db *DB) GetTagByID(libraryID int64, tagID int64) (*Tag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	tag, err := db.q.GetTagByID(ctx, base.GetTagByIDParams{LibraryID: libraryID, ID: tagID})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+func (db *DB) RenameTag(libraryID int64, tagID int64, name string) (*Tag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	tag, err := db.q.RenameTag(ctx, base.RenameTagParams{Name: name, LibraryID: libraryID, ID: tagID})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+// MergeTags adds the tag toTagID to every quote which has the tag fromTagID and
+// deletes fromTagID. The merge is logged as an activity of the user.
+func (db *DB) MergeTags(userID, libraryID int64, fromTagID int64, toTagID int64) error {
+	c, err := db.pool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer c.Release()
+
+	tx, err := c.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(context.Background())
+		} else {
+			tx.Commit(context.Background())
+		}
+	}()
+
+	q := db.q.WithTx(tx)
+
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	if _, err = q.GetTagByID(ctx, base.GetTagByIDParams{LibraryID: libraryID, ID: toTagID}); err != nil {
+		return err
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel2()
+	fromTag, err := q.GetTagByID(ctx2, base.GetTagByIDParams{LibraryID: libraryID, ID: fromTagID})
+	if err != nil {
+		return err
+	}
+
+	ctx3, cancel3 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel3()
+	quoteIDs, err := q.GetTagQuoteIDs(ctx3, base.GetTagQuoteIDsParams{LibraryID: libraryID, Tag: fromTagID})
+	if err != nil {
+		return err
+	}
+
+	ctx4, cancel4 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel4()
+	taggedQuoteIDs, err := q.GetTagQuoteIDs(ctx4, base.GetTagQuoteIDsParams{LibraryID: libraryID, Tag: toTagID})
+	if err != nil {
+		return err
+	}
+
+	ctx5, cancel5 := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel5()
+	if err = q.MoveQuotesTagsToTag(ctx5, base.MoveQuotesTagsToTagParams{ToTag: toTagID, LibraryID: libraryID, FromTag: fromTagID}); err != nil {
+		return err
+	}
+
+	if err = db.deleteTag(q, libraryID, fromTagID); err != nil {
+		return err
+	}
+
+	_, err = db.createActivity(q, userID, libraryID, ActivityKindMergeTags, mergeTagsActivityData(fromTag.Name, toTagID, quoteIDs, taggedQuoteIDs))
+	return err
+}
+
+// unmergeTags reverts MergeTags, the merged tag is created again for the quotes which had it and
+// the tag they were merged into is removed from the quotes which did not have it. Quotes which
+// are deleted since the merge are left out.
+func (db *DB) unmergeTags(q *base.Queries, libraryID int64, data *ActivityMergeTagsData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	tagID, err := q.GetOrCreateTag(ctx, base.GetOrCreateTagParams{LibraryID: libraryID, Name: data.FromTagName})
+	if err != nil {
+		return err
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel2()
+	if err = q.CreateQuotesTagsOfQuotes(ctx2, base.CreateQuotesTagsOfQuotesParams{Tag: tagID, LibraryID: libraryID, QuoteIds: data.QuoteIDs}); err != nil {
+		return err
+	}
+
+	ctx3, cancel3 := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel3()
+	return q.DeleteQuotesTagsOfQuotes(ctx3, base.DeleteQuotesTagsOfQuotesParams{LibraryID: libraryID, Tag: data.ToTagID, QuoteIds: data.AddedQuoteIDs})
+}
+
+// DeleteTag removes the tag from every quote and deletes it. Quotes themselves are not deleted.
+func (db *DB) DeleteTag(libraryID int64, tagID int64) error {
+	c, err := db.pool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer c.Release()
+
+	tx, err := c.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(context.Background())
+		} else {
+			tx.Commit(context.Background())
+		}
+	}()
+
+	err = db.deleteTag(db.q.WithTx(tx), libraryID, tagID)
+	return err
+}
+
+func (db *DB) deleteTag(q *base.Queries, libraryID int64, tagID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel()
+	if err := q.DeleteQuotesTagsOfTag(ctx, base.DeleteQuotesTagsOfTagParams{LibraryID: libraryID, Tag: tagID}); err != nil {
+		return err
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel2()
+	return q.DeleteTag(ctx2, base.DeleteTagParams{LibraryID: libraryID, ID: tagID})
+}
+
+func (db *DB) CreateSource(libraryID int64, name string) (*Source, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	source, err := db.q.CreateSource(ctx, base.CreateSourceParams{LibraryID: libraryID, Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	return &source, nil
+}
+
+func (db *DB) GetSource(libraryID int64, name string) (*Source, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	source, err := db.q.GetSource(ctx, base.GetSourceParams{LibraryID: libraryID, Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	return &source, nil
+}
+
+func (db *DB) GetLibrarySources(libraryID int64) ([]Source, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel()
+	return db.q.GetLibrarySources(ctx, libraryID)
+}
+
+func (db *DB) GetSourceByID(libraryID int64, sourceID int64) (*Source, error) {
+	// TODO: test GetSourceByID
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	source, err := db.q.GetSourceByID(ctx, base.GetSourceByIDParams{LibraryID: libraryID, ID: sourceID})
+	if err != nil {
+		return nil, err
+	}
+
+	return &source, nil
+}
+
+func (db *DB) SetSourceBook(libraryID int64, sourceID int64, sourceData *SourceBookData) (*Source, error) {
+	var err error
+	data := pgtype.JSON{Status: pgtype.Null}
+	if sourceData != nil {
+		data.Status = pgtype.Present
+		data.Bytes, err = json.Marshal(sourceData)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	source, err := db.q.SetSourceData(ctx, base.SetSourceDataParams{LibraryID: libraryID, ID: sourceID, Kind: base.SourceKindBook, Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	return &source, nil
+}
+
+func (db *DB) SetSourceArticle(libraryID int64, sourceID int64, sourceData *SourceArticleData) (*Source, error) {
+	var err error
+	data := pgtype.JSON{Status: pgtype.Null}
+	if sourceData != nil {
+		data.Status = pgtype.Present
+		data.Bytes, err = json.Marshal(sourceData)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	source, err := db.q.SetSourceData(ctx, base.SetSourceDataParams{LibraryID: libraryID, ID: sourceID, Kind: base.SourceKindArticle, Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	return &source, nil
+}
+
+func (db *DB) SetSourcePerson(libraryID int64, sourceID int64, sourceData *SourcePersonData) (*Source, error) {
+	var err error
+	data := pgtype.JSON{Status: pgtype.Null}
+	if sourceData != nil {
+		data.Status = pgtype.Present
+		data.Bytes, err = json.Marshal(sourceData)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	source, err := db.q.SetSourceData(ctx, base.SetSourceDataParams{LibraryID: libraryID, ID: sourceID, Kind: base.SourceKindPerson, Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	return &source, nil
+}
+
+func (db *DB) SetSourceUnknown(libraryID int64, sourceID int64) (*Source, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	data := pgtype.JSON{Status: pgtype.Null}
+	source, err := db.q.SetSourceData(ctx, base.SetSourceDataParams{LibraryID: libraryID, ID: sourceID, Kind: base.SourceKindUnknown, Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	return &source, nil
+
+}
+
+// UpdateSource updates the source, the edit is logged as an activity of the user.
+func (db *DB) UpdateSource(userID, libraryID int64, source *Source) (*Source, error) {
+	if source == nil {
+		return nil, errors.New("source is nil")
+	}
+
+	c, err := db.pool.Acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer c.Release()
+
+	tx, err := c.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(context.Background())
+		} else {
+			tx.Commit(context.Background())
+		}
+	}()
+
+	q := db.q.WithTx(tx)
+
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	oldSource, err := q.GetSourceByID(ctx, base.GetSourceByIDParams{LibraryID: libraryID, ID: source.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	resSource, err := db.updateSource(q, libraryID, &oldSource, source)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = db.createActivity(q, userID, libraryID, ActivityKindEditSource, editSourceActivityData(&oldSource)); err != nil {
+		return nil, err
+	}
+
+	return resSource, nil
+}
+
+func (db *DB) updateSource(q *base.Queries, libraryID int64, oldSource *Source, source *Source) (*Source, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	resSource, err := q.UpdateSource(ctx, base.UpdateSourceParams{Name: source.Name, Kind: source.Kind, Data: source.Data, ID: source.ID, LibraryID: libraryID})
+	if err != nil {
+		return nil, err
+	}
+
+	if oldSource.Name == resSource.Name {
+		return &resSource, nil
+	}
+
+	// main sources are stored by name, so they are renamed with the source to keep
+	// quotes (and their search tokens) in sync with it
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel2()
+	err = q.ReplaceQuotesMainSource(ctx2, base.ReplaceQuotesMainSourceParams{NewMainSource: nullString(resSource.Name), LibraryID: libraryID, OldMainSource: oldSource.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx3, cancel3 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel3()
+	err = q.RenameActiveSourceInLibrary(ctx3, base.RenameActiveSourceInLibraryParams{NewName: resSource.Name, LibraryID: libraryID, OldName: oldSource.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	return &resSource, nil
+}
+
+// DeleteSource removes the source from every quote and deletes it. Quotes which
+// have the source as their main source are left without a main source, and
+// users of the library who have it as their active source are deactivated.
+func (db *DB) DeleteSource(libraryID int64, sourceID int64) error {
+	c, err := db.pool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer c.Release()
+
+	tx, err := c.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(context.Background())
+		} else {
+			tx.Commit(context.Background())
+		}
+	}()
+
+	q := db.q.WithTx(tx)
+
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	source, err := q.GetSourceByID(ctx, base.GetSourceByIDParams{LibraryID: libraryID, ID: sourceID})
+	if err != nil {
+		return err
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel2()
+	err = q.ReplaceQuotesMainSource(ctx2, base.ReplaceQuotesMainSourceParams{NewMainSource: sql.NullString{}, LibraryID: libraryID, OldMainSource: source.Name})
+	if err != nil {
+		return err
+	}
+
+	ctx3, cancel3 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel3()
+	err = q.DeactivateSourceInLibrary(ctx3, base.DeactivateSourceInLibraryParams{LibraryID: libraryID, ActiveSource: nullString(source.Name)})
+	if err != nil {
+		return err
+	}
+
+	err = db.deleteSource(q, libraryID, sourceID)
+	return err
+}
+
+// MergeSources adds the source toSourceID to every quote which has the source
+// fromSourceID, replaces it in main sources of quotes and active sources of
+// users of the library and deletes fromSourceID.
+func (db *DB) MergeSources(libraryID int64, fromSourceID int64, toSourceID int64) error {
+	c, err := db.pool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer c.Release()
+
+	tx, err := c.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(context.Background())
+		} else {
+			tx.Commit(context.Background())
+		}
+	}()
+
+	q := db.q.WithTx(tx)
+
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	fromSource, err := q.GetSourceByID(ctx, base.GetSourceByIDParams{LibraryID: libraryID, ID: fromSourceID})
+	if err != nil {
+		return err
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel2()
+	toSource, err := q.GetSourceByID(ctx2, base.GetSourceByIDParams{LibraryID: libraryID, ID: toSourceID})
+	if err != nil {
+		return err
+	}
+
+	ctx3, cancel3 := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel3()
+	err = q.MoveQuotesSourcesToSource(ctx3, base.MoveQuotesSourcesToSourceParams{ToSource: toSourceID, LibraryID: libraryID, FromSource: fromSourceID})
+	if err != nil {
+		return err
+	}
+
+	ctx4, cancel4 := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel4()
+	err = q.ReplaceQuotesMainSource(ctx4, base.ReplaceQuotesMainSourceParams{NewMainSource: nullString(toSource.Name), LibraryID: libraryID, OldMainSource: fromSource.Name})
+	if err != nil {
+		return err
+	}
+
+	ctx5, cancel5 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel5()
+	err = q.RenameActiveSourceInLibrary(ctx5, base.RenameActiveSourceInLibraryParams{NewName: toSource.Name, LibraryID: libraryID, OldName: fromSource.Name})
+	if err != nil {
+		return err
+	}
+
+	err = db.deleteSource(q, libraryID, fromSourceID)
+	return err
+}
+
+func (db *DB) deleteSource(q *base.Queries, libraryID int64, sourceID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel()
+	if err := q.DeleteQuotesSourcesOfSource(ctx, base.DeleteQuotesSourcesOfSourceParams{LibraryID: libraryID, Source: sourceID}); err != nil {
+		return err
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel2()
+	return q.DeleteSource(ctx2, base.DeleteSourceParams{LibraryID: libraryID, ID: sourceID})
+}
+
+type QuerySourcesParams struct {
+	LibraryID  int64
+	NameQuery  string
+	SourceKind string
+	Limit      int32
+	BaseID     int64
+	Before     bool
+}
+
+func (db *DB) QuerySources(p QuerySourcesParams) ([]Source, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	if p.Before {
+		if p.SourceKind == "" {
+			return db.q.QuerySourcesBefore(ctx, base.QuerySourcesBeforeParams{
+				LibraryID: p.LibraryID,
+				ID:        p.BaseID,
+				Column3:   sql.NullString{Valid: true, String: p.NameQuery},
+				Limit:     p.Limit,
+			})
+		} else {
+			return db.q.QuerySourcesBeforeWithKind(ctx, base.QuerySourcesBeforeWithKindParams{
+				LibraryID: p.LibraryID,
+				ID:        p.BaseID,
+				Kind:      base.SourceKind(p.SourceKind),
+				Column4:   sql.NullString{Valid: true, String: p.NameQuery},
+				Limit:     p.Limit,
+			})
+		}
+	}
+
+	if p.SourceKind == "" {
+		return db.q.QuerySourcesAfter(ctx, base.QuerySourcesAfterParams{
+			LibraryID: p.LibraryID,
+			ID:        p.BaseID,
+			Column3:   sql.NullString{Valid: true, String: p.NameQuery},
+			Limit:     p.Limit,
+		})
+	}
+
+	return db.q.QuerySourcesAfterWithKind(ctx, base.QuerySourcesAfterWithKindParams{
+		LibraryID: p.LibraryID,
+		ID:        p.BaseID,
+		Kind:      base.SourceKind(p.SourceKind),
+		Column4:   sql.NullString{Valid: true, String: p.NameQuery},
+		Limit:     p.Limit,
+	})
+}
+
+func (db *DB) SetActiveSource(userID int64, activeSourceStr string, activeSourceExpireTime time.Time) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	user, err := db.q.SetActiveSource(ctx, base.SetActiveSourceParams{
+		ID:                 userID,
+		ActiveSource:       sql.NullString{Valid: true, String: activeSourceStr},
+		ActiveSourceExpire: sql.NullTime{Valid: true, Time: activeSourceExpireTime},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (db *DB) DeactivateExpiredSources() ([]User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	return db.q.DeactivateExpiredSources(ctx)
+}
+
+func (db *DB) DeactivateSource(userID int64) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	user, err := db.q.DeactivateSource(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetUserQuoteSyntax sets the keywords the user writes quotes with, nil syntax resets them to the default ones.
+func (db *DB) SetUserQuoteSyntax(userID int64, syntax *QuoteSyntax) (*User, error) {
+	quoteSyntax := pgtype.JSON{Status: pgtype.Null}
+	if syntax != nil {
+		syntaxBytes, err := json.Marshal(syntax)
+		if err != nil {
+			return nil, err
+		}
+		quoteSyntax = pgtype.JSON{Bytes: syntaxBytes, Status: pgtype.Present}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	user, err := db.q.SetUserQuoteSyntax(ctx, base.SetUserQuoteSyntaxParams{ID: userID, QuoteSyntax: quoteSyntax})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetUserConfirmQuotes sets whether new quotes of the user are kept as drafts until the user saves them.
+func (db *DB) SetUserConfirmQuotes(userID int64, confirmQuotes bool) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	user, err := db.q.SetUserConfirmQuotes(ctx, base.SetUserConfirmQuotesParams{ID: userID, ConfirmQuotes: confirmQuotes})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (db *DB) GetOutputs(userID int64) ([]Output, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	return db.q.GetOutputs(ctx, userID)
+}
+
+func (db *DB) GetActiveOutputs(userID int64) ([]Output, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	return db.q.GetActiveOutputs(ctx, userID)
+}
+
+func (db *DB) GetOutput(userID int64, outputChatID int64) (*Output, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	output, err := db.q.GetOutput(ctx, base.GetOutputParams{UserID: userID, ChatID: outputChatID})
+	if err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
+
+func (db *DB) ActivateOutput(userID int64, outputChatID int64) (*Output, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	output, err := db.q.ActivateOutput(ctx, base.ActivateOutputParams{UserID: userID, ChatID: outputChatID})
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (db *DB) DeactivateOutput(userID int64, outputChatID int64) (*Output, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	output, err := db.q.DeactivateOutput(ctx, base.DeactivateOutputParams{UserID: userID, ChatID: outputChatID})
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (db *DB) SetOutputFilters(userID int64, outputChatID int64, filters *OutputFilters) (*Output, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+
+	filtersBytes, err := json.Marshal(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := db.q.SetOutputFilters(ctx, base.SetOutputFiltersParams{
+		UserID:  userID,
+		ChatID:  outputChatID,
+		Filters: pgtype.JSON{Bytes: filtersBytes, Status: pgtype.Present},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (db *DB) GetOrCreateOutput(userID int64, chatID int64, chatTitle string) (*Output, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	output, err := db.q.GetOutput(ctx, base.GetOutputParams{UserID: userID, ChatID: chatID})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			output, err := db.q.CreateOutput(ctx, base.CreateOutputParams{UserID: userID, ChatID: chatID, Title: chatTitle})
+			if err != nil {
+				return nil, false, err
+			}
+			return &output, true, nil
+		}
+
+		return nil, false, err
+	}
+
+	return &output, false, nil
+}
+
+// kinds of SearchTerm
+const (
+	SearchTermWord       = "word"
+	SearchTermPhrase     = "phrase"
+	SearchTermTag        = "tag"
+	SearchTermSource     = "source"
+	SearchTermSourceKind = "sourceKind"
+)
+
+// SearchTerm is a single condition of a quote search. Words and phrases are matched
+// against text and main source of quotes, other kinds against their tags and sources.
+type SearchTerm struct {
+	Kind    string
+	Value   string
+	Negated bool
+}
+
+// QuoteSearch is a structured quote search. A quote matches the search if it matches
+// at least one term of every clause and is created in [After, Before). Zero After
+// and Before are not applied.
+type QuoteSearch struct {
+	Clauses [][]SearchTerm
+	After   time.Time
+	Before  time.Time
+}
+
+func (qs *QuoteSearch) IsEmpty() bool {
+	return len(qs.Clauses) == 0 && qs.After.IsZero() && qs.Before.IsZero()
+}
+
+// FUZZY_SEARCH_THRESHOLD is the least word similarity (of pg_trgm) a word of a search should
+// have with text or main source of a quote to match it, when it is not matched by text search.
+const FUZZY_SEARCH_THRESHOLD = 0.4
+
+// SearchQuotes returns quotes matching the search with their tags and sources, the most relevant
+// quotes first. Words of the search match quotes with text search or by similarity, so they can have typos.
+// An empty search matches every quote of the library, the newest quotes first.
+func (db *DB) SearchQuotes(libraryID int64, search *QuoteSearch, limit int32, offset int32) ([]QuoteWithData, error) {
+	c, err := db.pool.Acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer c.Release()
 
-func (db *DB) SetSourcePerson(libraryID int64, sourceID int64, sourceData *SourcePersonData) (*Source, error) {
-	var err error
-	data := pgtype.JSON{Status: pgtype.Null}
-	if sourceData != nil {
-		data.Status = pgtype.Present
-		data.Bytes, err = json.Marshal(sourceData)
+	tx, err := c.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
 		if err != nil {
-			return nil, err
+			tx.Rollback(context.Background())
+		} else {
+			tx.Commit(context.Background())
 		}
-	}
+	}()
 
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	source, err := db.q.SetSourceData(ctx, base.SetSourceDataParams{LibraryID: libraryID, ID: sourceID, Kind: base.SourceKindPerson, Data: data})
+
+	// the threshold of '<%' operator can only be changed with a setting
+	threshold := strconv.FormatFloat(FUZZY_SEARCH_THRESHOLD, 'f', -1, 64)
+	if _, err = tx.Exec(ctx, "SELECT SET_CONFIG('pg_trgm.word_similarity_threshold', $1, true)", threshold); err != nil {
+		return nil, err
+	}
+
+	query, args := searchQuotesSQL(libraryID, search, limit, offset)
+	rows, err := tx.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return &source, nil
+	res := []QuoteWithData{}
+	for rows.Next() {
+		var q QuoteWithData
+		var textEntities pgtype.JSON
+		if err = rows.Scan(&q.ID, &q.Text, &q.MainSource, &q.OriginURL, &textEntities, &q.Location, &q.Note, &q.LibraryID, &q.CreatedAt, &q.UpdatedAt, &q.Tags, &q.Sources); err != nil {
+			return nil, err
+		}
+		if q.TextEntities, err = parseTextEntities(textEntities); err != nil {
+			return nil, err
+		}
+		res = append(res, q)
+	}
+
+	err = rows.Err()
+	return res, err
 }
 
-func (db *DB) SetSourceUnknown(libraryID int64, sourceID int64) (*Source, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
-	defer cancel()
-	data := pgtype.JSON{Status: pgtype.Null}
-	source, err := db.q.SetSourceData(ctx, base.SetSourceDataParams{LibraryID: libraryID, ID: sourceID, Kind: base.SourceKindUnknown, Data: data})
-	if err != nil {
-		return nil, err
+// searchQuotesSQL compiles the search into a query and its arguments. Values of the
+// search are only passed as arguments, never written into the query itself.
+func searchQuotesSQL(libraryID int64, search *QuoteSearch, limit int32, offset int32) (string, []any) {
+	args := []any{libraryID}
+	arg := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	conditions := []string{"q.library_id = $1"}
+	rankWords := []string{}
+	for _, clause := range search.Clauses {
+		terms := make([]string, 0, len(clause))
+		for _, term := range clause {
+			terms = append(terms, searchTermSQL(&term, arg))
+			if !term.Negated && (term.Kind == SearchTermWord || term.Kind == SearchTermPhrase) {
+				rankWords = append(rankWords, term.Value)
+			}
+		}
+		conditions = append(conditions, "("+strings.Join(terms, " OR ")+")")
+	}
+	if !search.After.IsZero() {
+		conditions = append(conditions, "q.created_at >= "+arg(search.After))
+	}
+	if !search.Before.IsZero() {
+		conditions = append(conditions, "q.created_at < "+arg(search.Before))
 	}
 
-	return &source, nil
+	// quotes are ranked by both text search and similarity, since words can match with either of them.
+	// Quotes with the same rank are ordered from the newest, the order should be stable for paging.
+	order := "q.created_at DESC, q.id DESC"
+	if len(rankWords) != 0 {
+		words := arg(strings.Join(rankWords, " "))
+		order = "TS_RANK(q.text_tokens, PLAINTO_TSQUERY(" + searchConfigSQL + ", " + words + ")) + WORD_SIMILARITY(" + words + ", " + searchTextSQL + ") DESC, " + order
+	}
 
+	query := "SELECT q.id, q.text, q.main_source, q.origin_url, q.text_entities, q.location, q.note, q.library_id, q.created_at, q.updated_at, " + quoteTagsSQL + ", " + quoteSourcesSQL + " FROM quotes q WHERE " +
+		strings.Join(conditions, " AND ") + " ORDER BY " + order + " LIMIT " + arg(limit) + " OFFSET " + arg(offset)
+	return query, args
 }
 
-func (db *DB) UpdateSource(libraryID int64, source *Source) (*Source, error) {
-	if source == nil {
-		return nil, errors.New("source is nil")
+// searchConfigSQL is the text search configuration of the library, which is the same as
+// the configuration of its quotes. It is not read from quotes so the index can be used.
+const searchConfigSQL = "(SELECT search_language FROM libraries WHERE id = $1)::REGCONFIG"
+
+const quoteTagsSQL = "ARRAY(SELECT t.name FROM quotes_tags qt INNER JOIN tags t ON t.id = qt.tag WHERE qt.quote = q.id ORDER BY t.name)"
+const quoteSourcesSQL = "ARRAY(SELECT s.name FROM quotes_sources qs INNER JOIN sources s ON s.id = qs.source WHERE qs.quote = q.id ORDER BY s.name)"
+
+// searchTextSQL should be the same as the expression of the trigram index of quotes
+const searchTextSQL = "(q.text || ' ' || COALESCE(q.main_source, '') || ' ' || COALESCE(q.note, ''))"
+
+func searchTermSQL(term *SearchTerm, arg func(value any) string) string {
+	var condition string
+	switch term.Kind {
+	case SearchTermPhrase:
+		condition = "q.text_tokens @@ PHRASETO_TSQUERY(" + searchConfigSQL + ", " + arg(term.Value) + ")"
+	case SearchTermTag:
+		condition = "EXISTS (SELECT 1 FROM quotes_tags qt INNER JOIN tags t ON t.id = qt.tag WHERE qt.quote = q.id AND t.name = " + arg(term.Value) + ")"
+	case SearchTermSource:
+		condition = "EXISTS (SELECT 1 FROM quotes_sources qs INNER JOIN sources s ON s.id = qs.source WHERE qs.quote = q.id AND LOWER(s.name) = LOWER(" + arg(term.Value) + "))"
+	case SearchTermSourceKind:
+		condition = "EXISTS (SELECT 1 FROM quotes_sources qs INNER JOIN sources s ON s.id = qs.source WHERE qs.quote = q.id AND s.kind::TEXT = " + arg(term.Value) + ")"
+	default:
+		value := arg(term.Value)
+		condition = "q.text_tokens @@ PLAINTO_TSQUERY(" + searchConfigSQL + ", " + value + ")"
+		// negated words only exclude quotes matching them exactly, not similar ones
+		if !term.Negated {
+			condition = "(" + condition + " OR " + value + " <% " + searchTextSQL + ")"
+		}
+	}
+
+	if term.Negated {
+		return "NOT " + condition
 	}
+	return condition
+}
 
+func (db *DB) DeleteOutput(userID int64, outputChatID int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
+	err := db.q.DeleteOutput(ctx, base.DeleteOutputParams{UserID: userID, ChatID: outputChatID})
+	return err
+}
 
-	resSource, err := db.q.UpdateSource(ctx, base.UpdateSourceParams{Name: source.Name, Kind: source.Kind, Data: source.Data, ID: source.ID, LibraryID: libraryID})
+func (db *DB) GetDigest(userID int64) (*Digest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	digest, err := db.q.GetDigest(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	return &resSource, nil
+	return &digest, nil
 }
 
-type QuerySourcesParams struct {
-	LibraryID  int64
-	NameQuery  string
-	SourceKind string
-	Limit      int32
-	BaseID     int64
-	Before     bool
+func (db *DB) GetActiveDigests() ([]Digest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	return db.q.GetActiveDigests(ctx)
 }
 
-func (db *DB) QuerySources(p QuerySourcesParams) ([]Source, error) {
+// SetDigest creates the digest of user or updates it if it already exists.
+func (db *DB) SetDigest(userID int64, settings *DigestSettings) (*Digest, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	if p.Before {
-		if p.SourceKind == "" {
-			return db.q.QuerySourcesBefore(ctx, base.QuerySourcesBeforeParams{
-				LibraryID: p.LibraryID,
-				ID:        p.BaseID,
-				Column3:   sql.NullString{Valid: true, String: p.NameQuery},
-				Limit:     p.Limit,
-			})
-		} else {
-			return db.q.QuerySourcesBeforeWithKind(ctx, base.QuerySourcesBeforeWithKindParams{
-				LibraryID: p.LibraryID,
-				ID:        p.BaseID,
-				Kind:      base.SourceKind(p.SourceKind),
-				Column4:   sql.NullString{Valid: true, String: p.NameQuery},
-				Limit:     p.Limit,
-			})
-		}
-	}
 
-	if p.SourceKind == "" {
-		return db.q.QuerySourcesAfter(ctx, base.QuerySourcesAfterParams{
-			LibraryID: p.LibraryID,
-			ID:        p.BaseID,
-			Column3:   sql.NullString{Valid: true, String: p.NameQuery},
-			Limit:     p.Limit,
-		})
+	filtersBytes, err := json.Marshal(&settings.Filters)
+	if err != nil {
+		return nil, err
 	}
 
-	return db.q.QuerySourcesAfterWithKind(ctx, base.QuerySourcesAfterWithKindParams{
-		LibraryID: p.LibraryID,
-		ID:        p.BaseID,
-		Kind:      base.SourceKind(p.SourceKind),
-		Column4:   sql.NullString{Valid: true, String: p.NameQuery},
-		Limit:     p.Limit,
+	digest, err := db.q.SetDigest(ctx, base.SetDigestParams{
+		UserID:       userID,
+		IsActive:     settings.IsActive,
+		SendAt:       settings.SendAt,
+		Timezone:     settings.Timezone,
+		Count:        settings.Count,
+		OutputChatID: settings.OutputChatID,
+		Filters:      pgtype.JSON{Bytes: filtersBytes, Status: pgtype.Present},
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &digest, nil
 }
 
-func (db *DB) SetActiveSource(userID int64, activeSourceStr string, activeSourceExpireTime time.Time) (*User, error) {
+func (db *DB) SetDigestLastSentAt(userID int64, lastSentAt time.Time) (*Digest, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	user, err := db.q.SetActiveSource(ctx, base.SetActiveSourceParams{
-		ID:                 userID,
-		ActiveSource:       sql.NullString{Valid: true, String: activeSourceStr},
-		ActiveSourceExpire: sql.NullTime{Valid: true, Time: activeSourceExpireTime},
-	})
-
+	digest, err := db.q.SetDigestLastSentAt(ctx, base.SetDigestLastSentAtParams{UserID: userID, LastSentAt: sql.NullTime{Valid: true, Time: lastSentAt}})
 	if err != nil {
 		return nil, err
 	}
-	return &user, nil
+
+	return &digest, nil
 }
 
-func (db *DB) DeactivateExpiredSources() ([]User, error) {
+// SyncReviewCards creates review cards for quotes of the library which the user
+// doesn't have a card for yet. New cards are due immediately.
+func (db *DB) SyncReviewCards(userID int64, libraryID int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	return db.q.DeactivateExpiredSources(ctx)
+	return db.q.CreateMissingReviewCards(ctx, base.CreateMissingReviewCardsParams{UserID: userID, LibraryID: libraryID})
 }
 
-func (db *DB) DeactivateSource(userID int64) (*User, error) {
+// SyncAllReviewCards syncs review cards of users who have started reviewing (have any card)
+// with quotes of their library, so they are reminded of quotes added later too.
+func (db *DB) SyncAllReviewCards() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*db.Timeout)
+	defer cancel()
+	return db.q.CreateAllMissingReviewCards(ctx)
+}
+
+// GetDueReviewCard returns the review card of the library which is due the
+// longest. Returns ErrNotFound if no card is due.
+func (db *DB) GetDueReviewCard(userID int64, libraryID int64) (*ReviewCard, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	user, err := db.q.DeactivateSource(ctx, userID)
+	card, err := db.q.GetDueReviewCard(ctx, base.GetDueReviewCardParams{UserID: userID, LibraryID: libraryID})
 	if err != nil {
 		return nil, err
 	}
-	return &user, nil
+
+	return &card, nil
 }
 
-func (db *DB) GetOutputs(userID int64) ([]Output, error) {
+func (db *DB) CountDueReviewCards(userID int64, libraryID int64) (int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	return db.q.GetOutputs(ctx, userID)
+	return db.q.CountDueReviewCards(ctx, base.CountDueReviewCardsParams{UserID: userID, LibraryID: libraryID})
 }
 
-func (db *DB) GetOutput(userID int64, outputChatID int64) (*Output, error) {
+func (db *DB) GetReviewCard(userID int64, libraryID int64, quoteID int64) (*ReviewCard, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	output, err := db.q.GetOutput(ctx, base.GetOutputParams{UserID: userID, ChatID: outputChatID})
+	card, err := db.q.GetReviewCard(ctx, base.GetReviewCardParams{UserID: userID, LibraryID: libraryID, QuoteID: quoteID})
 	if err != nil {
 		return nil, err
 	}
 
-	return &output, nil
+	return &card, nil
 }
 
-func (db *DB) ActivateOutput(userID int64, outputChatID int64) (*Output, error) {
+func (db *DB) UpdateReviewCard(userID int64, quoteID int64, schedule *ReviewSchedule) (*ReviewCard, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	output, err := db.q.ActivateOutput(ctx, base.ActivateOutputParams{UserID: userID, ChatID: outputChatID})
+	card, err := db.q.UpdateReviewCard(ctx, base.UpdateReviewCardParams{
+		Repetitions:  schedule.Repetitions,
+		IntervalDays: schedule.IntervalDays,
+		EaseFactor:   schedule.EaseFactor,
+		DueAt:        schedule.DueAt,
+		UserID:       userID,
+		QuoteID:      quoteID,
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &output, nil
+
+	return &card, nil
 }
 
-func (db *DB) DeactivateOutput(userID int64, outputChatID int64) (*Output, error) {
+// GetUsersToRemindReview returns users who have due review cards in their
+// current library and are not reminded since remindedAfter.
+func (db *DB) GetUsersToRemindReview(remindedAfter time.Time) ([]User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	output, err := db.q.DeactivateOutput(ctx, base.DeactivateOutputParams{UserID: userID, ChatID: outputChatID})
+	return db.q.GetUsersToRemindReview(ctx, remindedAfter)
+}
+
+func (db *DB) SetReviewReminded(userID int64, remindedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	return db.q.SetReviewReminded(ctx, base.SetReviewRemindedParams{UserID: userID, RemindedAt: remindedAt})
+}
+
+func (db *DB) createActivity(q *base.Queries, userID, libraryID int64, kind ActivityKind, data any) (*Activity, error) {
+	dataBytes, err := json.Marshal(data)
 	if err != nil {
 		return nil, err
 	}
-	return &output, nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	activity, err := q.CreateActivity(ctx, base.CreateActivityParams{
+		UserID:    userID,
+		LibraryID: libraryID,
+		Kind:      kind,
+		Data:      pgtype.JSON{Bytes: dataBytes, Status: pgtype.Present},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &activity, nil
 }
 
-func (db *DB) GetOrCreateOutput(userID int64, chatID int64, chatTitle string) (*Output, bool, error) {
+// GetLastActivity returns the newest activity of the user in the library which is created after
+// the time and is not undone yet.
+func (db *DB) GetLastActivity(userID, libraryID int64, after time.Time) (*Activity, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	output, err := db.q.GetOutput(ctx, base.GetOutputParams{UserID: userID, ChatID: chatID})
+	activity, err := db.q.GetLastActivity(ctx, base.GetLastActivityParams{UserID: userID, LibraryID: libraryID, CreatedAt: after})
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			output, err := db.q.CreateOutput(ctx, base.CreateOutputParams{UserID: userID, ChatID: chatID, Title: chatTitle})
-			if err != nil {
-				return nil, false, err
-			}
-			return &output, true, nil
+		return nil, err
+	}
+
+	return &activity, nil
+}
+
+// UndoActivity reverts the activity and marks it undone in one transaction. It returns false if the
+// activity can no longer be reverted, like a quote which is deleted since it was created, and the
+// activity is still marked undone. ErrNotFound is returned if the activity is already undone.
+func (db *DB) UndoActivity(libraryID int64, activity *Activity) (bool, error) {
+	c, err := db.pool.Acquire(context.Background())
+	if err != nil {
+		return false, err
+	}
+	defer c.Release()
+
+	tx, err := c.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(context.Background())
+		} else {
+			tx.Commit(context.Background())
 		}
+	}()
 
-		return nil, false, err
+	q := db.q.WithTx(tx)
+
+	// the activity is marked first, so undoing it twice at the same time waits for the first one
+	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel()
+	if _, err = q.SetActivityUndone(ctx, activity.ID); err != nil {
+		return false, err
 	}
 
-	return &output, false, nil
+	var reverted bool
+	switch activity.Kind {
+	case ActivityKindCreateQuote:
+		var data ActivityCreateQuoteData
+		if err = json.Unmarshal(activity.Data.Bytes, &data); err != nil {
+			return false, err
+		}
+		reverted, err = db.undoQuoteCreation(q, libraryID, &data)
+	case ActivityKindEditSource:
+		var data ActivityEditSourceData
+		if err = json.Unmarshal(activity.Data.Bytes, &data); err != nil {
+			return false, err
+		}
+		reverted, err = db.undoSourceEdit(q, libraryID, &data)
+	case ActivityKindMergeTags:
+		var data ActivityMergeTagsData
+		if err = json.Unmarshal(activity.Data.Bytes, &data); err != nil {
+			return false, err
+		}
+		err = db.unmergeTags(q, libraryID, &data)
+		reverted = true
+	default:
+		err = errors.New("unknown activity kind")
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return reverted, nil
 }
 
-func (db *DB) SearchQuotes(libraryID int64, query string, limit int32) ([]QuoteSearchResult, error) {
+func (db *DB) undoQuoteCreation(q *base.Queries, libraryID int64, data *ActivityCreateQuoteData) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
+	if _, err := q.GetQuote(ctx, base.GetQuoteParams{LibraryID: libraryID, ID: data.QuoteID}); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
 
-	return db.q.SearchQuotes(ctx, base.SearchQuotesParams{LibraryID: libraryID, ToTsquery: query, Limit: limit})
+	return true, db.deleteQuote(q, libraryID, data.QuoteID)
 }
 
-func (db *DB) DeleteOutput(userID int64, outputChatID int64) error {
+// undoSourceEdit changes the source back to how it was before the edit. Sources which are
+// deleted or whose old name is taken by another source since the edit are not changed.
+func (db *DB) undoSourceEdit(q *base.Queries, libraryID int64, data *ActivityEditSourceData) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), db.Timeout)
 	defer cancel()
-	err := db.q.DeleteOutput(ctx, base.DeleteOutputParams{UserID: userID, ChatID: outputChatID})
-	return err
+	source, err := q.GetSourceByID(ctx, base.GetSourceByIDParams{LibraryID: libraryID, ID: data.SourceID})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), db.Timeout)
+	defer cancel2()
+	existing, err := q.GetSource(ctx2, base.GetSourceParams{LibraryID: libraryID, Name: data.Name})
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return false, err
+	}
+	if err == nil && existing.ID != source.ID {
+		return false, nil
+	}
+
+	oldSource := source
+	source.Name, source.Kind, source.Data = data.Name, data.Kind, pgtype.JSON{Status: pgtype.Null}
+	if len(data.Data) != 0 {
+		source.Data = pgtype.JSON{Bytes: data.Data, Status: pgtype.Present}
+	}
+	if _, err = db.updateSource(q, libraryID, &oldSource, &source); err != nil {
+		return false, err
+	}
+
+	return true, nil
 }
 
 func (db *DB) DEBUGCleanDB() error {
+	if err := db.q.CleanActivities(context.Background()); err != nil {
+		return err
+	}
+
+	if err := db.q.CleanReviewReminders(context.Background()); err != nil {
+		return err
+	}
+
+	if err := db.q.CleanReviewCards(context.Background()); err != nil {
+		return err
+	}
+
+	if err := db.q.CleanDigests(context.Background()); err != nil {
+		return err
+	}
+
 	if err := db.q.CleanOutputs(context.Background()); err != nil {
 		return err
 	}
@@ -787,3 +2651,98 @@ func (db *DB) DEBUGCleanDB() error {
 func (db *DB) Close() {
 	db.pool.Close()
 }
+
+// mergeTagsActivityData returns what is needed to undo merging the tag fromTagName into toTagID, with
+// quoteIDs and taggedQuoteIDs being the quotes of the tags before the merge
+func mergeTagsActivityData(fromTagName string, toTagID int64, quoteIDs []int64, taggedQuoteIDs []int64) *ActivityMergeTagsData {
+	tagged := map[int64]bool{}
+	for _, quoteID := range taggedQuoteIDs {
+		tagged[quoteID] = true
+	}
+	addedQuoteIDs := []int64{}
+	for _, quoteID := range quoteIDs {
+		if !tagged[quoteID] {
+			addedQuoteIDs = append(addedQuoteIDs, quoteID)
+		}
+	}
+
+	return &ActivityMergeTagsData{FromTagName: fromTagName, ToTagID: toTagID, QuoteIDs: quoteIDs, AddedQuoteIDs: addedQuoteIDs}
+}
+
+func editSourceActivityData(source *Source) *ActivityEditSourceData {
+	data := &ActivityEditSourceData{SourceID: source.ID, Name: source.Name, Kind: source.Kind}
+	if source.Data.Status == pgtype.Present {
+		data.Data = json.RawMessage(source.Data.Bytes)
+	}
+	return data
+}
+
+func nullString(str string) sql.NullString {
+	if str == "" {
+		return sql.NullString{}
+	}
+	return sql.NullString{Valid: true, String: str}
+}
+
+func textEntitiesJSON(entities []TextEntity) (pgtype.JSON, error) {
+	if len(entities) == 0 {
+		return pgtype.JSON{Status: pgtype.Null}, nil
+	}
+
+	entitiesBytes, err := json.Marshal(entities)
+	if err != nil {
+		return pgtype.JSON{}, err
+	}
+	return pgtype.JSON{Bytes: entitiesBytes, Status: pgtype.Present}, nil
+}
+
+func parseTextEntities(textEntities pgtype.JSON) ([]TextEntity, error) {
+	if textEntities.Status != pgtype.Present {
+		return nil, nil
+	}
+
+	var entities []TextEntity
+	if err := json.Unmarshal(textEntities.Bytes, &entities); err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+// SliceTextEntities returns entities of text which are in text[start:end] with their offsets relative
+// to start, parts of entities out of it are cut. start and end are byte offsets, unlike offsets of entities.
+func SliceTextEntities(text string, entities []TextEntity, start, end int) []TextEntity {
+	sliceStart := utf16Len(text[:start])
+	sliceEnd := sliceStart + utf16Len(text[start:end])
+
+	var res []TextEntity
+	for _, entity := range entities {
+		entityStart, entityEnd := entity.Offset, entity.Offset+entity.Length
+		if entityStart < sliceStart {
+			entityStart = sliceStart
+		}
+		if entityEnd > sliceEnd {
+			entityEnd = sliceEnd
+		}
+		if entityStart >= entityEnd {
+			continue
+		}
+
+		entity.Offset = entityStart - sliceStart
+		entity.Length = entityEnd - entityStart
+		res = append(res, entity)
+	}
+	return res
+}
+
+// utf16Len returns the length of str in UTF-16 code units, which is how Telegram counts offsets of entities
+func utf16Len(str string) int {
+	length := 0
+	for _, r := range str {
+		if r >= 0x10000 {
+			length += 2
+		} else {
+			length++
+		}
+	}
+	return length
+}
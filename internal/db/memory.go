@@ -0,0 +1,2034 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/aigic8/warmlight/internal/db/base"
+	"github.com/google/uuid"
+	"github.com/jackc/pgtype"
+)
+
+var errMemoryUniqueViolation = errors.New("duplicate key value violates unique constraint")
+var errMemoryForeignKeyViolation = errors.New("violates foreign key constraint")
+
+// MemoryDB is an in-memory Store. It follows the behavior of DB (including
+// not found errors, unique and foreign key constraints and rolling back failed
+// operations), so it can be used to run the bot without postgres.
+type MemoryDB struct {
+	mu sync.Mutex
+	s  *memoryState
+}
+
+type memoryState struct {
+	lastID        int64
+	users         map[int64]User
+	libraries     map[int64]Library
+	sources       map[int64]Source
+	quotes        map[int64]base.Quote
+	tags          map[int64]base.Tag
+	outputs       map[int64]Output
+	digests       map[int64]Digest
+	reviewCards   map[reviewCardKey]ReviewCard
+	reminders     map[int64]time.Time
+	activities    map[int64]Activity
+	quotesTags    []base.QuotesTag
+	quotesSources []base.QuotesSource
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{s: newMemoryState()}
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{
+		users:       map[int64]User{},
+		libraries:   map[int64]Library{},
+		sources:     map[int64]Source{},
+		quotes:      map[int64]base.Quote{},
+		tags:        map[int64]base.Tag{},
+		outputs:     map[int64]Output{},
+		digests:     map[int64]Digest{},
+		reviewCards: map[reviewCardKey]ReviewCard{},
+		reminders:   map[int64]time.Time{},
+		activities:  map[int64]Activity{},
+	}
+}
+
+func (s *memoryState) clone() *memoryState {
+	c := newMemoryState()
+	c.lastID = s.lastID
+	for k, v := range s.users {
+		c.users[k] = v
+	}
+	for k, v := range s.libraries {
+		c.libraries[k] = v
+	}
+	for k, v := range s.sources {
+		c.sources[k] = v
+	}
+	for k, v := range s.quotes {
+		c.quotes[k] = v
+	}
+	for k, v := range s.tags {
+		c.tags[k] = v
+	}
+	for k, v := range s.outputs {
+		c.outputs[k] = v
+	}
+	for k, v := range s.digests {
+		c.digests[k] = v
+	}
+	for k, v := range s.reviewCards {
+		c.reviewCards[k] = v
+	}
+	for k, v := range s.reminders {
+		c.reminders[k] = v
+	}
+	for k, v := range s.activities {
+		c.activities[k] = v
+	}
+	c.quotesTags = append(c.quotesTags, s.quotesTags...)
+	c.quotesSources = append(c.quotesSources, s.quotesSources...)
+	return c
+}
+
+// tx runs fn on a copy of the state and keeps the changes only if fn succeeds.
+func (m *MemoryDB) tx(fn func(s *memoryState) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.s.clone()
+	if err := fn(c); err != nil {
+		return err
+	}
+	m.s = c
+	return nil
+}
+
+func (m *MemoryDB) Init() error {
+	return nil
+}
+
+func (m *MemoryDB) Close() {}
+
+func (m *MemoryDB) DEBUGCleanDB() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.s = newMemoryState()
+	return nil
+}
+
+/////////////////////// USERS ////////////////////////////
+
+func (m *MemoryDB) GetUser(ID int64) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.s.users[ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (m *MemoryDB) GetOrCreateUser(ID, ChatID int64, firstName string) (*User, bool, error) {
+	var user User
+	var created bool
+	err := m.tx(func(s *memoryState) error {
+		var ok bool
+		if user, ok = s.users[ID]; ok {
+			return nil
+		}
+
+		now := time.Now()
+		library := Library{ID: s.nextID(), OwnerID: ID, SearchLanguage: DEFAULT_SEARCH_LANGUAGE, CreatedAt: now, UpdatedAt: now}
+		s.libraries[library.ID] = library
+
+		user = User{
+			ID:        ID,
+			ChatID:    ChatID,
+			FirstName: firstName,
+			State:     UserStateNormal,
+			StateData: pgtype.JSON{Status: pgtype.Null},
+			LibraryID: library.ID,
+			CreatedAt: now,
+			UpdatedAt: now,
+			// the default syntax is used until the user changes it
+			QuoteSyntax: pgtype.JSON{Status: pgtype.Null},
+		}
+		s.users[ID] = user
+		created = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &user, created, nil
+}
+
+func (m *MemoryDB) SetUserStateNormal(userID int64) (*User, error) {
+	return m.setUserState(userID, UserStateNormal, nil)
+}
+
+func (m *MemoryDB) SetUserStateEditingSource(userID int64, sourceID int64) (*User, error) {
+	return m.setUserState(userID, UserStateEditingSource, &StateEditingSourceData{SourceID: sourceID})
+}
+
+func (m *MemoryDB) SetUserStateChangingLibrary(userID int64, libraryID int64) (*User, error) {
+	return m.setUserState(userID, UserStateChangingLibrary, &StateChangingLibraryData{LibraryID: libraryID})
+}
+
+func (m *MemoryDB) SetUserStateConfirmingLibraryChange(userID int64, libraryID int64, mode string) (*User, error) {
+	return m.setUserState(userID, UserStateConfirmingLibraryChange, &StateConfirmingLibraryChangeData{LibraryID: libraryID, Mode: mode})
+}
+
+func (m *MemoryDB) SetUserStateEditingQuote(userID int64, quoteID int64) (*User, error) {
+	return m.setUserState(userID, UserStateEditingQuote, &StateEditingQuoteData{QuoteID: quoteID})
+}
+
+func (m *MemoryDB) SetUserStateEditingOutputFilters(userID int64, outputChatID int64) (*User, error) {
+	return m.setUserState(userID, UserStateEditingOutputFilters, &StateEditingOutputFiltersData{OutputChatID: outputChatID})
+}
+
+func (m *MemoryDB) SetUserStateEditingDigest(userID int64) (*User, error) {
+	return m.setUserState(userID, UserStateEditingDigest, nil)
+}
+
+func (m *MemoryDB) SetUserStateMergingSource(userID int64, sourceID int64) (*User, error) {
+	return m.setUserState(userID, UserStateMergingSource, &StateMergingSourceData{SourceID: sourceID})
+}
+
+func (m *MemoryDB) SetUserStateConfirmingSourceChange(userID int64, sourceID int64, targetSourceID int64, mode string) (*User, error) {
+	return m.setUserState(userID, UserStateConfirmingSourceChange, &StateConfirmingSourceChangeData{SourceID: sourceID, TargetSourceID: targetSourceID, Mode: mode})
+}
+
+func (m *MemoryDB) SetUserStateEditingTag(userID int64, tagID int64, mode string) (*User, error) {
+	return m.setUserState(userID, UserStateEditingTag, &StateEditingTagData{TagID: tagID, Mode: mode})
+}
+
+func (m *MemoryDB) SetUserStateConfirmingQuote(userID int64, draft *QuoteDraft, mode string) (*User, error) {
+	return m.setUserState(userID, UserStateConfirmingQuote, &StateConfirmingQuoteData{Draft: *draft, Mode: mode})
+}
+
+func (m *MemoryDB) SetUserStateImportingLibrary(userID int64) (*User, error) {
+	return m.setUserState(userID, UserStateImportingLibrary, nil)
+}
+
+func (m *MemoryDB) setUserState(userID int64, state UserState, data any) (*User, error) {
+	stateData := pgtype.JSON{Status: pgtype.Null}
+	if data != nil {
+		dataBytes, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		stateData = pgtype.JSON{Bytes: dataBytes, Status: pgtype.Present}
+	}
+
+	return m.updateUser(userID, func(user *User) {
+		user.State = state
+		user.StateData = stateData
+	})
+}
+
+func (m *MemoryDB) updateUser(userID int64, update func(user *User)) (*User, error) {
+	var user User
+	err := m.tx(func(s *memoryState) error {
+		var ok bool
+		if user, ok = s.users[userID]; !ok {
+			return ErrNotFound
+		}
+		update(&user)
+		s.users[userID] = user
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (m *MemoryDB) DeleteUserCurrentLibraryAndMigrateTo(userID, currLibraryID, newLibraryID int64) error {
+	return m.tx(func(s *memoryState) error {
+		s.quotesTags = filterSlice(s.quotesTags, func(qt base.QuotesTag) bool { return qt.LibraryID != currLibraryID })
+		s.quotesSources = filterSlice(s.quotesSources, func(qs base.QuotesSource) bool { return qs.LibraryID != currLibraryID })
+		for id, quote := range s.quotes {
+			if quote.LibraryID == currLibraryID {
+				delete(s.quotes, id)
+				s.deleteQuoteReviewCards(id)
+			}
+		}
+		for id, tag := range s.tags {
+			if tag.LibraryID == currLibraryID {
+				delete(s.tags, id)
+			}
+		}
+		for id, source := range s.sources {
+			if source.LibraryID == currLibraryID {
+				delete(s.sources, id)
+			}
+		}
+
+		return s.moveUserAndDeleteLibrary(userID, currLibraryID, newLibraryID)
+	})
+}
+
+func (m *MemoryDB) MergeUserCurrentLibraryAndMigrateTo(userID, currLibraryID, newLibraryID int64) error {
+	return m.tx(func(s *memoryState) error {
+		for i, qt := range s.quotesTags {
+			if qt.LibraryID == currLibraryID {
+				s.quotesTags[i].LibraryID = newLibraryID
+			}
+		}
+		for i, qs := range s.quotesSources {
+			if qs.LibraryID == currLibraryID {
+				s.quotesSources[i].LibraryID = newLibraryID
+			}
+		}
+
+		for id, quote := range s.quotes {
+			if quote.LibraryID != currLibraryID {
+				continue
+			}
+			if _, exists := s.quoteByText(newLibraryID, quote.Text); exists {
+				return errMemoryUniqueViolation
+			}
+			quote.LibraryID = newLibraryID
+			s.quotes[id] = quote
+		}
+		for id, tag := range s.tags {
+			if tag.LibraryID != currLibraryID {
+				continue
+			}
+			if _, exists := s.tagByName(newLibraryID, tag.Name); exists {
+				return errMemoryUniqueViolation
+			}
+			tag.LibraryID = newLibraryID
+			s.tags[id] = tag
+		}
+		for id, source := range s.sources {
+			if source.LibraryID != currLibraryID {
+				continue
+			}
+			if _, exists := s.sourceByName(newLibraryID, source.Name); exists {
+				return errMemoryUniqueViolation
+			}
+			source.LibraryID = newLibraryID
+			s.sources[id] = source
+		}
+
+		return s.moveUserAndDeleteLibrary(userID, currLibraryID, newLibraryID)
+	})
+}
+
+func (s *memoryState) moveUserAndDeleteLibrary(userID, currLibraryID, newLibraryID int64) error {
+	user, ok := s.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := s.libraries[newLibraryID]; !ok {
+		return errMemoryForeignKeyViolation
+	}
+	user.LibraryID = newLibraryID
+	s.users[userID] = user
+
+	for _, u := range s.users {
+		if u.LibraryID == currLibraryID {
+			return errMemoryForeignKeyViolation
+		}
+	}
+	delete(s.libraries, currLibraryID)
+	for id, activity := range s.activities {
+		if activity.LibraryID == currLibraryID {
+			delete(s.activities, id)
+		}
+	}
+	return nil
+}
+
+/////////////////////// LIBRARIES ////////////////////////////
+
+func (m *MemoryDB) GetLibrary(libraryID int64) (*Library, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	library, ok := m.s.libraries[libraryID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &library, nil
+}
+
+func (m *MemoryDB) GetLibraryByUUID(UUID uuid.UUID) (*Library, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, library := range m.s.libraries {
+		if library.Token.Valid && library.Token.UUID == UUID {
+			return &library, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryDB) SetLibraryToken(libraryID int64, UUID uuid.UUID, expiresOn time.Time) (*Library, error) {
+	return m.setLibraryToken(libraryID, uuid.NullUUID{UUID: UUID, Valid: true}, sql.NullTime{Time: expiresOn, Valid: true})
+}
+
+func (m *MemoryDB) DeleteLibraryToken(libraryID int64) (*Library, error) {
+	return m.setLibraryToken(libraryID, uuid.NullUUID{}, sql.NullTime{})
+}
+
+// SetLibrarySearchLanguage only keeps the language, words are searched the same way in every language.
+func (m *MemoryDB) SetLibrarySearchLanguage(libraryID int64, language string) (*Library, error) {
+	var library Library
+	err := m.tx(func(s *memoryState) error {
+		var ok bool
+		if library, ok = s.libraries[libraryID]; !ok {
+			return ErrNotFound
+		}
+		library.SearchLanguage = language
+		library.UpdatedAt = time.Now()
+		s.libraries[libraryID] = library
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &library, nil
+}
+
+func (m *MemoryDB) setLibraryToken(libraryID int64, token uuid.NullUUID, expiresOn sql.NullTime) (*Library, error) {
+	var library Library
+	err := m.tx(func(s *memoryState) error {
+		var ok bool
+		if library, ok = s.libraries[libraryID]; !ok {
+			return ErrNotFound
+		}
+		if token.Valid {
+			for _, l := range s.libraries {
+				if l.ID != libraryID && l.Token.Valid && l.Token.UUID == token.UUID {
+					return errMemoryUniqueViolation
+				}
+			}
+		}
+		library.Token = token
+		library.TokenExpiresOn = expiresOn
+		s.libraries[libraryID] = library
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &library, nil
+}
+
+/////////////////////// QUOTES ////////////////////////////
+
+func (m *MemoryDB) CreateQuoteWithData(userID int64, libraryID int64, text, mainSource string, tagNames []string, sourceNames []string, details *QuoteDetails) (*CreateQuoteResult, error) {
+	var quote base.Quote
+	err := m.tx(func(s *memoryState) error {
+		if _, ok := s.libraries[libraryID]; !ok {
+			return errMemoryForeignKeyViolation
+		}
+		if _, exists := s.quoteByText(libraryID, text); exists {
+			return errMemoryUniqueViolation
+		}
+
+		now := time.Now()
+		quote = base.Quote{
+			ID:         s.nextID(),
+			Text:       text,
+			LibraryID:  libraryID,
+			MainSource: nullString(mainSource),
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		if details != nil {
+			quote.OriginUrl = nullString(details.OriginURL)
+		}
+		if err := s.setQuoteDetails(&quote, details); err != nil {
+			return err
+		}
+		s.quotes[quote.ID] = quote
+
+		if err := s.addQuoteTagsAndSources(libraryID, quote.ID, tagNames, sourceNames); err != nil {
+			return err
+		}
+
+		_, err := s.createActivity(userID, libraryID, ActivityKindCreateQuote, &ActivityCreateQuoteData{QuoteID: quote.ID})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreateQuoteResult{
+		ID:         quote.ID,
+		Text:       quote.Text,
+		LibraryID:  quote.LibraryID,
+		MainSource: quote.MainSource,
+		CreatedAt:  quote.CreatedAt,
+		UpdatedAt:  quote.UpdatedAt,
+	}, nil
+}
+
+func (m *MemoryDB) ImportQuotes(libraryID int64, quotes []ImportedQuote) (*ImportResult, error) {
+	newQuotes, res := prepareImport(quotes)
+	if len(newQuotes) == 0 {
+		return res, nil
+	}
+
+	err := m.tx(func(s *memoryState) error {
+		if _, ok := s.libraries[libraryID]; !ok {
+			return errMemoryForeignKeyViolation
+		}
+
+		for _, quote := range newQuotes {
+			if _, exists := s.quoteByText(libraryID, quote.Text); exists {
+				res.Duplicates++
+				continue
+			}
+
+			now := time.Now()
+			textEntities, err := textEntitiesJSON(quote.TextEntities)
+			if err != nil {
+				return err
+			}
+			created := base.Quote{
+				ID:           s.nextID(),
+				Text:         quote.Text,
+				LibraryID:    libraryID,
+				MainSource:   nullString(quote.MainSource),
+				TextEntities: textEntities,
+				Location:     nullString(quote.Location),
+				Note:         nullString(quote.Note),
+				CreatedAt:    now,
+				UpdatedAt:    now,
+			}
+			s.quotes[created.ID] = created
+
+			sourceNames := make([]string, 0, len(quote.Sources))
+			for _, source := range quote.Sources {
+				sourceNames = append(sourceNames, source.Name)
+			}
+			if err := s.addQuoteTagsAndSources(libraryID, created.ID, quote.Tags, sourceNames); err != nil {
+				return err
+			}
+
+			for _, imported := range quote.Sources {
+				source, _ := s.sourceByName(libraryID, imported.Name)
+				if imported.Kind != "" && imported.Kind != SourceKindUnknown && source.Kind == SourceKindUnknown {
+					source.Kind = imported.Kind
+					source.Data = imported.Data
+					s.sources[source.ID] = source
+				}
+			}
+			res.Created++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (m *MemoryDB) GetQuoteWithData(libraryID int64, quoteID int64) (*QuoteWithData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	quote, ok := m.s.quotes[quoteID]
+	if !ok || quote.LibraryID != libraryID {
+		return nil, ErrNotFound
+	}
+	return m.s.quoteWithData(quote), nil
+}
+
+func (m *MemoryDB) GetQuoteByText(libraryID int64, text string) (*QuoteWithData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	quote, ok := m.s.quoteByText(libraryID, text)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m.s.quoteWithData(quote), nil
+}
+
+func (m *MemoryDB) GetQuoteSources(libraryID int64, quoteID int64) ([]Source, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.s.quoteSources(libraryID, quoteID), nil
+}
+
+func (m *MemoryDB) GetLibraryQuotesWithData(libraryID int64) ([]QuoteWithData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := []QuoteWithData{}
+	for _, quote := range m.s.sortedQuotes() {
+		if quote.LibraryID == libraryID {
+			res = append(res, *m.s.quoteWithData(quote))
+		}
+	}
+	return res, nil
+}
+
+func (m *MemoryDB) UpdateQuoteWithData(libraryID int64, quoteID int64, text, mainSource string, tagNames []string, sourceNames []string, details *QuoteDetails) (*QuoteWithData, error) {
+	var quote base.Quote
+	err := m.tx(func(s *memoryState) error {
+		var ok bool
+		if quote, ok = s.quotes[quoteID]; !ok || quote.LibraryID != libraryID {
+			return ErrNotFound
+		}
+		if other, exists := s.quoteByText(libraryID, text); exists && other.ID != quoteID {
+			return errMemoryUniqueViolation
+		}
+
+		quote.Text = text
+		quote.MainSource = nullString(mainSource)
+		quote.TextEntities = pgtype.JSON{Status: pgtype.Null}
+		quote.UpdatedAt = time.Now()
+		if err := s.setQuoteDetails(&quote, details); err != nil {
+			return err
+		}
+		s.quotes[quoteID] = quote
+
+		s.deleteQuoteAssociations(libraryID, quoteID)
+		if err := s.addQuoteTagsAndSources(libraryID, quoteID, tagNames, sourceNames); err != nil {
+			return err
+		}
+		s.deleteOrphanTags(libraryID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := QuoteWithData{
+		ID:         quote.ID,
+		LibraryID:  quote.LibraryID,
+		Text:       quote.Text,
+		MainSource: quote.MainSource,
+		Tags:       tagNames,
+		Sources:    sourceNames,
+		OriginURL:  quote.OriginUrl,
+		Location:   quote.Location,
+		Note:       quote.Note,
+		CreatedAt:  quote.CreatedAt,
+		UpdatedAt:  quote.UpdatedAt,
+	}
+	if details != nil {
+		res.TextEntities = details.TextEntities
+	}
+
+	return &res, nil
+}
+
+// setQuoteDetails sets the text entities, location and note of the quote. Nothing is changed if details is nil.
+func (s *memoryState) setQuoteDetails(quote *base.Quote, details *QuoteDetails) error {
+	if details == nil {
+		return nil
+	}
+
+	textEntities, err := textEntitiesJSON(details.TextEntities)
+	if err != nil {
+		return err
+	}
+	quote.TextEntities = textEntities
+	quote.Location = nullString(details.Location)
+	quote.Note = nullString(details.Note)
+	return nil
+}
+
+func (m *MemoryDB) SetQuoteOriginURL(libraryID int64, quoteID int64, originURL string) error {
+	return m.tx(func(s *memoryState) error {
+		quote, ok := s.quotes[quoteID]
+		if !ok || quote.LibraryID != libraryID {
+			return nil
+		}
+		quote.OriginUrl = nullString(originURL)
+		quote.UpdatedAt = time.Now()
+		s.quotes[quoteID] = quote
+		return nil
+	})
+}
+
+func (m *MemoryDB) SetQuoteDetails(libraryID int64, quoteID int64, location, note string) error {
+	return m.tx(func(s *memoryState) error {
+		quote, ok := s.quotes[quoteID]
+		if !ok || quote.LibraryID != libraryID {
+			return nil
+		}
+		quote.Location = nullString(location)
+		quote.Note = nullString(note)
+		quote.UpdatedAt = time.Now()
+		s.quotes[quoteID] = quote
+		return nil
+	})
+}
+
+func (m *MemoryDB) SetQuoteTextEntities(libraryID int64, quoteID int64, entities []TextEntity) error {
+	textEntities, err := textEntitiesJSON(entities)
+	if err != nil {
+		return err
+	}
+
+	return m.tx(func(s *memoryState) error {
+		quote, ok := s.quotes[quoteID]
+		if !ok || quote.LibraryID != libraryID {
+			return nil
+		}
+		quote.TextEntities = textEntities
+		quote.UpdatedAt = time.Now()
+		s.quotes[quoteID] = quote
+		return nil
+	})
+}
+
+func (m *MemoryDB) DeleteQuote(libraryID int64, quoteID int64) error {
+	return m.tx(func(s *memoryState) error {
+		s.deleteQuote(libraryID, quoteID)
+		return nil
+	})
+}
+
+func (s *memoryState) deleteQuote(libraryID int64, quoteID int64) {
+	s.deleteQuoteAssociations(libraryID, quoteID)
+	if quote, ok := s.quotes[quoteID]; ok && quote.LibraryID == libraryID {
+		delete(s.quotes, quoteID)
+		s.deleteQuoteReviewCards(quoteID)
+	}
+	s.deleteOrphanTags(libraryID)
+}
+
+// SearchQuotes stands in for postgres text search by matching words of terms with the
+// beginning of words in text and main source of quotes, which roughly stands in for
+// stemming. Stop words are not dropped.
+func (m *MemoryDB) SearchQuotes(libraryID int64, search *QuoteSearch, limit int32, offset int32) ([]QuoteWithData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type rankedQuote struct {
+		quote base.Quote
+		rank  float64
+	}
+	matches := []rankedQuote{}
+	for _, quote := range m.s.sortedQuotes() {
+		if quote.LibraryID != libraryID || !m.s.quoteMatchesSearch(quote, search) {
+			continue
+		}
+		matches = append(matches, rankedQuote{quote: quote, rank: searchRank(quote, search)})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.rank != b.rank {
+			return a.rank > b.rank
+		}
+		if !a.quote.CreatedAt.Equal(b.quote.CreatedAt) {
+			return a.quote.CreatedAt.After(b.quote.CreatedAt)
+		}
+		return a.quote.ID > b.quote.ID
+	})
+
+	res := []QuoteWithData{}
+	for i := int(offset); i < len(matches) && int32(len(res)) < limit; i++ {
+		res = append(res, *m.s.quoteWithData(matches[i].quote))
+	}
+
+	return res, nil
+}
+
+// searchRank ranks the quote by similarity of its words to words of the search, like DB does
+func searchRank(quote base.Quote, search *QuoteSearch) float64 {
+	words := textSearchWords(quoteSearchText(quote))
+	rank := 0.0
+	for _, clause := range search.Clauses {
+		for _, term := range clause {
+			if term.Negated || (term.Kind != SearchTermWord && term.Kind != SearchTermPhrase) {
+				continue
+			}
+			for _, termWord := range textSearchWords(term.Value) {
+				rank += wordSimilarity(termWord, words)
+			}
+		}
+	}
+	return rank
+}
+
+func (s *memoryState) quoteMatchesSearch(quote base.Quote, search *QuoteSearch) bool {
+	if !search.After.IsZero() && quote.CreatedAt.Before(search.After) {
+		return false
+	}
+	if !search.Before.IsZero() && !quote.CreatedAt.Before(search.Before) {
+		return false
+	}
+
+	words := textSearchWords(quoteSearchText(quote))
+	for _, clause := range search.Clauses {
+		matched := false
+		for _, term := range clause {
+			if s.quoteMatchesTerm(quote, words, &term) != term.Negated {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *memoryState) quoteMatchesTerm(quote base.Quote, words []string, term *SearchTerm) bool {
+	switch term.Kind {
+	case SearchTermPhrase:
+		return matchesPhrase(words, textSearchWords(term.Value))
+	case SearchTermTag:
+		for _, tag := range s.quoteWithData(quote).Tags {
+			if tag == term.Value {
+				return true
+			}
+		}
+		return false
+	case SearchTermSource:
+		for _, source := range s.quoteSources(quote.LibraryID, quote.ID) {
+			if strings.EqualFold(source.Name, term.Value) {
+				return true
+			}
+		}
+		return false
+	case SearchTermSourceKind:
+		for _, source := range s.quoteSources(quote.LibraryID, quote.ID) {
+			if string(source.Kind) == term.Value {
+				return true
+			}
+		}
+		return false
+	default:
+		terms := textSearchWords(term.Value)
+		if len(terms) == 0 {
+			return false
+		}
+		if matchesAllTerms(words, terms) {
+			return true
+		}
+		// negated words only exclude quotes matching them exactly, not similar ones
+		if term.Negated {
+			return false
+		}
+		for _, t := range terms {
+			if wordSimilarity(t, words) < FUZZY_SEARCH_THRESHOLD {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// wordSimilarity is the greatest share of trigrams of the term which a word has, which is
+// close to what word_similarity of pg_trgm returns when the term is a single word
+func wordSimilarity(term string, words []string) float64 {
+	termTrigrams := trigrams(term)
+	if len(termTrigrams) == 0 {
+		return 0
+	}
+
+	best := 0.0
+	for _, word := range words {
+		wordTrigrams := trigrams(word)
+		common := 0
+		for trigram := range termTrigrams {
+			if wordTrigrams[trigram] {
+				common++
+			}
+		}
+		if similarity := float64(common) / float64(len(termTrigrams)); similarity > best {
+			best = similarity
+		}
+	}
+	return best
+}
+
+// trigrams returns trigrams of the word the same way as pg_trgm, with two spaces before and one after it
+func trigrams(word string) map[string]bool {
+	runes := []rune("  " + word + " ")
+	res := map[string]bool{}
+	for i := 0; i+3 <= len(runes); i++ {
+		res[string(runes[i:i+3])] = true
+	}
+	return res
+}
+
+// quoteSearchText is the text of the quote which is searched, like searchTextSQL
+func quoteSearchText(quote base.Quote) string {
+	return quote.Text + " " + quote.MainSource.String + " " + quote.Note.String
+}
+
+func textSearchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func matchesAllTerms(words []string, terms []string) bool {
+	for _, term := range terms {
+		found := false
+		for _, word := range words {
+			if strings.HasPrefix(word, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesPhrase reports whether terms match consecutive words, the same way as matchesAllTerms
+func matchesPhrase(words []string, terms []string) bool {
+	if len(terms) == 0 {
+		return false
+	}
+
+	for i := 0; i+len(terms) <= len(words); i++ {
+		matched := true
+		for j, term := range terms {
+			if !strings.HasPrefix(words[i+j], term) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryState) quoteByText(libraryID int64, text string) (base.Quote, bool) {
+	for _, quote := range s.quotes {
+		if quote.LibraryID == libraryID && quote.Text == text {
+			return quote, true
+		}
+	}
+	return base.Quote{}, false
+}
+
+func (s *memoryState) sortedQuotes() []base.Quote {
+	quotes := make([]base.Quote, 0, len(s.quotes))
+	for _, quote := range s.quotes {
+		quotes = append(quotes, quote)
+	}
+	sort.Slice(quotes, func(i, j int) bool { return quotes[i].ID < quotes[j].ID })
+	return quotes
+}
+
+func (s *memoryState) quoteWithData(quote base.Quote) *QuoteWithData {
+	res := QuoteWithData{
+		ID:         quote.ID,
+		LibraryID:  quote.LibraryID,
+		Text:       quote.Text,
+		MainSource: quote.MainSource,
+		OriginURL:  quote.OriginUrl,
+		Location:   quote.Location,
+		Note:       quote.Note,
+		CreatedAt:  quote.CreatedAt,
+		UpdatedAt:  quote.UpdatedAt,
+	}
+	// entities are always valid, since they are encoded by the store itself
+	res.TextEntities, _ = parseTextEntities(quote.TextEntities)
+	for _, qt := range s.quotesTags {
+		if qt.LibraryID == quote.LibraryID && qt.Quote == quote.ID {
+			res.Tags = append(res.Tags, s.tags[qt.Tag].Name)
+		}
+	}
+	for _, source := range s.quoteSources(quote.LibraryID, quote.ID) {
+		res.Sources = append(res.Sources, source.Name)
+	}
+	return &res
+}
+
+func (s *memoryState) quoteSources(libraryID, quoteID int64) []Source {
+	sources := []Source{}
+	for _, qs := range s.quotesSources {
+		if qs.LibraryID == libraryID && qs.Quote == quoteID {
+			sources = append(sources, s.sources[qs.Source])
+		}
+	}
+	return sources
+}
+
+func (s *memoryState) addQuoteTagsAndSources(libraryID, quoteID int64, tagNames []string, sourceNames []string) error {
+	for _, name := range tagNames {
+		tag, exists := s.tagByName(libraryID, name)
+		if !exists {
+			now := time.Now()
+			tag = base.Tag{ID: s.nextID(), Name: name, LibraryID: libraryID, CreatedAt: now, UpdatedAt: now}
+			s.tags[tag.ID] = tag
+		}
+		for _, qt := range s.quotesTags {
+			if qt.Tag == tag.ID && qt.Quote == quoteID {
+				return errMemoryUniqueViolation
+			}
+		}
+		s.quotesTags = append(s.quotesTags, base.QuotesTag{LibraryID: libraryID, Tag: tag.ID, Quote: quoteID})
+	}
+
+	for _, name := range sourceNames {
+		source, exists := s.sourceByName(libraryID, name)
+		if !exists {
+			var err error
+			if source, err = s.createSource(libraryID, name); err != nil {
+				return err
+			}
+		}
+		for _, qs := range s.quotesSources {
+			if qs.Source == source.ID && qs.Quote == quoteID {
+				return errMemoryUniqueViolation
+			}
+		}
+		s.quotesSources = append(s.quotesSources, base.QuotesSource{LibraryID: libraryID, Source: source.ID, Quote: quoteID})
+	}
+
+	return nil
+}
+
+func (s *memoryState) deleteQuoteAssociations(libraryID, quoteID int64) {
+	s.quotesTags = filterSlice(s.quotesTags, func(qt base.QuotesTag) bool {
+		return qt.LibraryID != libraryID || qt.Quote != quoteID
+	})
+	s.quotesSources = filterSlice(s.quotesSources, func(qs base.QuotesSource) bool {
+		return qs.LibraryID != libraryID || qs.Quote != quoteID
+	})
+}
+
+/////////////////////// TAGS ////////////////////////////
+
+func (m *MemoryDB) QueryTags(p QueryTagsParams) ([]TagWithCount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	quoteCounts := map[int64]int64{}
+	for _, qt := range m.s.quotesTags {
+		quoteCounts[qt.Tag]++
+	}
+
+	tags := []TagWithCount{}
+	for _, tag := range m.s.tags {
+		if tag.LibraryID != p.LibraryID {
+			continue
+		}
+		if (p.Before && tag.ID >= p.BaseID) || (!p.Before && tag.ID <= p.BaseID) {
+			continue
+		}
+		tags = append(tags, TagWithCount{ID: tag.ID, Name: tag.Name, QuoteCount: quoteCounts[tag.ID]})
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		if p.Before {
+			return tags[i].ID > tags[j].ID
+		}
+		return tags[i].ID < tags[j].ID
+	})
+
+	if int32(len(tags)) > p.Limit {
+		tags = tags[:p.Limit]
+	}
+
+	return tags, nil
+}
+
+func (m *MemoryDB) GetTag(libraryID int64, name string) (*Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tag, ok := m.s.tagByName(libraryID, name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &tag, nil
+}
+
+func (m *MemoryDB) GetTagByID(libraryID int64, tagID int64) (*Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tag, ok := m.s.tags[tagID]
+	if !ok || tag.LibraryID != libraryID {
+		return nil, ErrNotFound
+	}
+	return &tag, nil
+}
+
+func (m *MemoryDB) RenameTag(libraryID int64, tagID int64, name string) (*Tag, error) {
+	var tag Tag
+	err := m.tx(func(s *memoryState) error {
+		var ok bool
+		if tag, ok = s.tags[tagID]; !ok || tag.LibraryID != libraryID {
+			return ErrNotFound
+		}
+		if existing, exists := s.tagByName(libraryID, name); exists && existing.ID != tagID {
+			return errMemoryUniqueViolation
+		}
+		tag.Name = name
+		tag.UpdatedAt = time.Now()
+		s.tags[tagID] = tag
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+func (m *MemoryDB) MergeTags(userID, libraryID int64, fromTagID int64, toTagID int64) error {
+	return m.tx(func(s *memoryState) error {
+		if tag, ok := s.tags[toTagID]; !ok || tag.LibraryID != libraryID {
+			return ErrNotFound
+		}
+		fromTag, ok := s.tags[fromTagID]
+		if !ok || fromTag.LibraryID != libraryID {
+			return ErrNotFound
+		}
+
+		quoteIDs, taggedQuoteIDs := s.tagQuoteIDs(libraryID, fromTagID), s.tagQuoteIDs(libraryID, toTagID)
+		taggedQuotes := map[int64]bool{}
+		for _, quoteID := range taggedQuoteIDs {
+			taggedQuotes[quoteID] = true
+		}
+		for _, quoteID := range quoteIDs {
+			if !taggedQuotes[quoteID] {
+				s.quotesTags = append(s.quotesTags, base.QuotesTag{LibraryID: libraryID, Tag: toTagID, Quote: quoteID})
+			}
+		}
+
+		s.deleteTag(libraryID, fromTagID)
+		_, err := s.createActivity(userID, libraryID, ActivityKindMergeTags, mergeTagsActivityData(fromTag.Name, toTagID, quoteIDs, taggedQuoteIDs))
+		return err
+	})
+}
+
+func (s *memoryState) tagQuoteIDs(libraryID int64, tagID int64) []int64 {
+	quoteIDs := []int64{}
+	for _, qt := range s.quotesTags {
+		if qt.LibraryID == libraryID && qt.Tag == tagID {
+			quoteIDs = append(quoteIDs, qt.Quote)
+		}
+	}
+	return quoteIDs
+}
+
+func (s *memoryState) unmergeTags(libraryID int64, data *ActivityMergeTagsData) {
+	tag, exists := s.tagByName(libraryID, data.FromTagName)
+	if !exists {
+		now := time.Now()
+		tag = base.Tag{ID: s.nextID(), Name: data.FromTagName, LibraryID: libraryID, CreatedAt: now, UpdatedAt: now}
+		s.tags[tag.ID] = tag
+	}
+
+	taggedQuotes := map[int64]bool{}
+	for _, qt := range s.quotesTags {
+		if qt.Tag == tag.ID {
+			taggedQuotes[qt.Quote] = true
+		}
+	}
+	for _, quoteID := range data.QuoteIDs {
+		if quote, ok := s.quotes[quoteID]; ok && quote.LibraryID == libraryID && !taggedQuotes[quoteID] {
+			s.quotesTags = append(s.quotesTags, base.QuotesTag{LibraryID: libraryID, Tag: tag.ID, Quote: quoteID})
+		}
+	}
+
+	addedQuotes := map[int64]bool{}
+	for _, quoteID := range data.AddedQuoteIDs {
+		addedQuotes[quoteID] = true
+	}
+	s.quotesTags = filterSlice(s.quotesTags, func(qt base.QuotesTag) bool {
+		return qt.LibraryID != libraryID || qt.Tag != data.ToTagID || !addedQuotes[qt.Quote]
+	})
+}
+
+func (m *MemoryDB) DeleteTag(libraryID int64, tagID int64) error {
+	return m.tx(func(s *memoryState) error {
+		s.deleteTag(libraryID, tagID)
+		return nil
+	})
+}
+
+func (s *memoryState) deleteTag(libraryID int64, tagID int64) {
+	s.quotesTags = filterSlice(s.quotesTags, func(qt base.QuotesTag) bool { return qt.LibraryID != libraryID || qt.Tag != tagID })
+	if tag, ok := s.tags[tagID]; ok && tag.LibraryID == libraryID {
+		delete(s.tags, tagID)
+	}
+}
+
+func (s *memoryState) tagByName(libraryID int64, name string) (base.Tag, bool) {
+	for _, tag := range s.tags {
+		if tag.LibraryID == libraryID && tag.Name == name {
+			return tag, true
+		}
+	}
+	return base.Tag{}, false
+}
+
+func (s *memoryState) deleteOrphanTags(libraryID int64) {
+	usedTags := map[int64]bool{}
+	for _, qt := range s.quotesTags {
+		usedTags[qt.Tag] = true
+	}
+	for id, tag := range s.tags {
+		if tag.LibraryID == libraryID && !usedTags[id] {
+			delete(s.tags, id)
+		}
+	}
+}
+
+/////////////////////// SOURCES ////////////////////////////
+
+func (m *MemoryDB) CreateSource(libraryID int64, name string) (*Source, error) {
+	var source Source
+	err := m.tx(func(s *memoryState) error {
+		var err error
+		source, err = s.createSource(libraryID, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &source, nil
+}
+
+func (m *MemoryDB) GetSource(libraryID int64, name string) (*Source, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	source, ok := m.s.sourceByName(libraryID, name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &source, nil
+}
+
+func (m *MemoryDB) GetSourceByID(libraryID int64, sourceID int64) (*Source, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	source, ok := m.s.sources[sourceID]
+	if !ok || source.LibraryID != libraryID {
+		return nil, ErrNotFound
+	}
+	return &source, nil
+}
+
+func (m *MemoryDB) GetLibrarySources(libraryID int64) ([]Source, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sources := []Source{}
+	for _, source := range m.s.sources {
+		if source.LibraryID == libraryID {
+			sources = append(sources, source)
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].ID < sources[j].ID })
+	return sources, nil
+}
+
+func (m *MemoryDB) SetSourceBook(libraryID int64, sourceID int64, sourceData *SourceBookData) (*Source, error) {
+	data, err := marshalSourceData(sourceData)
+	if err != nil {
+		return nil, err
+	}
+	return m.setSourceData(libraryID, sourceID, SourceKindBook, data)
+}
+
+func (m *MemoryDB) SetSourceArticle(libraryID int64, sourceID int64, sourceData *SourceArticleData) (*Source, error) {
+	data, err := marshalSourceData(sourceData)
+	if err != nil {
+		return nil, err
+	}
+	return m.setSourceData(libraryID, sourceID, SourceKindArticle, data)
+}
+
+func (m *MemoryDB) SetSourcePerson(libraryID int64, sourceID int64, sourceData *SourcePersonData) (*Source, error) {
+	data, err := marshalSourceData(sourceData)
+	if err != nil {
+		return nil, err
+	}
+	return m.setSourceData(libraryID, sourceID, SourceKindPerson, data)
+}
+
+func (m *MemoryDB) SetSourceUnknown(libraryID int64, sourceID int64) (*Source, error) {
+	return m.setSourceData(libraryID, sourceID, SourceKindUnknown, pgtype.JSON{Status: pgtype.Null})
+}
+
+func marshalSourceData[T any](sourceData *T) (pgtype.JSON, error) {
+	if sourceData == nil {
+		return pgtype.JSON{Status: pgtype.Null}, nil
+	}
+
+	dataBytes, err := json.Marshal(sourceData)
+	if err != nil {
+		return pgtype.JSON{}, err
+	}
+	return pgtype.JSON{Bytes: dataBytes, Status: pgtype.Present}, nil
+}
+
+func (m *MemoryDB) setSourceData(libraryID int64, sourceID int64, kind SourceKind, data pgtype.JSON) (*Source, error) {
+	var source Source
+	err := m.tx(func(s *memoryState) error {
+		var ok bool
+		if source, ok = s.sources[sourceID]; !ok || source.LibraryID != libraryID {
+			return ErrNotFound
+		}
+		source.Kind = kind
+		source.Data = data
+		s.sources[sourceID] = source
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &source, nil
+}
+
+func (m *MemoryDB) UpdateSource(userID, libraryID int64, source *Source) (*Source, error) {
+	if source == nil {
+		return nil, errors.New("source is nil")
+	}
+
+	var resSource Source
+	err := m.tx(func(s *memoryState) error {
+		oldSource, ok := s.sources[source.ID]
+		if !ok || oldSource.LibraryID != libraryID {
+			return ErrNotFound
+		}
+
+		var err error
+		if resSource, err = s.updateSource(libraryID, source); err != nil {
+			return err
+		}
+		_, err = s.createActivity(userID, libraryID, ActivityKindEditSource, editSourceActivityData(&oldSource))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &resSource, nil
+}
+
+func (s *memoryState) updateSource(libraryID int64, source *Source) (Source, error) {
+	resSource := s.sources[source.ID]
+	if other, exists := s.sourceByName(libraryID, source.Name); exists && other.ID != source.ID {
+		return Source{}, errMemoryUniqueViolation
+	}
+	if resSource.Name != source.Name {
+		s.replaceQuotesMainSource(libraryID, resSource.Name, nullString(source.Name))
+		for id, user := range s.users {
+			if user.LibraryID == libraryID && user.ActiveSource.Valid && user.ActiveSource.String == resSource.Name {
+				user.ActiveSource = nullString(source.Name)
+				s.users[id] = user
+			}
+		}
+	}
+
+	resSource.Name = source.Name
+	resSource.Kind = source.Kind
+	resSource.Data = source.Data
+	resSource.UpdatedAt = time.Now()
+	s.sources[source.ID] = resSource
+	return resSource, nil
+}
+
+func (m *MemoryDB) DeleteSource(libraryID int64, sourceID int64) error {
+	return m.tx(func(s *memoryState) error {
+		source, ok := s.sources[sourceID]
+		if !ok || source.LibraryID != libraryID {
+			return ErrNotFound
+		}
+
+		s.replaceQuotesMainSource(libraryID, source.Name, sql.NullString{})
+		for id, user := range s.users {
+			if user.LibraryID == libraryID && user.ActiveSource.Valid && user.ActiveSource.String == source.Name {
+				user.ActiveSource = sql.NullString{}
+				user.ActiveSourceExpire = sql.NullTime{}
+				s.users[id] = user
+			}
+		}
+
+		s.deleteSource(libraryID, sourceID)
+		return nil
+	})
+}
+
+func (m *MemoryDB) MergeSources(libraryID int64, fromSourceID int64, toSourceID int64) error {
+	return m.tx(func(s *memoryState) error {
+		fromSource, ok := s.sources[fromSourceID]
+		if !ok || fromSource.LibraryID != libraryID {
+			return ErrNotFound
+		}
+		toSource, ok := s.sources[toSourceID]
+		if !ok || toSource.LibraryID != libraryID {
+			return ErrNotFound
+		}
+
+		sourcedQuotes := map[int64]bool{}
+		for _, qs := range s.quotesSources {
+			if qs.Source == toSourceID {
+				sourcedQuotes[qs.Quote] = true
+			}
+		}
+		for _, qs := range s.quotesSources {
+			if qs.LibraryID == libraryID && qs.Source == fromSourceID && !sourcedQuotes[qs.Quote] {
+				s.quotesSources = append(s.quotesSources, base.QuotesSource{LibraryID: libraryID, Source: toSourceID, Quote: qs.Quote})
+			}
+		}
+
+		s.replaceQuotesMainSource(libraryID, fromSource.Name, nullString(toSource.Name))
+		for id, user := range s.users {
+			if user.LibraryID == libraryID && user.ActiveSource.Valid && user.ActiveSource.String == fromSource.Name {
+				user.ActiveSource = nullString(toSource.Name)
+				s.users[id] = user
+			}
+		}
+
+		s.deleteSource(libraryID, fromSourceID)
+		return nil
+	})
+}
+
+func (s *memoryState) replaceQuotesMainSource(libraryID int64, oldMainSource string, newMainSource sql.NullString) {
+	now := time.Now()
+	for id, quote := range s.quotes {
+		if quote.LibraryID == libraryID && quote.MainSource.Valid && quote.MainSource.String == oldMainSource {
+			quote.MainSource = newMainSource
+			quote.UpdatedAt = now
+			s.quotes[id] = quote
+		}
+	}
+}
+
+func (s *memoryState) deleteSource(libraryID int64, sourceID int64) {
+	s.quotesSources = filterSlice(s.quotesSources, func(qs base.QuotesSource) bool { return qs.LibraryID != libraryID || qs.Source != sourceID })
+	if source, ok := s.sources[sourceID]; ok && source.LibraryID == libraryID {
+		delete(s.sources, sourceID)
+	}
+}
+
+func (m *MemoryDB) QuerySources(p QuerySourcesParams) ([]Source, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sources := []Source{}
+	for _, source := range m.s.sources {
+		if source.LibraryID != p.LibraryID || !strings.Contains(source.Name, p.NameQuery) {
+			continue
+		}
+		if p.SourceKind != "" && string(source.Kind) != p.SourceKind {
+			continue
+		}
+		if (p.Before && source.ID >= p.BaseID) || (!p.Before && source.ID <= p.BaseID) {
+			continue
+		}
+		sources = append(sources, source)
+	}
+
+	sort.Slice(sources, func(i, j int) bool {
+		if p.Before {
+			return sources[i].ID > sources[j].ID
+		}
+		return sources[i].ID < sources[j].ID
+	})
+
+	if int32(len(sources)) > p.Limit {
+		sources = sources[:p.Limit]
+	}
+
+	return sources, nil
+}
+
+func (m *MemoryDB) SetActiveSource(userID int64, activeSourceStr string, activeSourceExpireTime time.Time) (*User, error) {
+	return m.updateUser(userID, func(user *User) {
+		user.ActiveSource = sql.NullString{Valid: true, String: activeSourceStr}
+		user.ActiveSourceExpire = sql.NullTime{Valid: true, Time: activeSourceExpireTime}
+	})
+}
+
+func (m *MemoryDB) DeactivateExpiredSources() ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	users := []User{}
+	for id, user := range m.s.users {
+		if !user.ActiveSourceExpire.Valid || user.ActiveSourceExpire.Time.After(now) {
+			continue
+		}
+		user.ActiveSource = sql.NullString{}
+		user.ActiveSourceExpire = sql.NullTime{}
+		m.s.users[id] = user
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+func (m *MemoryDB) DeactivateSource(userID int64) (*User, error) {
+	return m.updateUser(userID, func(user *User) {
+		user.ActiveSource = sql.NullString{}
+		user.ActiveSourceExpire = sql.NullTime{}
+	})
+}
+
+func (m *MemoryDB) SetUserQuoteSyntax(userID int64, syntax *QuoteSyntax) (*User, error) {
+	quoteSyntax := pgtype.JSON{Status: pgtype.Null}
+	if syntax != nil {
+		syntaxBytes, err := json.Marshal(syntax)
+		if err != nil {
+			return nil, err
+		}
+		quoteSyntax = pgtype.JSON{Bytes: syntaxBytes, Status: pgtype.Present}
+	}
+
+	return m.updateUser(userID, func(user *User) {
+		user.QuoteSyntax = quoteSyntax
+	})
+}
+
+func (m *MemoryDB) SetUserConfirmQuotes(userID int64, confirmQuotes bool) (*User, error) {
+	return m.updateUser(userID, func(user *User) {
+		user.ConfirmQuotes = confirmQuotes
+	})
+}
+
+func (s *memoryState) sourceByName(libraryID int64, name string) (Source, bool) {
+	for _, source := range s.sources {
+		if source.LibraryID == libraryID && source.Name == name {
+			return source, true
+		}
+	}
+	return Source{}, false
+}
+
+func (s *memoryState) createSource(libraryID int64, name string) (Source, error) {
+	if _, ok := s.libraries[libraryID]; !ok {
+		return Source{}, errMemoryForeignKeyViolation
+	}
+	if _, exists := s.sourceByName(libraryID, name); exists {
+		return Source{}, errMemoryUniqueViolation
+	}
+
+	now := time.Now()
+	source := Source{
+		ID:        s.nextID(),
+		Name:      name,
+		LibraryID: libraryID,
+		Kind:      SourceKindUnknown,
+		Data:      pgtype.JSON{Status: pgtype.Null},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.sources[source.ID] = source
+	return source, nil
+}
+
+/////////////////////// OUTPUTS ////////////////////////////
+
+func (m *MemoryDB) GetOutputs(userID int64) ([]Output, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.s.userOutputs(userID, false), nil
+}
+
+func (m *MemoryDB) GetActiveOutputs(userID int64) ([]Output, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.s.userOutputs(userID, true), nil
+}
+
+func (m *MemoryDB) GetOutput(userID int64, outputChatID int64) (*Output, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	output, ok := m.s.output(userID, outputChatID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &output, nil
+}
+
+func (m *MemoryDB) GetOrCreateOutput(userID int64, chatID int64, chatTitle string) (*Output, bool, error) {
+	var output Output
+	var created bool
+	err := m.tx(func(s *memoryState) error {
+		var ok bool
+		if output, ok = s.output(userID, chatID); ok {
+			return nil
+		}
+		if _, ok = s.users[userID]; !ok {
+			return errMemoryForeignKeyViolation
+		}
+
+		now := time.Now()
+		output = Output{
+			ID:        s.nextID(),
+			ChatID:    chatID,
+			UserID:    userID,
+			Title:     chatTitle,
+			CreatedAt: now,
+			UpdatedAt: now,
+			Filters:   pgtype.JSON{Status: pgtype.Null},
+		}
+		s.outputs[output.ID] = output
+		created = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &output, created, nil
+}
+
+func (m *MemoryDB) ActivateOutput(userID int64, outputChatID int64) (*Output, error) {
+	return m.updateOutput(userID, outputChatID, func(output *Output) {
+		output.IsActive = true
+	})
+}
+
+func (m *MemoryDB) DeactivateOutput(userID int64, outputChatID int64) (*Output, error) {
+	return m.updateOutput(userID, outputChatID, func(output *Output) {
+		output.IsActive = false
+	})
+}
+
+func (m *MemoryDB) SetOutputFilters(userID int64, outputChatID int64, filters *OutputFilters) (*Output, error) {
+	filtersBytes, err := json.Marshal(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.updateOutput(userID, outputChatID, func(output *Output) {
+		output.Filters = pgtype.JSON{Bytes: filtersBytes, Status: pgtype.Present}
+		output.UpdatedAt = time.Now()
+	})
+}
+
+func (m *MemoryDB) DeleteOutput(userID int64, outputChatID int64) error {
+	return m.tx(func(s *memoryState) error {
+		if output, ok := s.output(userID, outputChatID); ok {
+			delete(s.outputs, output.ID)
+		}
+		return nil
+	})
+}
+
+func (m *MemoryDB) updateOutput(userID int64, outputChatID int64, update func(output *Output)) (*Output, error) {
+	var output Output
+	err := m.tx(func(s *memoryState) error {
+		var ok bool
+		if output, ok = s.output(userID, outputChatID); !ok {
+			return ErrNotFound
+		}
+		update(&output)
+		s.outputs[output.ID] = output
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
+
+func (s *memoryState) output(userID int64, chatID int64) (Output, bool) {
+	for _, output := range s.outputs {
+		if output.UserID == userID && output.ChatID == chatID {
+			return output, true
+		}
+	}
+	return Output{}, false
+}
+
+func (s *memoryState) userOutputs(userID int64, onlyActive bool) []Output {
+	outputs := []Output{}
+	for _, output := range s.outputs {
+		if output.UserID == userID && (output.IsActive || !onlyActive) {
+			outputs = append(outputs, output)
+		}
+	}
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].ID < outputs[j].ID })
+	return outputs
+}
+
+/////////////////////// DIGESTS ////////////////////////////
+
+func (m *MemoryDB) GetDigest(userID int64) (*Digest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	digest, ok := m.s.digests[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &digest, nil
+}
+
+func (m *MemoryDB) GetActiveDigests() ([]Digest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	digests := []Digest{}
+	for _, digest := range m.s.digests {
+		if digest.IsActive {
+			digests = append(digests, digest)
+		}
+	}
+	sort.Slice(digests, func(i, j int) bool { return digests[i].UserID < digests[j].UserID })
+	return digests, nil
+}
+
+func (m *MemoryDB) SetDigest(userID int64, settings *DigestSettings) (*Digest, error) {
+	filtersBytes, err := json.Marshal(&settings.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	var digest Digest
+	err = m.tx(func(s *memoryState) error {
+		if _, ok := s.users[userID]; !ok {
+			return errMemoryForeignKeyViolation
+		}
+
+		now := time.Now()
+		var ok bool
+		if digest, ok = s.digests[userID]; !ok {
+			digest = Digest{UserID: userID, CreatedAt: now}
+		}
+		digest.IsActive = settings.IsActive
+		digest.SendAt = settings.SendAt
+		digest.Timezone = settings.Timezone
+		digest.Count = settings.Count
+		digest.OutputChatID = settings.OutputChatID
+		digest.Filters = pgtype.JSON{Bytes: filtersBytes, Status: pgtype.Present}
+		digest.UpdatedAt = now
+		s.digests[userID] = digest
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &digest, nil
+}
+
+func (m *MemoryDB) SetDigestLastSentAt(userID int64, lastSentAt time.Time) (*Digest, error) {
+	var digest Digest
+	err := m.tx(func(s *memoryState) error {
+		var ok bool
+		if digest, ok = s.digests[userID]; !ok {
+			return ErrNotFound
+		}
+		digest.LastSentAt = sql.NullTime{Valid: true, Time: lastSentAt}
+		s.digests[userID] = digest
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &digest, nil
+}
+
+/////////////////////// REVIEWS ////////////////////////////
+
+type reviewCardKey struct {
+	UserID  int64
+	QuoteID int64
+}
+
+func (m *MemoryDB) SyncReviewCards(userID int64, libraryID int64) error {
+	return m.tx(func(s *memoryState) error {
+		if _, ok := s.users[userID]; !ok {
+			return errMemoryForeignKeyViolation
+		}
+
+		s.syncReviewCards(userID, libraryID, time.Now())
+		return nil
+	})
+}
+
+func (m *MemoryDB) SyncAllReviewCards() error {
+	return m.tx(func(s *memoryState) error {
+		now := time.Now()
+		reviewers := map[int64]bool{}
+		for key := range s.reviewCards {
+			reviewers[key.UserID] = true
+		}
+		for _, user := range s.users {
+			if !reviewers[user.ID] {
+				continue
+			}
+			s.syncReviewCards(user.ID, user.LibraryID, now)
+		}
+		return nil
+	})
+}
+
+func (s *memoryState) syncReviewCards(userID int64, libraryID int64, now time.Time) {
+	for _, quote := range s.quotes {
+		key := reviewCardKey{UserID: userID, QuoteID: quote.ID}
+		if _, exists := s.reviewCards[key]; quote.LibraryID != libraryID || exists {
+			continue
+		}
+		s.reviewCards[key] = ReviewCard{
+			UserID:     userID,
+			QuoteID:    quote.ID,
+			EaseFactor: DEFAULT_REVIEW_EASE_FACTOR,
+			DueAt:      now,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+	}
+}
+
+func (m *MemoryDB) GetDueReviewCard(userID int64, libraryID int64) (*ReviewCard, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cards := m.s.dueReviewCards(userID, libraryID, time.Now())
+	if len(cards) == 0 {
+		return nil, ErrNotFound
+	}
+	return &cards[0], nil
+}
+
+func (m *MemoryDB) CountDueReviewCards(userID int64, libraryID int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.s.dueReviewCards(userID, libraryID, time.Now()))), nil
+}
+
+func (m *MemoryDB) GetReviewCard(userID int64, libraryID int64, quoteID int64) (*ReviewCard, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	card, ok := m.s.reviewCards[reviewCardKey{UserID: userID, QuoteID: quoteID}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if quote, ok := m.s.quotes[quoteID]; !ok || quote.LibraryID != libraryID {
+		return nil, ErrNotFound
+	}
+	return &card, nil
+}
+
+func (m *MemoryDB) UpdateReviewCard(userID int64, quoteID int64, schedule *ReviewSchedule) (*ReviewCard, error) {
+	var card ReviewCard
+	err := m.tx(func(s *memoryState) error {
+		key := reviewCardKey{UserID: userID, QuoteID: quoteID}
+		var ok bool
+		if card, ok = s.reviewCards[key]; !ok {
+			return ErrNotFound
+		}
+
+		now := time.Now()
+		card.Repetitions = schedule.Repetitions
+		card.IntervalDays = schedule.IntervalDays
+		card.EaseFactor = schedule.EaseFactor
+		card.DueAt = schedule.DueAt
+		card.LastReviewedAt = sql.NullTime{Valid: true, Time: now}
+		card.UpdatedAt = now
+		s.reviewCards[key] = card
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &card, nil
+}
+
+func (m *MemoryDB) GetUsersToRemindReview(remindedAfter time.Time) ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	users := []User{}
+	for _, user := range m.s.users {
+		if remindedAt, ok := m.s.reminders[user.ID]; ok && remindedAt.After(remindedAfter) {
+			continue
+		}
+		if len(m.s.dueReviewCards(user.ID, user.LibraryID, now)) != 0 {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (m *MemoryDB) SetReviewReminded(userID int64, remindedAt time.Time) error {
+	return m.tx(func(s *memoryState) error {
+		if _, ok := s.users[userID]; !ok {
+			return errMemoryForeignKeyViolation
+		}
+		s.reminders[userID] = remindedAt
+		return nil
+	})
+}
+
+func (s *memoryState) dueReviewCards(userID int64, libraryID int64, now time.Time) []ReviewCard {
+	cards := []ReviewCard{}
+	for key, card := range s.reviewCards {
+		if key.UserID != userID || card.DueAt.After(now) {
+			continue
+		}
+		if quote, ok := s.quotes[key.QuoteID]; ok && quote.LibraryID == libraryID {
+			cards = append(cards, card)
+		}
+	}
+	sort.Slice(cards, func(i, j int) bool {
+		if cards[i].DueAt.Equal(cards[j].DueAt) {
+			return cards[i].QuoteID < cards[j].QuoteID
+		}
+		return cards[i].DueAt.Before(cards[j].DueAt)
+	})
+	return cards
+}
+
+func (s *memoryState) deleteQuoteReviewCards(quoteID int64) {
+	for key := range s.reviewCards {
+		if key.QuoteID == quoteID {
+			delete(s.reviewCards, key)
+		}
+	}
+}
+
+/////////////////////// ACTIVITIES ////////////////////////////
+
+func (s *memoryState) createActivity(userID, libraryID int64, kind ActivityKind, data any) (Activity, error) {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return Activity{}, err
+	}
+
+	if _, ok := s.users[userID]; !ok {
+		return Activity{}, errMemoryForeignKeyViolation
+	}
+	if _, ok := s.libraries[libraryID]; !ok {
+		return Activity{}, errMemoryForeignKeyViolation
+	}
+	activity := Activity{
+		ID:        s.nextID(),
+		UserID:    userID,
+		LibraryID: libraryID,
+		Kind:      kind,
+		Data:      pgtype.JSON{Bytes: dataBytes, Status: pgtype.Present},
+		CreatedAt: time.Now(),
+	}
+	s.activities[activity.ID] = activity
+	return activity, nil
+}
+
+func (m *MemoryDB) GetLastActivity(userID, libraryID int64, after time.Time) (*Activity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var last *Activity
+	for _, activity := range m.s.activities {
+		if activity.UserID != userID || activity.LibraryID != libraryID || activity.UndoneAt.Valid || !activity.CreatedAt.After(after) {
+			continue
+		}
+		if last == nil || activity.ID > last.ID {
+			a := activity
+			last = &a
+		}
+	}
+	if last == nil {
+		return nil, ErrNotFound
+	}
+	return last, nil
+}
+
+func (m *MemoryDB) UndoActivity(libraryID int64, activity *Activity) (bool, error) {
+	var reverted bool
+	err := m.tx(func(s *memoryState) error {
+		stored, ok := s.activities[activity.ID]
+		if !ok || stored.UndoneAt.Valid {
+			return ErrNotFound
+		}
+		stored.UndoneAt = sql.NullTime{Valid: true, Time: time.Now()}
+		s.activities[activity.ID] = stored
+
+		switch activity.Kind {
+		case ActivityKindCreateQuote:
+			var data ActivityCreateQuoteData
+			if err := json.Unmarshal(activity.Data.Bytes, &data); err != nil {
+				return err
+			}
+			if quote, ok := s.quotes[data.QuoteID]; ok && quote.LibraryID == libraryID {
+				s.deleteQuote(libraryID, data.QuoteID)
+				reverted = true
+			}
+		case ActivityKindEditSource:
+			var data ActivityEditSourceData
+			if err := json.Unmarshal(activity.Data.Bytes, &data); err != nil {
+				return err
+			}
+			reverted = s.undoSourceEdit(libraryID, &data)
+		case ActivityKindMergeTags:
+			var data ActivityMergeTagsData
+			if err := json.Unmarshal(activity.Data.Bytes, &data); err != nil {
+				return err
+			}
+			s.unmergeTags(libraryID, &data)
+			reverted = true
+		default:
+			return errors.New("unknown activity kind")
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return reverted, nil
+}
+
+func (s *memoryState) undoSourceEdit(libraryID int64, data *ActivityEditSourceData) bool {
+	source, ok := s.sources[data.SourceID]
+	if !ok || source.LibraryID != libraryID {
+		return false
+	}
+	if existing, exists := s.sourceByName(libraryID, data.Name); exists && existing.ID != source.ID {
+		return false
+	}
+
+	source.Name, source.Kind, source.Data = data.Name, data.Kind, pgtype.JSON{Status: pgtype.Null}
+	if len(data.Data) != 0 {
+		source.Data = pgtype.JSON{Bytes: data.Data, Status: pgtype.Present}
+	}
+	// the old name is checked above, so updating can not fail
+	_, err := s.updateSource(libraryID, &source)
+	return err == nil
+}
+
+/////////////////////// UTILS ////////////////////////////
+
+func (s *memoryState) nextID() int64 {
+	s.lastID++
+	return s.lastID
+}
+
+func filterSlice[T any](items []T, keep func(item T) bool) []T {
+	res := make([]T, 0, len(items))
+	for _, item := range items {
+		if keep(item) {
+			res = append(res, item)
+		}
+	}
+	return res
+}
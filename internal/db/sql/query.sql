@@ -30,9 +30,25 @@ UPDATE users SET
   active_source_expire = NULL
 WHERE id = $1 RETURNING *;
 
+-- name: RenameActiveSourceInLibrary :exec
+UPDATE users SET active_source = sqlc.arg(new_name)::TEXT
+WHERE library_id = sqlc.arg(library_id) AND active_source = sqlc.arg(old_name)::TEXT;
+
+-- name: DeactivateSourceInLibrary :exec
+UPDATE users SET
+  active_source = NULL,
+  active_source_expire = NULL
+WHERE library_id = $1 AND active_source = $2;
+
 -- name: SetUserLibrary :one
 UPDATE users SET library_id = $1 WHERE id = $2 RETURNING *;
 
+-- name: SetUserQuoteSyntax :one
+UPDATE users SET quote_syntax = $2 WHERE id = $1 RETURNING *;
+
+-- name: SetUserConfirmQuotes :one
+UPDATE users SET confirm_quotes = $2 WHERE id = $1 RETURNING *;
+
 --------- LIBRARIES ----------
 
 -- name: GetLibrary :one
@@ -50,16 +66,55 @@ UPDATE libraries SET token = $1, token_expires_on = $2 WHERE id = $3 RETURNING *
 -- name: DeleteLibrary :exec
 DELETE FROM libraries WHERE id = $1;
 
+-- name: SetLibrarySearchLanguage :one
+UPDATE libraries SET search_language = $1, updated_at = NOW() WHERE id = $2 RETURNING *;
+
 ---------- QUOTES ------------
 
 -- name: CreateQuote :one
-INSERT INTO quotes (library_id, text, main_source) VALUES ($1, $2, $3) RETURNING id, text, library_id, main_source, created_at, updated_at;
+INSERT INTO quotes (library_id, text, main_source, search_config)
+VALUES ($1, $2, $3, (SELECT search_language FROM libraries WHERE id = $1)::REGCONFIG)
+RETURNING id, text, library_id, main_source, created_at, updated_at;
+
+-- name: GetQuote :one
+SELECT id, text, library_id, main_source, origin_url, text_entities, location, note, created_at, updated_at FROM quotes WHERE library_id = $1 AND id = $2;
+
+-- name: GetQuoteByText :one
+SELECT id, text, library_id, main_source, origin_url, text_entities, location, note, created_at, updated_at FROM quotes WHERE library_id = $1 AND text = $2;
+
+-- name: UpdateQuote :one
+UPDATE quotes SET text = $1, main_source = $2, text_entities = NULL, updated_at = NOW() WHERE library_id = $3 AND id = $4 RETURNING id, text, library_id, main_source, origin_url, text_entities, location, note, created_at, updated_at;
+
+-- name: SetQuoteOriginURL :exec
+UPDATE quotes SET origin_url = $1, updated_at = NOW() WHERE library_id = $2 AND id = $3;
+
+-- name: SetQuoteDetails :exec
+UPDATE quotes SET location = $1, note = $2, updated_at = NOW() WHERE library_id = $3 AND id = $4;
+
+-- name: SetQuoteTextEntities :exec
+UPDATE quotes SET text_entities = $1, updated_at = NOW() WHERE library_id = $2 AND id = $3;
+
+-- name: DeleteQuote :exec
+DELETE FROM quotes WHERE library_id = $1 AND id = $2;
 
--- name: SearchQuotes :many
-SELECT id, text, main_source, library_id, created_at, updated_at FROM quotes WHERE library_id = $1 AND text_tokens @@ TO_TSQUERY('english', $2) LIMIT $3;
+-- name: GetExistingQuoteTexts :many
+SELECT text FROM quotes WHERE library_id = sqlc.arg(library_id) AND text = ANY(sqlc.arg(texts)::TEXT[]);
+
+-- name: GetLibraryQuotes :many
+SELECT id, text, library_id, main_source, origin_url, text_entities, location, note, created_at, updated_at FROM quotes WHERE library_id = $1 ORDER BY id ASC;
+
+-- name: ReplaceQuotesMainSource :exec
+UPDATE quotes SET main_source = sqlc.arg(new_main_source), updated_at = NOW()
+WHERE library_id = sqlc.arg(library_id) AND main_source = sqlc.arg(old_main_source)::TEXT;
 
 -- name: SetQuotesLibrary :exec
-UPDATE quotes SET library_id = $1 WHERE library_id = $2;
+UPDATE quotes SET
+  library_id = $1,
+  search_config = (SELECT search_language FROM libraries WHERE id = $1)::REGCONFIG
+WHERE library_id = $2;
+
+-- name: SetLibraryQuotesSearchConfig :exec
+UPDATE quotes SET search_config = sqlc.arg(search_language)::TEXT::REGCONFIG WHERE library_id = sqlc.arg(library_id);
 
 -- name: DeleteQuotesInLibrary :exec
 DELETE FROM quotes WHERE library_id = $1;
@@ -69,6 +124,9 @@ DELETE FROM quotes WHERE library_id = $1;
 -- name: GetSource :one
 SELECT * FROM sources WHERE library_id = $1 AND name = $2;
 
+-- name: GetLibrarySources :many
+SELECT * FROM sources WHERE library_id = $1 ORDER BY id ASC;
+
 -- name: CreateSource :one
 INSERT INTO sources (library_id, name) VALUES ($1, $2) RETURNING *;
 
@@ -95,9 +153,15 @@ SELECT * FROM sources WHERE library_id = $1 AND id < $2 AND kind = $3 AND name L
 -- name: SetSourceData :one
 UPDATE sources SET kind = $1, data = $2  WHERE library_id = $3 AND id = $4 RETURNING *;
 
+-- name: SetSourceDataIfUnknown :exec
+UPDATE sources SET kind = $1, data = $2 WHERE library_id = $3 AND id = $4 AND kind = 'unknown';
+
 -- name: UpdateSource :one
 UPDATE sources SET name = $1, kind = $2, data = $3, updated_at = NOW() WHERE library_id = $4 AND id = $5 RETURNING *;
 
+-- name: DeleteSource :exec
+DELETE FROM sources WHERE library_id = $1 AND id = $2;
+
 -- name: SetSourcesLibrary :exec
 UPDATE sources SET library_id = $1 WHERE library_id = $2;
 
@@ -112,6 +176,9 @@ INSERT INTO outputs (user_id, chat_id, title) VALUES ($1, $2, $3) RETURNING *;
 -- name: GetOutputs :many
 SELECT * FROM outputs WHERE user_id = $1;
 
+-- name: GetActiveOutputs :many
+SELECT * FROM outputs WHERE user_id = $1 AND is_active = TRUE;
+
 -- name: GetOutput :one
 SELECT * FROM outputs WHERE user_id = $1 AND chat_id = $2;
 
@@ -125,9 +192,86 @@ UPDATE outputs SET
   is_active = FALSE
 WHERE chat_id = $1 AND user_id = $2 RETURNING *;
 
+-- name: SetOutputFilters :one
+UPDATE outputs SET
+  filters = $1,
+  updated_at = NOW()
+WHERE chat_id = $2 AND user_id = $3 RETURNING *;
+
 -- name: DeleteOutput :exec
 DELETE FROM outputs WHERE user_id = $1 AND chat_id = $2;
 
+---------- DIGESTS -----------
+
+-- name: GetDigest :one
+SELECT * FROM digests WHERE user_id = $1;
+
+-- name: GetActiveDigests :many
+SELECT * FROM digests WHERE is_active = TRUE;
+
+-- name: SetDigest :one
+INSERT INTO digests (user_id, is_active, send_at, timezone, count, output_chat_id, filters) VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (user_id) DO UPDATE SET
+  is_active = EXCLUDED.is_active,
+  send_at = EXCLUDED.send_at,
+  timezone = EXCLUDED.timezone,
+  count = EXCLUDED.count,
+  output_chat_id = EXCLUDED.output_chat_id,
+  filters = EXCLUDED.filters,
+  updated_at = NOW()
+RETURNING *;
+
+-- name: SetDigestLastSentAt :one
+UPDATE digests SET last_sent_at = $1 WHERE user_id = $2 RETURNING *;
+
+---------- REVIEWS -----------
+
+-- name: CreateMissingReviewCards :exec
+INSERT INTO review_cards (user_id, quote_id)
+SELECT sqlc.arg(user_id)::BIGINT, quotes.id FROM quotes WHERE quotes.library_id = sqlc.arg(library_id)
+ON CONFLICT DO NOTHING;
+
+-- name: CreateAllMissingReviewCards :exec
+INSERT INTO review_cards (user_id, quote_id)
+SELECT users.id, quotes.id FROM users INNER JOIN quotes ON quotes.library_id = users.library_id
+WHERE EXISTS (SELECT 1 FROM review_cards WHERE review_cards.user_id = users.id)
+ON CONFLICT DO NOTHING;
+
+-- name: GetDueReviewCard :one
+SELECT review_cards.* FROM review_cards INNER JOIN quotes ON quotes.id = review_cards.quote_id
+WHERE review_cards.user_id = $1 AND quotes.library_id = $2 AND review_cards.due_at <= NOW()
+ORDER BY review_cards.due_at ASC, review_cards.quote_id ASC LIMIT 1;
+
+-- name: CountDueReviewCards :one
+SELECT COUNT(*) FROM review_cards INNER JOIN quotes ON quotes.id = review_cards.quote_id
+WHERE review_cards.user_id = $1 AND quotes.library_id = $2 AND review_cards.due_at <= NOW();
+
+-- name: GetReviewCard :one
+SELECT review_cards.* FROM review_cards INNER JOIN quotes ON quotes.id = review_cards.quote_id
+WHERE review_cards.user_id = $1 AND quotes.library_id = $2 AND review_cards.quote_id = $3;
+
+-- name: UpdateReviewCard :one
+UPDATE review_cards SET
+  repetitions = $1,
+  interval_days = $2,
+  ease_factor = $3,
+  due_at = $4,
+  last_reviewed_at = NOW(),
+  updated_at = NOW()
+WHERE user_id = $5 AND quote_id = $6 RETURNING *;
+
+-- name: GetUsersToRemindReview :many
+SELECT * FROM users WHERE EXISTS (
+  SELECT 1 FROM review_cards INNER JOIN quotes ON quotes.id = review_cards.quote_id
+  WHERE review_cards.user_id = users.id AND quotes.library_id = users.library_id AND review_cards.due_at <= NOW()
+) AND NOT EXISTS (
+  SELECT 1 FROM review_reminders WHERE review_reminders.user_id = users.id AND review_reminders.reminded_at > $1
+);
+
+-- name: SetReviewReminded :exec
+INSERT INTO review_reminders (user_id, reminded_at) VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE SET reminded_at = EXCLUDED.reminded_at;
+
 ----------- TAGS -------------
 
 -- name: GetOrCreateTag :one
@@ -135,6 +279,42 @@ WITH created_id AS (
   INSERT INTO tags (library_id, name) VALUES ($1, $2) ON CONFLICT DO NOTHING RETURNING id
 ) SELECT id FROM created_id UNION ALL SELECT id FROM tags WHERE library_id = $1 AND name = $2 LIMIT 1;
 
+-- name: GetTagByID :one
+SELECT * FROM tags WHERE library_id = $1 AND id = $2;
+
+-- name: GetTagByName :one
+SELECT * FROM tags WHERE library_id = $1 AND name = $2;
+
+-- name: QueryTagsAfter :many
+SELECT tags.id, tags.name, COUNT(quotes_tags.quote) AS quote_count FROM tags
+LEFT JOIN quotes_tags ON quotes_tags.tag = tags.id
+WHERE tags.library_id = $1 AND tags.id > $2
+GROUP BY tags.id ORDER BY tags.id ASC LIMIT $3;
+
+-- name: QueryTagsBefore :many
+SELECT tags.id, tags.name, COUNT(quotes_tags.quote) AS quote_count FROM tags
+LEFT JOIN quotes_tags ON quotes_tags.tag = tags.id
+WHERE tags.library_id = $1 AND tags.id < $2
+GROUP BY tags.id ORDER BY tags.id DESC LIMIT $3;
+
+-- name: RenameTag :one
+UPDATE tags SET name = $1, updated_at = NOW() WHERE library_id = $2 AND id = $3 RETURNING *;
+
+-- name: DeleteTag :exec
+DELETE FROM tags WHERE library_id = $1 AND id = $2;
+
+-- name: GetQuoteTagNames :many
+SELECT tags.name FROM tags INNER JOIN quotes_tags ON quotes_tags.tag = tags.id WHERE quotes_tags.library_id = $1 AND quotes_tags.quote = $2;
+
+-- name: GetTagQuoteIDs :many
+SELECT quote FROM quotes_tags WHERE library_id = $1 AND tag = $2;
+
+-- name: GetLibraryQuotesTags :many
+SELECT quotes_tags.quote, tags.name FROM tags INNER JOIN quotes_tags ON quotes_tags.tag = tags.id WHERE quotes_tags.library_id = $1;
+
+-- name: DeleteOrphanTagsInLibrary :exec
+DELETE FROM tags WHERE library_id = $1 AND NOT EXISTS (SELECT 1 FROM quotes_tags WHERE quotes_tags.tag = tags.id);
+
 -- name: DeleteTagsInLibrary :exec
 DELETE FROM tags WHERE library_id = $1;
 
@@ -146,6 +326,30 @@ UPDATE tags SET library_id = $1 WHERE library_id = $2;
 -- name: CreateQuotesTags :exec
 INSERT INTO quotes_tags (quote, tag, library_id) VALUES ($1, $2, $3);
 
+-- name: CopyQuotesTags :copyfrom
+INSERT INTO quotes_tags (quote, tag, library_id) VALUES ($1, $2, $3);
+
+-- name: DeleteQuotesTagsOfQuote :exec
+DELETE FROM quotes_tags WHERE library_id = $1 AND quote = $2;
+
+-- name: MoveQuotesTagsToTag :exec
+INSERT INTO quotes_tags (quote, tag, library_id)
+SELECT quotes_tags.quote, sqlc.arg(to_tag)::BIGINT, quotes_tags.library_id FROM quotes_tags
+WHERE quotes_tags.library_id = sqlc.arg(library_id) AND quotes_tags.tag = sqlc.arg(from_tag)::BIGINT
+ON CONFLICT DO NOTHING;
+
+-- name: CreateQuotesTagsOfQuotes :exec
+INSERT INTO quotes_tags (quote, tag, library_id)
+SELECT quotes.id, sqlc.arg(tag)::BIGINT, quotes.library_id FROM quotes
+WHERE quotes.library_id = sqlc.arg(library_id) AND quotes.id = ANY(sqlc.arg(quote_ids)::BIGINT[])
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteQuotesTagsOfQuotes :exec
+DELETE FROM quotes_tags WHERE library_id = sqlc.arg(library_id) AND tag = sqlc.arg(tag) AND quote = ANY(sqlc.arg(quote_ids)::BIGINT[]);
+
+-- name: DeleteQuotesTagsOfTag :exec
+DELETE FROM quotes_tags WHERE library_id = $1 AND tag = $2;
+
 -- name: DeleteQuotesTagsInLibrary :exec
 DELETE FROM quotes_tags WHERE library_id = $1;
 
@@ -155,14 +359,62 @@ UPDATE quotes_tags SET library_id = $1 WHERE library_id = $2;
 -- name: CreateQuotesSources :exec
 INSERT INTO quotes_sources (quote, source, library_id) VALUES ($1, $2, $3);
 
+-- name: CopyQuotesSources :copyfrom
+INSERT INTO quotes_sources (quote, source, library_id) VALUES ($1, $2, $3);
+
+-- name: GetQuoteSourceNames :many
+SELECT sources.name FROM sources INNER JOIN quotes_sources ON quotes_sources.source = sources.id WHERE quotes_sources.library_id = $1 AND quotes_sources.quote = $2;
+
+-- name: GetQuoteSources :many
+SELECT sources.* FROM sources INNER JOIN quotes_sources ON quotes_sources.source = sources.id WHERE quotes_sources.library_id = $1 AND quotes_sources.quote = $2;
+
+-- name: GetLibraryQuotesSources :many
+SELECT quotes_sources.quote, sources.name FROM sources INNER JOIN quotes_sources ON quotes_sources.source = sources.id WHERE quotes_sources.library_id = $1;
+
+-- name: DeleteQuotesSourcesOfQuote :exec
+DELETE FROM quotes_sources WHERE library_id = $1 AND quote = $2;
+
+-- name: MoveQuotesSourcesToSource :exec
+INSERT INTO quotes_sources (quote, source, library_id)
+SELECT quotes_sources.quote, sqlc.arg(to_source)::BIGINT, quotes_sources.library_id FROM quotes_sources
+WHERE quotes_sources.library_id = sqlc.arg(library_id) AND quotes_sources.source = sqlc.arg(from_source)::BIGINT
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteQuotesSourcesOfSource :exec
+DELETE FROM quotes_sources WHERE library_id = $1 AND source = $2;
+
 -- name: DeleteQuotesSourcesInLibrary :exec
 DELETE FROM quotes_sources WHERE library_id = $1;
 
 -- name: SetQuotesSourcesLibrary :exec
 UPDATE quotes_sources SET library_id = $1 WHERE library_id = $2;
 
+--------- ACTIVITIES ---------
+
+-- name: CreateActivity :one
+INSERT INTO activities (user_id, library_id, kind, data) VALUES ($1, $2, $3, $4) RETURNING *;
+
+-- name: GetLastActivity :one
+SELECT * FROM activities WHERE user_id = $1 AND library_id = $2 AND undone_at IS NULL AND created_at > $3
+ORDER BY id DESC LIMIT 1;
+
+-- name: SetActivityUndone :one
+UPDATE activities SET undone_at = NOW() WHERE id = $1 AND undone_at IS NULL RETURNING id;
+
 ----------- DEBUG ------------
 
+-- name: CleanActivities :exec
+DELETE FROM activities;
+
+-- name: CleanReviewReminders :exec
+DELETE FROM review_reminders;
+
+-- name: CleanReviewCards :exec
+DELETE FROM review_cards;
+
+-- name: CleanDigests :exec
+DELETE FROM digests;
+
 -- name: CleanOutputs :exec
 DELETE FROM outputs; 
 
@@ -1,4 +1,4 @@
-CREATE TYPE user_state AS ENUM ('normal', 'editingSource', 'changingLibrary', 'confirmingLibraryChange');
+CREATE TYPE user_state AS ENUM ('normal', 'editingSource', 'changingLibrary', 'confirmingLibraryChange', 'editingQuote', 'editingOutputFilters', 'importingLibrary', 'editingDigest', 'editingTag', 'mergingSource', 'confirmingSourceChange', 'confirmingQuote');
 CREATE TABLE users (
   id BIGINT PRIMARY KEY,
   chat_id BIGINT NOT NULL,
@@ -9,7 +9,9 @@ CREATE TABLE users (
   active_source_expire TIMESTAMPTZ,
 	library_id BIGINT NOT NULL REFERENCES libraries (id),
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  quote_syntax JSON,
+  confirm_quotes BOOLEAN NOT NULL DEFAULT FALSE
 );
 
 CREATE TABLE libraries (
@@ -18,7 +20,8 @@ CREATE TABLE libraries (
 	token UUID,
 	token_expires_on TIMESTAMPTZ,
 	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	search_language TEXT NOT NULL DEFAULT 'english'
 );
 
 CREATE TYPE source_kind AS ENUM ('unknown', 'book', 'article', 'person');
@@ -39,7 +42,12 @@ CREATE TABLE quotes (
   main_source TEXT,
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
   updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-  text_tokens TSVECTOR GENERATED ALWAYS AS (TO_TSVECTOR('english', text || ' ' || COALESCE(main_source, ''))) STORED
+  search_config REGCONFIG NOT NULL DEFAULT 'english',
+  text_tokens TSVECTOR GENERATED ALWAYS AS (TO_TSVECTOR(search_config, text || ' ' || COALESCE(main_source, '') || ' ' || COALESCE(note, ''))) STORED,
+  origin_url TEXT,
+  text_entities JSON,
+  location TEXT,
+  note TEXT
 );
 
 CREATE TABLE tags (
@@ -57,7 +65,8 @@ CREATE TABLE outputs (
   title TEXT NOT NULL,
   is_active BOOLEAN NOT NULL DEFAULT FALSE,
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  filters JSON
 );
 
 CREATE TABLE quotes_tags (
@@ -74,3 +83,45 @@ CREATE TABLE quotes_sources (
   PRIMARY KEY (source, quote)
 );
 
+
+CREATE TABLE digests (
+  user_id BIGINT PRIMARY KEY REFERENCES users (id),
+  is_active BOOLEAN NOT NULL DEFAULT FALSE,
+  send_at TEXT NOT NULL DEFAULT '09:00',
+  timezone TEXT NOT NULL DEFAULT 'UTC',
+  count INT NOT NULL DEFAULT 1,
+  output_chat_id BIGINT,
+  filters JSON,
+  last_sent_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE review_cards (
+  user_id BIGINT NOT NULL REFERENCES users (id),
+  quote_id BIGINT NOT NULL REFERENCES quotes (id) ON DELETE CASCADE,
+  repetitions INT NOT NULL DEFAULT 0,
+  interval_days INT NOT NULL DEFAULT 0,
+  ease_factor DOUBLE PRECISION NOT NULL DEFAULT 2.5,
+  due_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_reviewed_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, quote_id)
+);
+
+CREATE TABLE review_reminders (
+  user_id BIGINT PRIMARY KEY REFERENCES users (id),
+  reminded_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TYPE activity_kind AS ENUM ('createQuote', 'editSource', 'mergeTags');
+CREATE TABLE activities (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  library_id BIGINT NOT NULL REFERENCES libraries (id) ON DELETE CASCADE,
+  kind activity_kind NOT NULL,
+  data JSON NOT NULL,
+  undone_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Store is the data layer used by the bot. DB implements it on top of postgres
+// and MemoryDB keeps everything in memory, which is useful for tests.
+type Store interface {
+	Init() error
+	Close()
+	DEBUGCleanDB() error
+
+	GetUser(ID int64) (*User, error)
+	GetOrCreateUser(ID, ChatID int64, firstName string) (*User, bool, error)
+	SetUserStateNormal(userID int64) (*User, error)
+	SetUserStateEditingSource(userID int64, sourceID int64) (*User, error)
+	SetUserStateChangingLibrary(userID int64, libraryID int64) (*User, error)
+	SetUserStateConfirmingLibraryChange(userID int64, libraryID int64, mode string) (*User, error)
+	SetUserStateEditingQuote(userID int64, quoteID int64) (*User, error)
+	SetUserStateEditingOutputFilters(userID int64, outputChatID int64) (*User, error)
+	SetUserStateImportingLibrary(userID int64) (*User, error)
+	SetUserStateEditingDigest(userID int64) (*User, error)
+	SetUserStateMergingSource(userID int64, sourceID int64) (*User, error)
+	SetUserStateConfirmingSourceChange(userID int64, sourceID int64, targetSourceID int64, mode string) (*User, error)
+	SetUserStateEditingTag(userID int64, tagID int64, mode string) (*User, error)
+	SetUserStateConfirmingQuote(userID int64, draft *QuoteDraft, mode string) (*User, error)
+	SetUserQuoteSyntax(userID int64, syntax *QuoteSyntax) (*User, error)
+	SetUserConfirmQuotes(userID int64, confirmQuotes bool) (*User, error)
+	DeleteUserCurrentLibraryAndMigrateTo(userID, currLibraryID, newLibraryID int64) error
+	MergeUserCurrentLibraryAndMigrateTo(userID, currLibraryID, newLibraryID int64) error
+
+	GetLibrary(libraryID int64) (*Library, error)
+	GetLibraryByUUID(UUID uuid.UUID) (*Library, error)
+	SetLibraryToken(libraryID int64, UUID uuid.UUID, expiresOn time.Time) (*Library, error)
+	DeleteLibraryToken(libraryID int64) (*Library, error)
+	SetLibrarySearchLanguage(libraryID int64, language string) (*Library, error)
+
+	CreateQuoteWithData(userID int64, libraryID int64, text, mainSource string, tagNames []string, sourceNames []string, details *QuoteDetails) (*CreateQuoteResult, error)
+	GetQuoteWithData(libraryID int64, quoteID int64) (*QuoteWithData, error)
+	GetQuoteByText(libraryID int64, text string) (*QuoteWithData, error)
+	GetQuoteSources(libraryID int64, quoteID int64) ([]Source, error)
+	GetLibraryQuotesWithData(libraryID int64) ([]QuoteWithData, error)
+	ImportQuotes(libraryID int64, quotes []ImportedQuote) (*ImportResult, error)
+	UpdateQuoteWithData(libraryID int64, quoteID int64, text, mainSource string, tagNames []string, sourceNames []string, details *QuoteDetails) (*QuoteWithData, error)
+	SetQuoteOriginURL(libraryID int64, quoteID int64, originURL string) error
+	SetQuoteDetails(libraryID int64, quoteID int64, location, note string) error
+	SetQuoteTextEntities(libraryID int64, quoteID int64, entities []TextEntity) error
+	DeleteQuote(libraryID int64, quoteID int64) error
+	SearchQuotes(libraryID int64, search *QuoteSearch, limit int32, offset int32) ([]QuoteWithData, error)
+
+	QueryTags(p QueryTagsParams) ([]TagWithCount, error)
+	GetTag(libraryID int64, name string) (*Tag, error)
+	GetTagByID(libraryID int64, tagID int64) (*Tag, error)
+	RenameTag(libraryID int64, tagID int64, name string) (*Tag, error)
+	MergeTags(userID, libraryID int64, fromTagID int64, toTagID int64) error
+	DeleteTag(libraryID int64, tagID int64) error
+
+	CreateSource(libraryID int64, name string) (*Source, error)
+	GetSource(libraryID int64, name string) (*Source, error)
+	GetSourceByID(libraryID int64, sourceID int64) (*Source, error)
+	GetLibrarySources(libraryID int64) ([]Source, error)
+	SetSourceBook(libraryID int64, sourceID int64, sourceData *SourceBookData) (*Source, error)
+	SetSourceArticle(libraryID int64, sourceID int64, sourceData *SourceArticleData) (*Source, error)
+	SetSourcePerson(libraryID int64, sourceID int64, sourceData *SourcePersonData) (*Source, error)
+	SetSourceUnknown(libraryID int64, sourceID int64) (*Source, error)
+	UpdateSource(userID, libraryID int64, source *Source) (*Source, error)
+	DeleteSource(libraryID int64, sourceID int64) error
+	MergeSources(libraryID int64, fromSourceID int64, toSourceID int64) error
+	QuerySources(p QuerySourcesParams) ([]Source, error)
+	SetActiveSource(userID int64, activeSourceStr string, activeSourceExpireTime time.Time) (*User, error)
+	DeactivateExpiredSources() ([]User, error)
+	DeactivateSource(userID int64) (*User, error)
+
+	GetOutputs(userID int64) ([]Output, error)
+	GetActiveOutputs(userID int64) ([]Output, error)
+	GetOutput(userID int64, outputChatID int64) (*Output, error)
+	GetOrCreateOutput(userID int64, chatID int64, chatTitle string) (*Output, bool, error)
+	ActivateOutput(userID int64, outputChatID int64) (*Output, error)
+	DeactivateOutput(userID int64, outputChatID int64) (*Output, error)
+	SetOutputFilters(userID int64, outputChatID int64, filters *OutputFilters) (*Output, error)
+	DeleteOutput(userID int64, outputChatID int64) error
+
+	GetDigest(userID int64) (*Digest, error)
+	GetActiveDigests() ([]Digest, error)
+	SetDigest(userID int64, settings *DigestSettings) (*Digest, error)
+	SetDigestLastSentAt(userID int64, lastSentAt time.Time) (*Digest, error)
+
+	SyncReviewCards(userID int64, libraryID int64) error
+	SyncAllReviewCards() error
+	GetDueReviewCard(userID int64, libraryID int64) (*ReviewCard, error)
+	CountDueReviewCards(userID int64, libraryID int64) (int64, error)
+	GetReviewCard(userID int64, libraryID int64, quoteID int64) (*ReviewCard, error)
+	UpdateReviewCard(userID int64, quoteID int64, schedule *ReviewSchedule) (*ReviewCard, error)
+	GetUsersToRemindReview(remindedAfter time.Time) ([]User, error)
+	SetReviewReminded(userID int64, remindedAt time.Time) error
+
+	GetLastActivity(userID, libraryID int64, after time.Time) (*Activity, error)
+	UndoActivity(libraryID int64, activity *Activity) (bool, error)
+}
+
+var _ Store = (*DB)(nil)
+var _ Store = (*MemoryDB)(nil)
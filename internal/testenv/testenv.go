@@ -0,0 +1,35 @@
+// Package testenv selects the store tests run against. Tests run against the postgres
+// database of TEST_DB_URL if it is set and against the in-memory store otherwise.
+package testenv
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// DB_URL_ENV is the environment variable of the URL of the postgres database tests run against
+const DB_URL_ENV = "TEST_DB_URL"
+
+// DBURL returns the URL of the postgres database tests run against, it is empty if tests run
+// against the in-memory store
+func DBURL() string {
+	return os.Getenv(DB_URL_ENV)
+}
+
+// Main prints the store tests run against and runs them, it should be called by TestMain of packages
+func Main(m *testing.M) {
+	if DBURL() != "" {
+		fmt.Println("running tests against postgres")
+	} else {
+		fmt.Printf("running tests against the in-memory store, set %s to run them against postgres\n", DB_URL_ENV)
+	}
+	os.Exit(m.Run())
+}
+
+// SkipWithoutPostgres skips tests which only run against postgres when it is not used
+func SkipWithoutPostgres(t *testing.T) {
+	if DBURL() == "" {
+		t.Skip("only runs against postgres, which is not used for this run")
+	}
+}
@@ -0,0 +1,2 @@
+
+ALTER TYPE user_state ADD VALUE IF NOT EXISTS 'editingQuote';
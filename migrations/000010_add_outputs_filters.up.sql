@@ -0,0 +1,3 @@
+
+ALTER TYPE user_state ADD VALUE IF NOT EXISTS 'editingOutputFilters';
+ALTER TABLE outputs ADD COLUMN IF NOT EXISTS filters JSON;
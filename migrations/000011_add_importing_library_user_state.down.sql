@@ -0,0 +1,8 @@
+-- postgres can not drop a value from an enum, so the type is recreated without it
+UPDATE users SET state = 'normal', state_data = NULL WHERE state = 'importingLibrary';
+ALTER TYPE user_state RENAME TO user_state_old;
+CREATE TYPE user_state AS ENUM ('normal', 'editingSource', 'changingLibrary', 'confirmingLibraryChange', 'editingQuote', 'editingOutputFilters');
+ALTER TABLE users ALTER COLUMN state DROP DEFAULT;
+ALTER TABLE users ALTER COLUMN state TYPE user_state USING state::TEXT::user_state;
+ALTER TABLE users ALTER COLUMN state SET DEFAULT 'normal';
+DROP TYPE user_state_old;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS digests (
+  user_id BIGINT PRIMARY KEY REFERENCES users (id),
+  is_active BOOLEAN NOT NULL DEFAULT FALSE,
+  send_at TEXT NOT NULL DEFAULT '09:00',
+  timezone TEXT NOT NULL DEFAULT 'UTC',
+  count INT NOT NULL DEFAULT 1,
+  output_chat_id BIGINT,
+  filters JSON,
+  last_sent_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TYPE user_state ADD VALUE IF NOT EXISTS 'editingDigest';
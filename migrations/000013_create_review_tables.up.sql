@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS review_cards (
+  user_id BIGINT NOT NULL REFERENCES users (id),
+  quote_id BIGINT NOT NULL REFERENCES quotes (id) ON DELETE CASCADE,
+  repetitions INT NOT NULL DEFAULT 0,
+  interval_days INT NOT NULL DEFAULT 0,
+  ease_factor DOUBLE PRECISION NOT NULL DEFAULT 2.5,
+  due_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_reviewed_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, quote_id)
+);
+
+CREATE INDEX ON review_cards (user_id, due_at);
+
+CREATE TABLE IF NOT EXISTS review_reminders (
+  user_id BIGINT PRIMARY KEY REFERENCES users (id),
+  reminded_at TIMESTAMPTZ NOT NULL
+);
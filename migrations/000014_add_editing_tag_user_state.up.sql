@@ -0,0 +1 @@
+ALTER TYPE user_state ADD VALUE IF NOT EXISTS 'editingTag';
@@ -0,0 +1,2 @@
+-- the data migration can not be reverted, old main source names are not kept
+SELECT 1;
@@ -0,0 +1,17 @@
+-- main sources were not renamed with their source. the main source of a quote is always one of its
+-- sources, so a quote whose main source matches no source and which has a single source was renamed with it
+UPDATE quotes SET main_source = sources.name, updated_at = NOW()
+FROM quotes_sources
+INNER JOIN sources ON sources.id = quotes_sources.source
+WHERE quotes_sources.quote = quotes.id
+  AND quotes.main_source IS NOT NULL
+  AND NOT EXISTS (SELECT 1 FROM sources s WHERE s.library_id = quotes.library_id AND s.name = quotes.main_source)
+  AND (SELECT COUNT(*) FROM quotes_sources qs WHERE qs.quote = quotes.id) = 1;
+
+-- a quote with no sources has no source to reference as its main source
+UPDATE quotes SET main_source = NULL, updated_at = NOW()
+WHERE main_source IS NOT NULL
+  AND NOT EXISTS (SELECT 1 FROM quotes_sources qs WHERE qs.quote = quotes.id);
+
+-- quotes left with a stale main source have several sources and any of them may have been
+-- renamed, so their main source is kept as it is
@@ -0,0 +1,6 @@
+ALTER TABLE quotes DROP COLUMN text_tokens;
+ALTER TABLE quotes ADD COLUMN text_tokens TSVECTOR GENERATED ALWAYS AS (TO_TSVECTOR('english', text || ' ' || COALESCE(main_source, ''))) STORED;
+CREATE INDEX ON quotes USING GIN (text_tokens);
+
+ALTER TABLE quotes DROP COLUMN search_config;
+ALTER TABLE libraries DROP COLUMN search_language;
@@ -0,0 +1,8 @@
+-- search_language is the name of the text search configuration of the library, quotes keep
+-- it as REGCONFIG since generated columns can only use immutable expressions
+ALTER TABLE libraries ADD COLUMN search_language TEXT NOT NULL DEFAULT 'english';
+ALTER TABLE quotes ADD COLUMN search_config REGCONFIG NOT NULL DEFAULT 'english';
+
+ALTER TABLE quotes DROP COLUMN text_tokens;
+ALTER TABLE quotes ADD COLUMN text_tokens TSVECTOR GENERATED ALWAYS AS (TO_TSVECTOR(search_config, text || ' ' || COALESCE(main_source, ''))) STORED;
+CREATE INDEX ON quotes USING GIN (text_tokens);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS quotes_search_text_trgm_idx;
+DROP EXTENSION IF EXISTS pg_trgm;
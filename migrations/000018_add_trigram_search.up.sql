@@ -0,0 +1,3 @@
+-- trigrams are used to find quotes by similarity, when words of a search have typos
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX quotes_search_text_trgm_idx ON quotes USING GIN ((text || ' ' || COALESCE(main_source, '')) gin_trgm_ops);
@@ -0,0 +1,2 @@
+-- origin_url links to the original message of quotes forwarded from channels
+ALTER TABLE quotes ADD COLUMN origin_url TEXT;
@@ -0,0 +1,2 @@
+-- text_entities is the formatting of the text of quotes, as Telegram message entities
+ALTER TABLE quotes ADD COLUMN text_entities JSON;
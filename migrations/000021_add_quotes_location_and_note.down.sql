@@ -0,0 +1,7 @@
+DROP INDEX quotes_search_text_trgm_idx;
+CREATE INDEX quotes_search_text_trgm_idx ON quotes USING GIN ((text || ' ' || COALESCE(main_source, '')) gin_trgm_ops);
+ALTER TABLE quotes DROP COLUMN text_tokens;
+ALTER TABLE quotes ADD COLUMN text_tokens TSVECTOR GENERATED ALWAYS AS (TO_TSVECTOR(search_config, text || ' ' || COALESCE(main_source, ''))) STORED;
+CREATE INDEX ON quotes USING GIN (text_tokens);
+ALTER TABLE quotes DROP COLUMN note;
+ALTER TABLE quotes DROP COLUMN location;
@@ -0,0 +1,8 @@
+-- location is where the quote is in its main source, like a page, note is the commentary of the user
+ALTER TABLE quotes ADD COLUMN location TEXT;
+ALTER TABLE quotes ADD COLUMN note TEXT;
+ALTER TABLE quotes DROP COLUMN text_tokens;
+ALTER TABLE quotes ADD COLUMN text_tokens TSVECTOR GENERATED ALWAYS AS (TO_TSVECTOR(search_config, text || ' ' || COALESCE(main_source, '') || ' ' || COALESCE(note, ''))) STORED;
+CREATE INDEX ON quotes USING GIN (text_tokens);
+DROP INDEX quotes_search_text_trgm_idx;
+CREATE INDEX quotes_search_text_trgm_idx ON quotes USING GIN ((text || ' ' || COALESCE(main_source, '') || ' ' || COALESCE(note, '')) gin_trgm_ops);
@@ -0,0 +1,3 @@
+-- quote_syntax is the keywords the user writes sources, tags, pages and notes of quotes with,
+-- the default keywords are used when it is NULL
+ALTER TABLE users ADD COLUMN quote_syntax JSON;
@@ -0,0 +1,3 @@
+-- confirm_quotes makes the bot keep new quotes as a draft until the user saves them
+ALTER TYPE user_state ADD VALUE IF NOT EXISTS 'confirmingQuote';
+ALTER TABLE users ADD COLUMN confirm_quotes BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS activities;
+DROP TYPE IF EXISTS activity_kind;
@@ -0,0 +1,14 @@
+CREATE TYPE activity_kind AS ENUM ('createQuote', 'editSource', 'mergeTags');
+
+-- activities are changes users make to their libraries, data keeps what is needed to undo them
+CREATE TABLE IF NOT EXISTS activities (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  library_id BIGINT NOT NULL REFERENCES libraries (id) ON DELETE CASCADE,
+  kind activity_kind NOT NULL,
+  data JSON NOT NULL,
+  undone_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX ON activities (user_id, library_id, created_at);
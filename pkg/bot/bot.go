@@ -1,11 +1,12 @@
 package bot
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
@@ -25,21 +26,30 @@ import (
 )
 
 type Config struct {
+	Mode                           string
 	WebhookAddress                 string
+	WebhookSecret                  string
 	CertFilePath                   string
 	PrivKeyFilePath                string
 	IsDev                          bool
 	LogPath                        string
 	DefaultActiveSourceTimeoutMins int
 	DeactivatorIntervalMins        int
+	ListenAddress                  string
 	Port                           int
 }
 
 const SOURCES_PAGE_LIMIT = 5
+const TAGS_PAGE_LIMIT = 10
+const SEARCH_PAGE_LIMIT = 5
 
-// TODO: add support for filtering HASHTAGS and SOURCES for different outputs
+// UNDO_WINDOW is how long changes can be undone after they are made
+const UNDO_WINDOW = 30 * time.Minute
 
-func RunBot(appDB *db.DB, token string, config *Config) error {
+// https://core.telegram.org/bots/api#answerinlinequery no more than 50 results per query is allowed
+const INLINE_QUERY_PAGE_LIMIT = 50
+
+func RunBot(appDB db.Store, token string, config *Config) error {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
@@ -48,9 +58,11 @@ func RunBot(appDB *db.DB, token string, config *Config) error {
 		return err
 	}
 
+	downloader := &telegramFileDownloader{token: token, ctx: ctx}
 	h := Handlers{
 		db:                             appDB,
 		l:                              l,
+		files:                          downloader,
 		defaultActiveSourceTimeoutMins: config.DefaultActiveSourceTimeoutMins,
 		LibraryUUIDLifetime:            time.Duration(config.DefaultActiveSourceTimeoutMins) * time.Minute,
 	}
@@ -66,6 +78,7 @@ func RunBot(appDB *db.DB, token string, config *Config) error {
 	if err != nil {
 		return err
 	}
+	downloader.b = b
 
 	deactivator, err := NewSourceDeactiver(appDB, b, config.IsDev, config.LogPath, ctx)
 	if err != nil {
@@ -73,26 +86,32 @@ func RunBot(appDB *db.DB, token string, config *Config) error {
 	}
 	deactivator.Schedule(config.DeactivatorIntervalMins)
 
-	go b.StartWebhook(ctx)
-
-	_, err = b.SetWebhook(ctx, &bot.SetWebhookParams{
-		URL: config.WebhookAddress,
-	})
-
+	digestSender, err := NewDigestSender(appDB, b, config.IsDev, config.LogPath, ctx)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	digestSender.Schedule()
 
-	if err = http.ListenAndServeTLS(fmt.Sprintf(":%d", config.Port), config.CertFilePath, config.PrivKeyFilePath, b.WebhookHandler()); err != nil {
-		panic(err)
+	reviewReminder, err := NewReviewReminder(appDB, b, config.IsDev, config.LogPath, ctx)
+	if err != nil {
+		return err
 	}
+	reviewReminder.Schedule()
 
-	return nil
+	switch config.Mode {
+	case MODE_POLLING:
+		return runPolling(ctx, b)
+	case MODE_HTTP_WEBHOOK:
+		return runWebhook(ctx, b, config, false)
+	default:
+		return runWebhook(ctx, b, config, true)
+	}
 }
 
 type Handlers struct {
-	db                             *db.DB
+	db                             db.Store
 	l                              zerolog.Logger
+	files                          FileDownloader
 	defaultActiveSourceTimeoutMins int
 	LibraryUUIDLifetime            time.Duration
 }
@@ -127,7 +146,7 @@ func (h Handlers) updateHandler(ctx context.Context, b *bot.Bot, update *models.
 	}
 
 	if update.InlineQuery != nil {
-		results, err := h.reactInlineQuery(update)
+		results, nextOffset, err := h.reactInlineQuery(update)
 		if err != nil {
 			h.l.Error().Err(err).Msg("reacting to inline query")
 			return
@@ -141,6 +160,7 @@ func (h Handlers) updateHandler(ctx context.Context, b *bot.Bot, update *models.
 			InlineQueryID: update.InlineQuery.ID,
 			IsPersonal:    true,
 			Results:       results,
+			NextOffset:    nextOffset,
 		})
 		if err != nil {
 			h.l.Error().Err(err).Msg("answering inline query")
@@ -175,6 +195,23 @@ func (h Handlers) updateHandler(ctx context.Context, b *bot.Bot, update *models.
 		r, err = h.reactStateEditingSource(user, update)
 	case user.State == db.UserStateConfirmingLibraryChange:
 		r, err = h.reactStateConfirmingLibraryChange(user, update)
+	case user.State == db.UserStateEditingQuote:
+		r, err = h.reactStateEditingQuote(user, update)
+	case user.State == db.UserStateEditingOutputFilters:
+		r, err = h.reactStateEditingOutputFilters(user, update)
+	case user.State == db.UserStateImportingLibrary:
+		r, err = h.reactStateImportingLibrary(user, update)
+	case user.State == db.UserStateEditingDigest:
+		r, err = h.reactStateEditingDigest(user, update)
+	case user.State == db.UserStateEditingTag:
+		r, err = h.reactStateEditingTag(user, update)
+	case user.State == db.UserStateMergingSource:
+		r, err = h.reactStateMergingSource(user, update)
+	case user.State == db.UserStateConfirmingSourceChange:
+		r, err = h.reactStateConfirmingSourceChange(user, update)
+	// commands are not blocked by the quote waiting to be saved
+	case user.State == db.UserStateConfirmingQuote && !strings.HasPrefix(update.Message.Text, "/"):
+		r, err = h.reactStateConfirmingQuote(user, update)
 	case update.Message.Text == s.COMMAND_START:
 		r, err = h.reactAlreadyJoinedStart(user, update)
 	case update.Message.Text == s.COMMAND_HELP:
@@ -191,6 +228,32 @@ func (h Handlers) updateHandler(ctx context.Context, b *bot.Bot, update *models.
 		r, err = h.reactSetLibraryToken(user, update)
 	case strings.HasPrefix(update.Message.Text, s.COMMAND_GET_SOURCES):
 		r, err = h.reactGetSources(user, update)
+	case strings.HasPrefix(update.Message.Text, s.COMMAND_EDIT_QUOTE):
+		r, err = h.reactEditQuote(user, update)
+	case strings.HasPrefix(update.Message.Text, s.COMMAND_DELETE_QUOTE):
+		r, err = h.reactDeleteQuote(user, update)
+	case strings.HasPrefix(update.Message.Text, s.COMMAND_EXPORT):
+		r, err = h.reactExport(user, update)
+	case update.Message.Text == s.COMMAND_IMPORT:
+		r, err = h.reactImport(user, update)
+	case update.Message.Text == s.COMMAND_DIGEST:
+		r, err = h.reactDigest(user, update)
+	case update.Message.Text == s.COMMAND_REVIEW:
+		r, err = h.reactReview(user, update)
+	case update.Message.Text == s.COMMAND_TAGS:
+		r, err = h.reactTags(user, update)
+	case strings.HasPrefix(update.Message.Text, s.COMMAND_SEARCH):
+		r, err = h.reactSearch(user, update)
+	case strings.HasPrefix(update.Message.Text, s.COMMAND_LANGUAGE):
+		r, err = h.reactLanguage(user, update)
+	case strings.HasPrefix(update.Message.Text, s.COMMAND_SYNTAX):
+		r, err = h.reactSyntax(user, update)
+	case strings.HasPrefix(update.Message.Text, s.COMMAND_PREVIEW):
+		r, err = h.reactPreview(user, update)
+	case strings.HasPrefix(update.Message.Text, s.COMMAND_CONFIRM_QUOTES):
+		r, err = h.reactConfirmQuotes(user, update)
+	case update.Message.Text == s.COMMAND_UNDO:
+		r, err = h.reactUndo(user, update)
 	default:
 		r, err = h.reactDefault(user, update)
 	}
@@ -219,10 +282,30 @@ func (h Handlers) updateHandler(ctx context.Context, b *bot.Bot, update *models.
 
 // TODO: split reactions to multiple files
 func (h Handlers) reactDefault(user *db.User, update *models.Update) (u.Reaction, error) {
-	q, err := u.ParseQuote(update.Message.Text)
+	text, entities := update.Message.Text, update.Message.Entities
+	if text == "" {
+		// photos and documents have captions instead of text
+		text, entities = update.Message.Caption, update.Message.CaptionEntities
+	}
+	syntax, err := u.ParseQuoteSyntax(user.QuoteSyntax)
 	if err != nil {
 		return u.Reaction{}, err
 	}
+	q, err := u.ParseQuoteWithSyntax(text, u.TextEntitiesFromMessage(entities), &syntax)
+	if err != nil {
+		if errors.Is(err, u.ErrQuoteTextBlockNotClosed) {
+			return u.ReplyReaction(update.Message, s.QuoteTextBlockNotClosed), nil
+		}
+		return u.ReplyReaction(update.Message, s.QuoteHasNoText), nil
+	}
+
+	// quotes forwarded without sources are from the chat or the user they are forwarded from
+	origin, originURL := u.ForwardOrigin(update.Message)
+	if origin != "" && len(q.Sources) == 0 {
+		q.MainSource = origin
+		q.Sources = append(q.Sources, origin)
+	}
+	q.OriginURL = originURL
 
 	messages := []bot.SendMessageParams{}
 
@@ -240,18 +323,62 @@ func (h Handlers) reactDefault(user *db.User, update *models.Update) (u.Reaction
 		}
 	}
 
-	_, err = h.db.CreateQuoteWithData(user.LibraryID, q.Text, q.MainSource, q.Tags, q.Sources)
+	if user.ConfirmQuotes {
+		if _, err = h.db.SetUserStateConfirmingQuote(user.ID, u.DraftFromQuote(q, update.Message.ID), ""); err != nil {
+			return u.Reaction{}, err
+		}
+		messages = append(messages, quoteDraftMessage(update.Message.Chat.ID, update.Message.ID, q))
+		return u.Reaction{Messages: messages}, nil
+	}
+
+	addedMessages, err := h.addQuote(user, update.Message.Chat.ID, update.Message.ID, q)
 	if err != nil {
 		return u.Reaction{}, err
 	}
+	return u.Reaction{
+		Messages: append(messages, addedMessages...),
+	}, nil
+}
+
+// addQuote saves the quote and returns the messages to send for it, a reply to the message with
+// replyToMessageID and the messages to the active outputs accepting it
+func (h Handlers) addQuote(user *db.User, chatID int64, replyToMessageID int, q *u.Quote) ([]bot.SendMessageParams, error) {
+	details := db.QuoteDetails{OriginURL: q.OriginURL, TextEntities: q.Entities, Location: q.Location, Note: q.Note}
+	quote, err := h.db.CreateQuoteWithData(user.ID, user.LibraryID, q.Text, q.MainSource, q.Tags, q.Sources, &details)
+	if err != nil {
+		return nil, err
+	}
 
-	outputs, err := h.db.GetOutputs(update.Message.From.ID)
+	failedToPublishMsg := bot.SendMessageParams{ChatID: chatID, ReplyToMessageID: replyToMessageID, Text: s.QuoteAddedButFailedToPublish}
+	outputs, err := h.db.GetActiveOutputs(user.ID)
 	if err != nil {
-		return u.ReplyReaction(update.Message, s.QuoteAddedButFailedToPublish), nil
+		return []bot.SendMessageParams{failedToPublishMsg}, nil
+	}
+
+	var quoteSources []db.Source
+	if len(outputs) != 0 {
+		if quoteSources, err = h.db.GetQuoteSources(user.LibraryID, quote.ID); err != nil {
+			return []bot.SendMessageParams{failedToPublishMsg}, nil
+		}
 	}
 
-	messages = append(messages, u.TextReplyToMessage(update.Message, s.QuoteAdded))
+	messages := []bot.SendMessageParams{{
+		ChatID:           chatID,
+		ReplyToMessageID: replyToMessageID,
+		Text:             s.QuoteAdded,
+		ReplyMarkup:      u.QuoteReplyMarkup(quote.ID),
+	}}
 	for _, output := range outputs {
+		filters, err := u.ParseOutputFilters(output.Filters)
+		if err != nil {
+			// the quote is already saved, so other outputs still get it
+			h.l.Error().Err(err).Int64("outputChatID", output.ChatID).Msg("parsing output filters")
+			continue
+		}
+		if !u.OutputAcceptsQuote(&filters, q.Tags, quoteSources) {
+			continue
+		}
+
 		messages = append(messages, bot.SendMessageParams{
 			ChatID:    output.ChatID,
 			ParseMode: models.ParseModeMarkdown,
@@ -259,9 +386,18 @@ func (h Handlers) reactDefault(user *db.User, update *models.Update) (u.Reaction
 		})
 	}
 
-	return u.Reaction{
-		Messages: messages,
-	}, nil
+	return messages, nil
+}
+
+// quoteDraftMessage returns the preview of the draft made from the message with messageID, with its buttons
+func quoteDraftMessage(chatID int64, messageID int, q *u.Quote) bot.SendMessageParams {
+	return bot.SendMessageParams{
+		ChatID:           chatID,
+		ReplyToMessageID: messageID,
+		Text:             s.QuoteDraft(q),
+		ParseMode:        models.ParseModeMarkdown,
+		ReplyMarkup:      u.QuoteDraftReplyMarkup(messageID),
+	}
 }
 
 func (h Handlers) reactNewUser(user *db.User, update *models.Update) (u.Reaction, error) {
@@ -276,8 +412,7 @@ func (h Handlers) reactNewUser(user *db.User, update *models.Update) (u.Reaction
 }
 
 func (h Handlers) reactStateEditingSource(user *db.User, update *models.Update) (u.Reaction, error) {
-	// TODO: use strings
-	if update.Message.Text == "cancel" {
+	if update.Message.Text == s.CancelAnswer {
 		if _, err := h.db.SetUserStateNormal(user.ID); err != nil {
 			return u.Reaction{}, err
 		}
@@ -361,9 +496,9 @@ func (h Handlers) reactStateEditingSource(user *db.User, update *models.Update)
 		newSource.Name = sourceName
 	}
 
-	resSource, err := h.db.UpdateSource(user.LibraryID, &newSource)
+	resSource, err := h.db.UpdateSource(user.ID, user.LibraryID, &newSource)
 	if err != nil {
-		return u.Reaction{}, nil
+		return u.Reaction{}, err
 	}
 
 	updateStr, err := s.UpdatedSource(resSource)
@@ -418,7 +553,7 @@ func (h Handlers) reactStateConfirmingLibraryChange(user *db.User, update *model
 		return u.ReplyReaction(update.Message, s.LibraryChangedSuccessfully), nil
 	}
 
-	if update.Message.Text == s.ConfirmLibraryChangeCancelAnswer {
+	if update.Message.Text == s.CancelAnswer {
 		if _, err := h.db.SetUserStateNormal(user.ID); err != nil {
 			return u.Reaction{}, err
 		}
@@ -428,174 +563,1188 @@ func (h Handlers) reactStateConfirmingLibraryChange(user *db.User, update *model
 	return u.ReplyReaction(update.Message, s.UnknownLibraryConfirmationMessage), nil
 }
 
-func (h Handlers) reactAlreadyJoinedStart(user *db.User, update *models.Update) (u.Reaction, error) {
-	return u.ReplyReaction(update.Message, s.YouAreAlreadyJoined), nil
-}
+func (h Handlers) reactStateMergingSource(user *db.User, update *models.Update) (u.Reaction, error) {
+	if update.Message.Text == s.CancelAnswer {
+		if _, err := h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.ReplyReaction(update.Message, s.OperationCanceled), nil
+	}
 
-func (h Handlers) reactGetSources(user *db.User, update *models.Update) (u.Reaction, error) {
-	text := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, s.COMMAND_GET_SOURCES))
+	var stateData db.StateMergingSourceData
+	if err := json.Unmarshal(user.StateData.Bytes, &stateData); err != nil {
+		if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.TextReaction(update.Message.Chat.ID, s.GoingBackToNormalMode), nil
+	}
 
-	filter, err := m.ParseSourceFilter(text)
+	source, err := h.db.GetSourceByID(user.LibraryID, stateData.SourceID)
 	if err != nil {
-		if errors.Is(err, m.ErrMultipleSourceKindFilters) {
-			return u.ReplyReaction(update.Message, s.OnlyOneSourceKindFilterIsAllowed), nil
+		if errors.Is(err, db.ErrNotFound) {
+			if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+				return u.Reaction{}, err
+			}
+			return u.Reaction{Messages: []bot.SendMessageParams{
+				u.TextReplyToMessage(update.Message, s.SourceNoLongerExists),
+				u.TextMessage(update.Message.Chat.ID, s.GoingBackToNormalMode),
+			}}, nil
 		}
 		return u.Reaction{}, err
 	}
 
-	// we are fetching one more elements to check if there is any more pages or not
-	sources, err := h.db.QuerySources(db.QuerySourcesParams{
-		LibraryID:  user.LibraryID,
-		NameQuery:  filter.Text,
-		SourceKind: filter.SourceKind,
-		Limit:      SOURCES_PAGE_LIMIT + 1,
-		BaseID:     0,
-		Before:     false,
-	})
+	targetName := strings.TrimSpace(update.Message.Text)
+	target, err := h.db.GetSource(user.LibraryID, targetName)
 	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return u.ReplyReaction(update.Message, s.SourceDoesNotExist(targetName)), nil
+		}
 		return u.Reaction{}, err
 	}
 
-	sourcesLen := len(sources)
-	isLastPage := sourcesLen < SOURCES_PAGE_LIMIT+1
-	var viewedSources []db.Source
-	if !isLastPage {
-		// excluding the last element in viewed sources
-		viewedSources = sources[:sourcesLen-1]
-	} else {
-		viewedSources = sources[:]
+	if target.ID == source.ID {
+		return u.ReplyReaction(update.Message, s.CanNotMergeSourceIntoItself), nil
 	}
 
-	msg := u.TextReplyToMessage(update.Message, s.ListOfSources(viewedSources))
-	msg.ParseMode = models.ParseModeMarkdown
-	msg.ReplyMarkup = u.SourcesReplyMarkup(viewedSources, true, isLastPage)
-	return u.Reaction{Messages: []bot.SendMessageParams{msg}}, nil
+	if _, err = h.db.SetUserStateConfirmingSourceChange(user.ID, source.ID, target.ID, db.ChangeSourceMergeMode); err != nil {
+		return u.Reaction{}, err
+	}
+
+	return u.ReplyReaction(update.Message, s.ConfirmMergeSource(source.Name, target.Name)), nil
 }
 
-func (h Handlers) reactSetActiveSource(user *db.User, update *models.Update) (u.Reaction, error) {
-	// TODO: what happens if we already have an active source?
-	text := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, s.COMMAND_SET_ACTIVE_SOURCE))
-	argsRaw := strings.Split(text, ",")
-	args := make([]string, 0, len(argsRaw))
+func (h Handlers) reactStateConfirmingSourceChange(user *db.User, update *models.Update) (u.Reaction, error) {
+	if update.Message.Text == s.CancelAnswer {
+		if _, err := h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.ReplyReaction(update.Message, s.OperationCanceled), nil
+	}
 
-	for _, arg := range argsRaw {
-		args = append(args, strings.TrimSpace(arg))
+	if update.Message.Text != s.ConfirmSourceChangeYesAnswer {
+		return u.ReplyReaction(update.Message, s.UnknownSourceConfirmationMessage), nil
 	}
 
-	argsLen := len(args)
+	var stateData db.StateConfirmingSourceChangeData
+	if err := json.Unmarshal(user.StateData.Bytes, &stateData); err != nil {
+		return u.Reaction{}, err
+	}
 
-	malformedReaction := u.ReplyReaction(update.Message, s.MalformedSetActiveSource(h.defaultActiveSourceTimeoutMins))
-	if text == "" || argsLen > 2 {
-		return malformedReaction, nil
+	source, err := h.db.GetSourceByID(user.LibraryID, stateData.SourceID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+				return u.Reaction{}, err
+			}
+			return u.ReplyReaction(update.Message, s.SourceNoLongerExists), nil
+		}
+		return u.Reaction{}, err
 	}
 
-	activeSourceTimeoutInt := h.defaultActiveSourceTimeoutMins
-	var err error
-	if argsLen == 2 {
-		activeSourceTimeoutInt, err = strconv.Atoi(args[1])
+	var text string
+	switch stateData.Mode {
+	case db.ChangeSourceDeleteMode:
+		if err = h.db.DeleteSource(user.LibraryID, source.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		text = s.SourceDeleted(source.Name)
+	case db.ChangeSourceMergeMode:
+		target, err := h.db.GetSourceByID(user.LibraryID, stateData.TargetSourceID)
 		if err != nil {
-			return malformedReaction, nil
+			if errors.Is(err, db.ErrNotFound) {
+				if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+					return u.Reaction{}, err
+				}
+				return u.ReplyReaction(update.Message, s.SourceNoLongerExists), nil
+			}
+			return u.Reaction{}, err
+		}
+
+		if err = h.db.MergeSources(user.LibraryID, source.ID, target.ID); err != nil {
+			return u.Reaction{}, err
 		}
+		text = s.SourcesMerged(source.Name, target.Name)
+	default:
+		return u.Reaction{}, fmt.Errorf("unknown change source mode: '%s' ", stateData.Mode)
 	}
 
-	if activeSourceTimeoutInt <= 0 {
-		return u.ReplyReaction(update.Message, s.SourceTimeoutShouldBeGreaterThanZero), nil
+	if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+		return u.Reaction{}, fmt.Errorf("setting user state normal: %w", err)
 	}
-	activeSourceExpire := time.Now().Add(time.Minute * time.Duration(activeSourceTimeoutInt))
 
-	_, err = h.db.GetSource(user.LibraryID, args[0])
-	if err != nil {
-		if errors.Is(err, db.ErrNotFound) {
-			return u.ReplyReaction(update.Message, s.SourceDoesNotExist(args[0])), nil
+	return u.ReplyReaction(update.Message, text), nil
+}
+
+func (h Handlers) reactStateEditingQuote(user *db.User, update *models.Update) (u.Reaction, error) {
+	if update.Message.Text == s.CancelAnswer {
+		if _, err := h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
 		}
+		return u.ReplyReaction(update.Message, s.OperationCanceled), nil
+	}
 
-		return u.Reaction{}, err
+	var stateData db.StateEditingQuoteData
+	if err := json.Unmarshal(user.StateData.Bytes, &stateData); err != nil {
+		if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.TextReaction(update.Message.Chat.ID, s.GoingBackToNormalMode), nil
 	}
 
-	_, err = h.db.SetActiveSource(user.ID, args[0], activeSourceExpire)
+	syntax, err := u.ParseQuoteSyntax(user.QuoteSyntax)
 	if err != nil {
 		return u.Reaction{}, err
 	}
+	q, err := u.ParseQuoteWithSyntax(update.Message.Text, u.TextEntitiesFromMessage(update.Message.Entities), &syntax)
+	if err != nil {
+		if errors.Is(err, u.ErrQuoteTextBlockNotClosed) {
+			return u.ReplyReaction(update.Message, s.QuoteTextBlockNotClosed), nil
+		}
+		return u.ReplyReaction(update.Message, s.QuoteHasNoText), nil
+	}
 
-	return u.ReplyReaction(update.Message, s.ActiveSourceIsSet(args[0], activeSourceTimeoutInt)), nil
-}
-
-func (h Handlers) reactHelp(user *db.User, update *models.Update) (u.Reaction, error) {
-	return u.TextReaction(update.Message.Chat.ID, s.Help), nil
-}
-
-func (h Handlers) reactGetOutputs(user *db.User, update *models.Update) (u.Reaction, error) {
-	// FIXME: paginate get outputs
-	outputs, err := h.db.GetOutputs(user.ID)
+	// details are always set, so they are removed if the new version does not have them
+	details := db.QuoteDetails{TextEntities: q.Entities, Location: q.Location, Note: q.Note}
+	quote, err := h.db.UpdateQuoteWithData(user.LibraryID, stateData.QuoteID, q.Text, q.MainSource, q.Tags, q.Sources, &details)
 	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+				return u.Reaction{}, err
+			}
+			return u.Reaction{Messages: []bot.SendMessageParams{
+				u.TextReplyToMessage(update.Message, s.QuoteNoLongerExists),
+				u.TextMessage(update.Message.Chat.ID, s.GoingBackToNormalMode),
+			}}, nil
+		}
 		return u.Reaction{}, err
 	}
 
-	msg := u.TextReplyToMessage(update.Message, s.ListOfYourOutputs(outputs))
-	msg.ParseMode = models.ParseModeMarkdown
-
-	replyMarkup, err := u.OutputsReplyMarkup(outputs)
-	if err != nil {
+	if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
 		return u.Reaction{}, err
 	}
 
-	msg.ReplyMarkup = replyMarkup
+	msg := u.TextReplyToMessage(update.Message, s.QuoteUpdated)
+	msg.ReplyMarkup = u.QuoteReplyMarkup(quote.ID)
 	return u.Reaction{Messages: []bot.SendMessageParams{msg}}, nil
 }
 
-func (h Handlers) reactGetLibraryToken(user *db.User, update *models.Update) (u.Reaction, error) {
-	library, err := h.db.GetLibrary(user.LibraryID)
-	if err != nil {
-		return u.Reaction{}, err
+func (h Handlers) reactStateConfirmingQuote(user *db.User, update *models.Update) (u.Reaction, error) {
+	var stateData db.StateConfirmingQuoteData
+	if err := json.Unmarshal(user.StateData.Bytes, &stateData); err != nil {
+		if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.TextReaction(update.Message.Chat.ID, s.GoingBackToNormalMode), nil
 	}
 
-	if library.OwnerID != user.ID {
-		return u.ReplyReaction(update.Message, s.OnlyTheOwnerCanAddNewUsers), nil
+	text := strings.TrimSpace(update.Message.Text)
+	draft := &stateData.Draft
+	if stateData.Mode == "" {
+		if text != s.CancelAnswer {
+			// a new quote replaces the one waiting to be saved, whose buttons stop working
+			if !user.ConfirmQuotes {
+				if _, err := h.db.SetUserStateNormal(user.ID); err != nil {
+					return u.Reaction{}, err
+				}
+			}
+			return h.reactDefault(user, update)
+		}
+		if _, err := h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.ReplyReaction(update.Message, s.QuoteDraftDiscarded), nil
 	}
 
-	UUID := uuid.New()
-	_, err = h.db.SetLibraryToken(user.LibraryID, UUID, time.Now().Add(h.LibraryUUIDLifetime))
-	if err != nil {
-		return u.Reaction{}, err
+	if text != s.CancelAnswer {
+		switch stateData.Mode {
+		case db.ConfirmQuoteSourcesMode:
+			draft.MainSource, draft.Sources = "", nil
+			if text != s.DraftEmptyAnswer {
+				draft.Sources = u.ParseDraftSources(text)
+			}
+			if len(draft.Sources) != 0 {
+				draft.MainSource = draft.Sources[0]
+			}
+		case db.ConfirmQuoteTagsMode:
+			syntax, err := u.ParseQuoteSyntax(user.QuoteSyntax)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			draft.Tags = nil
+			if text != s.DraftEmptyAnswer {
+				draft.Tags = u.ParseDraftTags(text, &syntax)
+			}
+		case db.ConfirmQuoteMainSourceMode:
+			if text == "" {
+				return u.ReplyReaction(update.Message, s.EnterDraftMainSource), nil
+			}
+			// the new main source is moved to the start of sources, so it stays the main source if sources are edited
+			sources := []string{text}
+			for _, source := range draft.Sources {
+				if source != text {
+					sources = append(sources, source)
+				}
+			}
+			draft.MainSource, draft.Sources = text, sources
+		default:
+			return u.Reaction{}, errors.New("unknown confirm quote mode")
+		}
 	}
 
-	UUIDLifetimeStr := durafmt.Parse(h.LibraryUUIDLifetime).String()
-	return u.ReplyReaction(update.Message, s.YourLibraryToken(UUID.String(), UUIDLifetimeStr)), nil
+	if _, err := h.db.SetUserStateConfirmingQuote(user.ID, draft, ""); err != nil {
+		return u.Reaction{}, err
+	}
+	return u.Reaction{Messages: []bot.SendMessageParams{
+		quoteDraftMessage(update.Message.Chat.ID, draft.MessageID, u.QuoteFromDraft(draft)),
+	}}, nil
 }
 
-func (h Handlers) reactSetLibraryToken(user *db.User, update *models.Update) (u.Reaction, error) {
-	textParts := strings.Fields(update.Message.Text)
-	if len(textParts) != 2 {
-		return u.ReplyReaction(update.Message, s.MalformedLibraryToken), nil
+func (h Handlers) reactStateEditingOutputFilters(user *db.User, update *models.Update) (u.Reaction, error) {
+	if update.Message.Text == s.CancelAnswer {
+		if _, err := h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.ReplyReaction(update.Message, s.OperationCanceled), nil
 	}
 
-	libraryUUID, err := uuid.Parse(textParts[1])
-	if err != nil {
-		return u.ReplyReaction(update.Message, s.MalformedLibraryToken), nil
+	var stateData db.StateEditingOutputFiltersData
+	if err := json.Unmarshal(user.StateData.Bytes, &stateData); err != nil {
+		if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.TextReaction(update.Message.Chat.ID, s.GoingBackToNormalMode), nil
 	}
 
-	library, err := h.db.GetLibraryByUUID(libraryUUID)
+	output, err := h.db.GetOutput(user.ID, stateData.OutputChatID)
 	if err != nil {
 		if errors.Is(err, db.ErrNotFound) {
-			return u.ReplyReaction(update.Message, s.NoLibraryExistsWithToken), nil
+			if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+				return u.Reaction{}, err
+			}
+			return u.Reaction{Messages: []bot.SendMessageParams{
+				u.TextReplyToMessage(update.Message, s.OutputNoLongerExists),
+				u.TextMessage(update.Message.Chat.ID, s.GoingBackToNormalMode),
+			}}, nil
 		}
+		return u.Reaction{}, err
+	}
 
+	filters, err := u.ParseOutputFilters(output.Filters)
+	if err != nil {
 		return u.Reaction{}, err
 	}
 
-	if library.TokenExpiresOn.Time.Before(time.Now()) {
-		return u.ReplyReaction(update.Message, s.LibraryTokenExpired), nil
+	for _, line := range strings.Split(update.Message.Text, "\n") {
+		if line == "" {
+			continue
+		}
+		lineParts := strings.SplitN(line, ":", 2)
+		if len(lineParts) < 2 {
+			return u.ReplyReaction(update.Message, s.MalformedEditOutputFiltersText), nil
+		}
+
+		option := strings.TrimSpace(lineParts[0])
+		ok, invalidKind := setFilterOption(&filters, option, lineParts[1])
+		if invalidKind != "" {
+			return u.ReplyReaction(update.Message, s.InvalidSourceKind(invalidKind)), nil
+		}
+		if !ok {
+			return u.ReplyReaction(update.Message, s.MalformedEditOutputFiltersText), nil
+		}
 	}
 
-	if _, err = h.db.SetUserStateChangingLibrary(user.ID, library.ID); err != nil {
+	if _, err = h.db.SetOutputFilters(user.ID, output.ChatID, &filters); err != nil {
 		return u.Reaction{}, err
 	}
 
-	message := u.TextReplyToMessage(update.Message, s.MergeOrDeleteCurrentLibraryData)
-	message.ReplyMarkup = u.MergeOrDeleteCurrentLibraryReplyMarkup
-	return u.Reaction{
-		Messages: []bot.SendMessageParams{message},
-	}, nil
+	if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+		return u.Reaction{}, err
+	}
+
+	return u.ReplyReaction(update.Message, s.UpdatedOutputFilters(output.Title, &filters)), nil
+}
+
+// setFilterOption sets an option of filters from an "option: value" line. Returns
+// false if option is not a filter option and the invalid source kind if there is one.
+func setFilterOption(filters *db.OutputFilters, option string, value string) (bool, string) {
+	values := u.ParseListOption(value)
+	if option == s.OUTPUT_FILTER_INCLUDE_KINDS || option == s.OUTPUT_FILTER_EXCLUDE_KINDS {
+		for _, kind := range values {
+			if !u.IsValidSourceKind(kind) {
+				return true, kind
+			}
+		}
+	}
+
+	switch option {
+	case s.OUTPUT_FILTER_INCLUDE_TAGS:
+		filters.IncludeTags = values
+	case s.OUTPUT_FILTER_EXCLUDE_TAGS:
+		filters.ExcludeTags = values
+	case s.OUTPUT_FILTER_INCLUDE_SOURCES:
+		filters.IncludeSources = values
+	case s.OUTPUT_FILTER_EXCLUDE_SOURCES:
+		filters.ExcludeSources = values
+	case s.OUTPUT_FILTER_INCLUDE_KINDS:
+		filters.IncludeSourceKinds = values
+	case s.OUTPUT_FILTER_EXCLUDE_KINDS:
+		filters.ExcludeSourceKinds = values
+	default:
+		return false, ""
+	}
+	return true, ""
+}
+
+func (h Handlers) reactStateEditingDigest(user *db.User, update *models.Update) (u.Reaction, error) {
+	if update.Message.Text == s.CancelAnswer {
+		if _, err := h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.ReplyReaction(update.Message, s.OperationCanceled), nil
+	}
+
+	settings, err := h.userDigestSettings(user)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	outputs, err := h.db.GetOutputs(user.ID)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	for _, line := range strings.Split(update.Message.Text, "\n") {
+		if line == "" {
+			continue
+		}
+		lineParts := strings.SplitN(line, ":", 2)
+		if len(lineParts) < 2 {
+			return u.ReplyReaction(update.Message, s.MalformedEditDigestText), nil
+		}
+
+		option := strings.TrimSpace(lineParts[0])
+		value := strings.TrimSpace(lineParts[1])
+		switch option {
+		case s.DIGEST_OPTION_ENABLED:
+			if value != s.DIGEST_ENABLED_YES && value != s.DIGEST_ENABLED_NO {
+				return u.ReplyReaction(update.Message, s.InvalidDigestEnabled), nil
+			}
+			settings.IsActive = value == s.DIGEST_ENABLED_YES
+		case s.DIGEST_OPTION_TIME:
+			if settings.SendAt, err = u.ParseDigestTime(value); err != nil {
+				return u.ReplyReaction(update.Message, s.InvalidDigestTime), nil
+			}
+		case s.DIGEST_OPTION_TIMEZONE:
+			if settings.Timezone, err = u.ParseTimezone(value); err != nil {
+				return u.ReplyReaction(update.Message, s.InvalidTimezone(value)), nil
+			}
+		case s.DIGEST_OPTION_COUNT:
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 1 || count > u.MAX_DIGEST_COUNT {
+				return u.ReplyReaction(update.Message, s.InvalidDigestCount), nil
+			}
+			settings.Count = int32(count)
+		case s.DIGEST_OPTION_OUTPUT:
+			if value == "" {
+				settings.OutputChatID = sql.NullInt64{}
+				break
+			}
+			output := findOutputByTitle(outputs, value)
+			if output == nil {
+				return u.ReplyReaction(update.Message, s.DigestOutputNotFound(value)), nil
+			}
+			settings.OutputChatID = sql.NullInt64{Valid: true, Int64: output.ChatID}
+		default:
+			ok, invalidKind := setFilterOption(&settings.Filters, option, value)
+			if invalidKind != "" {
+				return u.ReplyReaction(update.Message, s.InvalidSourceKind(invalidKind)), nil
+			}
+			if !ok {
+				return u.ReplyReaction(update.Message, s.MalformedEditDigestText), nil
+			}
+		}
+	}
+
+	if _, err = h.db.SetDigest(user.ID, &settings); err != nil {
+		return u.Reaction{}, err
+	}
+
+	if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+		return u.Reaction{}, err
+	}
+
+	return u.ReplyReaction(update.Message, s.DigestUpdated(&settings, digestOutputTitle(outputs, &settings))), nil
+}
+
+func (h Handlers) reactStateImportingLibrary(user *db.User, update *models.Update) (u.Reaction, error) {
+	if update.Message.Text == s.CancelAnswer {
+		if _, err := h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.ReplyReaction(update.Message, s.OperationCanceled), nil
+	}
+
+	document := update.Message.Document
+	if document == nil {
+		return u.ReplyReaction(update.Message, s.SendImportFileAsDocument), nil
+	}
+
+	format := u.ImportFormat(document.FileName)
+	if format == "" {
+		return u.ReplyReaction(update.Message, s.UnsupportedImportFormat), nil
+	}
+
+	if document.FileSize > u.MAX_IMPORT_FILE_SIZE {
+		return u.ReplyReaction(update.Message, s.ImportFileTooLarge), nil
+	}
+
+	content, err := h.files.Download(document.FileID)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	quotes, err := u.ParseLibraryImport(content, format)
+	if err != nil {
+		if errors.Is(err, u.ErrMalformedImportFile) {
+			return u.ReplyReaction(update.Message, s.MalformedImportFile), nil
+		}
+		return u.Reaction{}, err
+	}
+
+	res, err := h.db.ImportQuotes(user.LibraryID, quotes)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+		return u.Reaction{}, err
+	}
+
+	return u.ReplyReaction(update.Message, s.LibraryImported(res)), nil
+}
+
+func (h Handlers) reactAlreadyJoinedStart(user *db.User, update *models.Update) (u.Reaction, error) {
+	return u.ReplyReaction(update.Message, s.YouAreAlreadyJoined), nil
+}
+
+func (h Handlers) reactGetSources(user *db.User, update *models.Update) (u.Reaction, error) {
+	text := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, s.COMMAND_GET_SOURCES))
+
+	filter, err := m.ParseSourceFilter(text)
+	if err != nil {
+		if errors.Is(err, m.ErrMultipleSourceKindFilters) {
+			return u.ReplyReaction(update.Message, s.OnlyOneSourceKindFilterIsAllowed), nil
+		}
+		return u.Reaction{}, err
+	}
+
+	if len(filter.Text) > u.SOURCE_FILTER_TEXT_MAX_LEN {
+		return u.ReplyReaction(update.Message, s.SourceFilterIsTooLong(u.SOURCE_FILTER_TEXT_MAX_LEN)), nil
+	}
+
+	text, replyMarkup, err := h.sourcesPage(user.LibraryID, filter, 0, false)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	msg := u.TextReplyToMessage(update.Message, text)
+	msg.ParseMode = models.ParseModeMarkdown
+	msg.ReplyMarkup = replyMarkup
+	return u.Reaction{Messages: []bot.SendMessageParams{msg}}, nil
+}
+
+// sourcesPage returns the text and keyboard of a page of sources matching filter. baseID and
+// before are used the same way as in db.QuerySourcesParams, baseID is 0 for the first page.
+func (h Handlers) sourcesPage(libraryID int64, filter m.SourceFilter, baseID int64, before bool) (string, models.InlineKeyboardMarkup, error) {
+	// we are fetching one more elements to check if there is any more pages or not
+	sources, err := h.db.QuerySources(db.QuerySourcesParams{
+		LibraryID:  libraryID,
+		NameQuery:  filter.Text,
+		SourceKind: filter.SourceKind,
+		Limit:      SOURCES_PAGE_LIMIT + 1,
+		BaseID:     baseID,
+		Before:     before,
+	})
+	if err != nil {
+		return "", models.InlineKeyboardMarkup{}, err
+	}
+
+	hasMore := len(sources) > SOURCES_PAGE_LIMIT
+	if hasMore {
+		sources = sources[:SOURCES_PAGE_LIMIT]
+	}
+
+	isFirstPage, isLastPage := baseID == 0, !hasMore
+	if before {
+		// sources before baseID are returned in descending order
+		for i, j := 0, len(sources)-1; i < j; i, j = i+1, j-1 {
+			sources[i], sources[j] = sources[j], sources[i]
+		}
+		isFirstPage, isLastPage = !hasMore, false
+	}
+
+	return s.ListOfSources(sources), u.SourcesReplyMarkup(sources, filter, isFirstPage, isLastPage), nil
+}
+
+func (h Handlers) reactSetActiveSource(user *db.User, update *models.Update) (u.Reaction, error) {
+	// TODO: what happens if we already have an active source?
+	text := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, s.COMMAND_SET_ACTIVE_SOURCE))
+	argsRaw := strings.Split(text, ",")
+	args := make([]string, 0, len(argsRaw))
+
+	for _, arg := range argsRaw {
+		args = append(args, strings.TrimSpace(arg))
+	}
+
+	argsLen := len(args)
+
+	malformedReaction := u.ReplyReaction(update.Message, s.MalformedSetActiveSource(h.defaultActiveSourceTimeoutMins))
+	if text == "" || argsLen > 2 {
+		return malformedReaction, nil
+	}
+
+	activeSourceTimeoutInt := h.defaultActiveSourceTimeoutMins
+	var err error
+	if argsLen == 2 {
+		activeSourceTimeoutInt, err = strconv.Atoi(args[1])
+		if err != nil {
+			return malformedReaction, nil
+		}
+	}
+
+	if activeSourceTimeoutInt <= 0 {
+		return u.ReplyReaction(update.Message, s.SourceTimeoutShouldBeGreaterThanZero), nil
+	}
+	activeSourceExpire := time.Now().Add(time.Minute * time.Duration(activeSourceTimeoutInt))
+
+	_, err = h.db.GetSource(user.LibraryID, args[0])
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return u.ReplyReaction(update.Message, s.SourceDoesNotExist(args[0])), nil
+		}
+
+		return u.Reaction{}, err
+	}
+
+	_, err = h.db.SetActiveSource(user.ID, args[0], activeSourceExpire)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	return u.ReplyReaction(update.Message, s.ActiveSourceIsSet(args[0], activeSourceTimeoutInt)), nil
+}
+
+func (h Handlers) reactHelp(user *db.User, update *models.Update) (u.Reaction, error) {
+	return u.TextReaction(update.Message.Chat.ID, s.Help), nil
+}
+
+func (h Handlers) reactGetOutputs(user *db.User, update *models.Update) (u.Reaction, error) {
+	// FIXME: paginate get outputs
+	outputs, err := h.db.GetOutputs(user.ID)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	msg := u.TextReplyToMessage(update.Message, s.ListOfYourOutputs(outputs))
+	msg.ParseMode = models.ParseModeMarkdown
+
+	replyMarkup, err := u.OutputsReplyMarkup(outputs)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	msg.ReplyMarkup = replyMarkup
+	return u.Reaction{Messages: []bot.SendMessageParams{msg}}, nil
+}
+
+func (h Handlers) reactGetLibraryToken(user *db.User, update *models.Update) (u.Reaction, error) {
+	library, err := h.db.GetLibrary(user.LibraryID)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	if library.OwnerID != user.ID {
+		return u.ReplyReaction(update.Message, s.OnlyTheOwnerCanAddNewUsers), nil
+	}
+
+	UUID := uuid.New()
+	_, err = h.db.SetLibraryToken(user.LibraryID, UUID, time.Now().Add(h.LibraryUUIDLifetime))
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	UUIDLifetimeStr := durafmt.Parse(h.LibraryUUIDLifetime).String()
+	return u.ReplyReaction(update.Message, s.YourLibraryToken(UUID.String(), UUIDLifetimeStr)), nil
+}
+
+func (h Handlers) reactSetLibraryToken(user *db.User, update *models.Update) (u.Reaction, error) {
+	textParts := strings.Fields(update.Message.Text)
+	if len(textParts) != 2 {
+		return u.ReplyReaction(update.Message, s.MalformedLibraryToken), nil
+	}
+
+	libraryUUID, err := uuid.Parse(textParts[1])
+	if err != nil {
+		return u.ReplyReaction(update.Message, s.MalformedLibraryToken), nil
+	}
+
+	library, err := h.db.GetLibraryByUUID(libraryUUID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return u.ReplyReaction(update.Message, s.NoLibraryExistsWithToken), nil
+		}
+
+		return u.Reaction{}, err
+	}
+
+	if library.TokenExpiresOn.Time.Before(time.Now()) {
+		return u.ReplyReaction(update.Message, s.LibraryTokenExpired), nil
+	}
+
+	if _, err = h.db.SetUserStateChangingLibrary(user.ID, library.ID); err != nil {
+		return u.Reaction{}, err
+	}
+
+	message := u.TextReplyToMessage(update.Message, s.MergeOrDeleteCurrentLibraryData)
+	message.ReplyMarkup = u.MergeOrDeleteCurrentLibraryReplyMarkup
+	return u.Reaction{
+		Messages: []bot.SendMessageParams{message},
+	}, nil
+}
+
+func (h Handlers) reactEditQuote(user *db.User, update *models.Update) (u.Reaction, error) {
+	quote, err := h.repliedQuote(user, update.Message)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	if quote == nil {
+		return u.ReplyReaction(update.Message, s.ReplyToQuoteToEdit), nil
+	}
+
+	return h.startEditingQuote(user, update.Message.Chat.ID, quote)
+}
+
+func (h Handlers) reactDeleteQuote(user *db.User, update *models.Update) (u.Reaction, error) {
+	quote, err := h.repliedQuote(user, update.Message)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	if quote == nil {
+		return u.ReplyReaction(update.Message, s.ReplyToQuoteToDelete), nil
+	}
+
+	msg := u.TextReplyToMessage(update.Message, s.ConfirmDeleteQuote)
+	msg.ReplyMarkup = u.ConfirmDeleteQuoteReplyMarkup(quote.ID)
+	return u.Reaction{Messages: []bot.SendMessageParams{msg}}, nil
+}
+
+func (h Handlers) reactExport(user *db.User, update *models.Update) (u.Reaction, error) {
+	format := u.EXPORT_FORMAT_JSON
+	textParts := strings.Fields(update.Message.Text)
+	if len(textParts) > 2 {
+		return u.ReplyReaction(update.Message, s.UnknownExportFormat), nil
+	}
+	if len(textParts) == 2 {
+		format = strings.ToLower(textParts[1])
+	}
+
+	if !u.IsValidExportFormat(format) {
+		return u.ReplyReaction(update.Message, s.UnknownExportFormat), nil
+	}
+
+	quotes, err := h.db.GetLibraryQuotesWithData(user.LibraryID)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	if len(quotes) == 0 {
+		return u.ReplyReaction(update.Message, s.NothingToExport), nil
+	}
+
+	sources, err := h.db.GetLibrarySources(user.LibraryID)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	export := u.MakeLibraryExport(quotes, sources)
+	content, err := export.Encode(format)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	return u.Reaction{Documents: []bot.SendDocumentParams{{
+		ChatID:           update.Message.Chat.ID,
+		ReplyToMessageID: update.Message.ID,
+		Caption:          s.LibraryExported(len(export.Quotes)),
+		Document: &models.InputFileUpload{
+			Filename: "warmlight-" + export.ExportedAt.Format("2006-01-02") + "." + format,
+			Data:     bytes.NewReader(content),
+		},
+	}}}, nil
+}
+
+func (h Handlers) reactImport(user *db.User, update *models.Update) (u.Reaction, error) {
+	if _, err := h.db.SetUserStateImportingLibrary(user.ID); err != nil {
+		return u.Reaction{}, err
+	}
+
+	return u.ReplyReaction(update.Message, s.SendImportFile), nil
+}
+
+func (h Handlers) reactDigest(user *db.User, update *models.Update) (u.Reaction, error) {
+	settings, err := h.userDigestSettings(user)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	outputs, err := h.db.GetOutputs(user.ID)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	if _, err = h.db.SetUserStateEditingDigest(user.ID); err != nil {
+		return u.Reaction{}, err
+	}
+
+	return u.ReplyReaction(update.Message, s.EditDigest(&settings, digestOutputTitle(outputs, &settings))), nil
+}
+
+func (h Handlers) reactReview(user *db.User, update *models.Update) (u.Reaction, error) {
+	// cards of quotes added since the last review are created here, instead of when quotes are created
+	if err := h.db.SyncReviewCards(user.ID, user.LibraryID); err != nil {
+		return u.Reaction{}, err
+	}
+
+	msg, err := nextReviewMessage(h.db, user)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	return u.Reaction{Messages: []bot.SendMessageParams{msg}}, nil
+}
+
+// nextReviewMessage returns the message of the review card which is due the longest.
+func nextReviewMessage(appDB db.Store, user *db.User) (bot.SendMessageParams, error) {
+	card, err := appDB.GetDueReviewCard(user.ID, user.LibraryID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return u.TextMessage(user.ChatID, s.NothingToReview), nil
+		}
+		return bot.SendMessageParams{}, err
+	}
+
+	quote, err := appDB.GetQuoteWithData(user.LibraryID, card.QuoteID)
+	if err != nil {
+		return bot.SendMessageParams{}, err
+	}
+
+	dueCount, err := appDB.CountDueReviewCards(user.ID, user.LibraryID)
+	if err != nil {
+		return bot.SendMessageParams{}, err
+	}
+
+	return bot.SendMessageParams{
+		ChatID:      user.ChatID,
+		ParseMode:   models.ParseModeMarkdown,
+		Text:        s.ReviewQuote(u.QuoteFromDB(quote), dueCount),
+		ReplyMarkup: u.ReviewReplyMarkup(quote.ID),
+	}, nil
+}
+
+func (h Handlers) reactSearch(user *db.User, update *models.Update) (u.Reaction, error) {
+	search, err := m.ParseSearchQuery(strings.TrimPrefix(update.Message.Text, s.COMMAND_SEARCH))
+	if err != nil {
+		if errors.Is(err, m.ErrInvalidSearchDate) {
+			return u.ReplyReaction(update.Message, s.InvalidSearchDate), nil
+		}
+		return u.Reaction{}, err
+	}
+	if search.IsEmpty() {
+		return u.ReplyReaction(update.Message, s.SearchUsage), nil
+	}
+
+	text, replyMarkup, err := h.searchPage(user.LibraryID, &search, 0)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	// results are replied to the search, so next pages can read the search from it
+	msg := u.TextReplyToMessage(update.Message, text)
+	msg.ParseMode = models.ParseModeMarkdown
+	msg.ReplyMarkup = replyMarkup
+	return u.Reaction{Messages: []bot.SendMessageParams{msg}}, nil
+}
+
+func (h Handlers) reactLanguage(user *db.User, update *models.Update) (u.Reaction, error) {
+	text := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, s.COMMAND_LANGUAGE))
+	if text == "" {
+		library, err := h.db.GetLibrary(user.LibraryID)
+		if err != nil {
+			return u.Reaction{}, err
+		}
+		return u.ReplyReaction(update.Message, s.SearchLanguage(library.SearchLanguage)), nil
+	}
+
+	language, supported := db.SearchLanguage(text)
+	if _, err := h.db.SetLibrarySearchLanguage(user.LibraryID, language); err != nil {
+		return u.Reaction{}, err
+	}
+
+	if !supported {
+		return u.ReplyReaction(update.Message, s.SearchLanguageNotSupported(text)), nil
+	}
+	return u.ReplyReaction(update.Message, s.SearchLanguageSet(language)), nil
+}
+
+func (h Handlers) reactSyntax(user *db.User, update *models.Update) (u.Reaction, error) {
+	syntax, err := u.ParseQuoteSyntax(user.QuoteSyntax)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	text := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, s.COMMAND_SYNTAX))
+	if text == "" {
+		return u.ReplyReaction(update.Message, s.QuoteSyntax(&syntax)), nil
+	}
+
+	if text == u.QUOTE_SYNTAX_RESET {
+		if _, err = h.db.SetUserQuoteSyntax(user.ID, nil); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.ReplyReaction(update.Message, s.QuoteSyntaxReset), nil
+	}
+
+	keyword, value := text, ""
+	if i := strings.IndexAny(text, " \t"); i != -1 {
+		keyword, value = text[:i], text[i+1:]
+	}
+	if err = u.SetQuoteSyntaxKeyword(&syntax, keyword, value); err != nil {
+		if errors.Is(err, u.ErrUnknownQuoteSyntaxKeyword) {
+			return u.ReplyReaction(update.Message, s.UnknownQuoteSyntaxKeyword), nil
+		}
+		return u.ReplyReaction(update.Message, s.InvalidQuoteSyntaxKeyword), nil
+	}
+
+	if _, err = h.db.SetUserQuoteSyntax(user.ID, &syntax); err != nil {
+		return u.Reaction{}, err
+	}
+	return u.ReplyReaction(update.Message, s.QuoteSyntaxSet(keyword, strings.TrimSpace(value))), nil
+}
+
+func (h Handlers) reactConfirmQuotes(user *db.User, update *models.Update) (u.Reaction, error) {
+	text := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, s.COMMAND_CONFIRM_QUOTES))
+	switch strings.ToLower(text) {
+	case "on":
+		if _, err := h.db.SetUserConfirmQuotes(user.ID, true); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.ReplyReaction(update.Message, s.ConfirmQuotesEnabled), nil
+	case "off":
+		if _, err := h.db.SetUserConfirmQuotes(user.ID, false); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.ReplyReaction(update.Message, s.ConfirmQuotesDisabled), nil
+	default:
+		return u.ReplyReaction(update.Message, s.ConfirmQuotes(user.ConfirmQuotes)), nil
+	}
+}
+
+// reactUndo reverts the last change of the user in their library which is made in UNDO_WINDOW
+func (h Handlers) reactUndo(user *db.User, update *models.Update) (u.Reaction, error) {
+	activity, err := h.db.GetLastActivity(user.ID, user.LibraryID, time.Now().Add(-UNDO_WINDOW))
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return u.ReplyReaction(update.Message, s.NothingToUndo(durafmt.Parse(UNDO_WINDOW).String())), nil
+		}
+		return u.Reaction{}, err
+	}
+
+	reverted, err := h.db.UndoActivity(user.LibraryID, activity)
+	if err != nil {
+		// another undo has undone the activity at the same time
+		if errors.Is(err, db.ErrNotFound) {
+			return u.ReplyReaction(update.Message, s.NothingToUndo(durafmt.Parse(UNDO_WINDOW).String())), nil
+		}
+		return u.Reaction{}, err
+	}
+	if !reverted {
+		return u.ReplyReaction(update.Message, s.ActivityCanNotBeUndone), nil
+	}
+
+	text, err := activityUndoneText(activity)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+	return u.ReplyReaction(update.Message, text), nil
+}
+
+// activityUndoneText returns the text telling the user the activity is undone
+func activityUndoneText(activity *db.Activity) (string, error) {
+	switch activity.Kind {
+	case db.ActivityKindCreateQuote:
+		return s.QuoteAddingUndone, nil
+	case db.ActivityKindEditSource:
+		var data db.ActivityEditSourceData
+		if err := json.Unmarshal(activity.Data.Bytes, &data); err != nil {
+			return "", err
+		}
+		return s.SourceEditUndone(data.Name), nil
+	case db.ActivityKindMergeTags:
+		var data db.ActivityMergeTagsData
+		if err := json.Unmarshal(activity.Data.Bytes, &data); err != nil {
+			return "", err
+		}
+		return s.TagsMergeUndone(data.FromTagName), nil
+	default:
+		return "", errors.New("unknown activity kind")
+	}
+}
+
+// reactPreview shows how the quote after the command is parsed, without saving it
+func (h Handlers) reactPreview(user *db.User, update *models.Update) (u.Reaction, error) {
+	syntax, err := u.ParseQuoteSyntax(user.QuoteSyntax)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	// the command is removed from the text, so offsets of entities are moved back with it.
+	// Commands are ASCII, so their length is the same in UTF-16 code units.
+	text := strings.TrimPrefix(update.Message.Text, s.COMMAND_PREVIEW)
+	commandLength := len(s.COMMAND_PREVIEW)
+	entities := []db.TextEntity{}
+	for _, entity := range u.TextEntitiesFromMessage(update.Message.Entities) {
+		if entity.Offset >= commandLength {
+			entity.Offset -= commandLength
+			entities = append(entities, entity)
+		}
+	}
+
+	q, err := u.ParseQuoteWithSyntax(text, entities, &syntax)
+	if err != nil {
+		if errors.Is(err, u.ErrQuoteTextBlockNotClosed) {
+			return u.ReplyReaction(update.Message, s.QuoteTextBlockNotClosed), nil
+		}
+		return u.ReplyReaction(update.Message, s.SendQuoteToPreview), nil
+	}
+
+	msg := u.TextReplyToMessage(update.Message, s.QuotePreview(q))
+	msg.ParseMode = models.ParseModeMarkdown
+	return u.Reaction{Messages: []bot.SendMessageParams{msg}}, nil
+}
+
+// searchPage returns the text and keyboard of the page of quotes matching search which
+// starts from offset.
+func (h Handlers) searchPage(libraryID int64, search *db.QuoteSearch, offset int32) (string, models.InlineKeyboardMarkup, error) {
+	// we are fetching one more elements to check if there is any more pages or not
+	quotes, err := h.db.SearchQuotes(libraryID, search, SEARCH_PAGE_LIMIT+1, offset)
+	if err != nil {
+		return "", models.InlineKeyboardMarkup{}, err
+	}
+
+	hasMore := len(quotes) > SEARCH_PAGE_LIMIT
+	if hasMore {
+		quotes = quotes[:SEARCH_PAGE_LIMIT]
+	}
+
+	return s.ListOfSearchResults(quotes, offset), u.SearchResultsReplyMarkup(quotes, offset, SEARCH_PAGE_LIMIT, !hasMore), nil
+}
+
+func (h Handlers) reactTags(user *db.User, update *models.Update) (u.Reaction, error) {
+	text, replyMarkup, err := h.tagsPage(user.LibraryID, 0, false)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	msg := u.TextReplyToMessage(update.Message, text)
+	msg.ParseMode = models.ParseModeMarkdown
+	msg.ReplyMarkup = replyMarkup
+	return u.Reaction{Messages: []bot.SendMessageParams{msg}}, nil
+}
+
+// tagsPage returns the text and keyboard of a page of tags. baseID and before
+// are used the same way as in db.QueryTagsParams, baseID is 0 for the first page.
+func (h Handlers) tagsPage(libraryID int64, baseID int64, before bool) (string, models.InlineKeyboardMarkup, error) {
+	// we are fetching one more elements to check if there is any more pages or not
+	tags, err := h.db.QueryTags(db.QueryTagsParams{
+		LibraryID: libraryID,
+		Limit:     TAGS_PAGE_LIMIT + 1,
+		BaseID:    baseID,
+		Before:    before,
+	})
+	if err != nil {
+		return "", models.InlineKeyboardMarkup{}, err
+	}
+
+	hasMore := len(tags) > TAGS_PAGE_LIMIT
+	if hasMore {
+		tags = tags[:TAGS_PAGE_LIMIT]
+	}
+
+	isFirstPage, isLastPage := baseID == 0, !hasMore
+	if before {
+		// tags before baseID are returned in descending order
+		for i, j := 0, len(tags)-1; i < j; i, j = i+1, j-1 {
+			tags[i], tags[j] = tags[j], tags[i]
+		}
+		isFirstPage, isLastPage = !hasMore, false
+	}
+
+	return s.ListOfTags(tags), u.TagsReplyMarkup(tags, isFirstPage, isLastPage), nil
+}
+
+func (h Handlers) reactStateEditingTag(user *db.User, update *models.Update) (u.Reaction, error) {
+	if update.Message.Text == s.CancelAnswer {
+		if _, err := h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.ReplyReaction(update.Message, s.OperationCanceled), nil
+	}
+
+	var stateData db.StateEditingTagData
+	if err := json.Unmarshal(user.StateData.Bytes, &stateData); err != nil {
+		if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.TextReaction(update.Message.Chat.ID, s.GoingBackToNormalMode), nil
+	}
+
+	tag, err := h.db.GetTagByID(user.LibraryID, stateData.TagID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+				return u.Reaction{}, err
+			}
+			return u.Reaction{Messages: []bot.SendMessageParams{
+				u.TextReplyToMessage(update.Message, s.TagNoLongerExists),
+				u.TextMessage(update.Message.Chat.ID, s.GoingBackToNormalMode),
+			}}, nil
+		}
+		return u.Reaction{}, err
+	}
+
+	name, ok := u.ParseTagName(update.Message.Text)
+	if !ok {
+		return u.ReplyReaction(update.Message, s.InvalidTagName), nil
+	}
+
+	existing, err := h.db.GetTag(user.LibraryID, name)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			return u.Reaction{}, err
+		}
+		existing = nil
+	}
+
+	var text string
+	switch stateData.Mode {
+	case db.EditTagRenameMode:
+		if existing != nil && existing.ID != tag.ID {
+			return u.ReplyReaction(update.Message, s.TagAlreadyExists(name)), nil
+		}
+		if _, err = h.db.RenameTag(user.LibraryID, tag.ID, name); err != nil {
+			return u.Reaction{}, err
+		}
+		text = s.TagRenamed(tag.Name, name)
+	case db.EditTagMergeMode:
+		if existing == nil {
+			return u.ReplyReaction(update.Message, s.TagNotFound(name)), nil
+		}
+		if existing.ID == tag.ID {
+			return u.ReplyReaction(update.Message, s.CanNotMergeTagIntoItself), nil
+		}
+		if err = h.db.MergeTags(user.ID, user.LibraryID, tag.ID, existing.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		text = s.TagsMerged(tag.Name, existing.Name)
+	default:
+		if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+		return u.TextReaction(update.Message.Chat.ID, s.GoingBackToNormalMode), nil
+	}
+
+	if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+		return u.Reaction{}, err
+	}
+
+	return u.ReplyReaction(update.Message, text), nil
+}
+
+func (h Handlers) userDigestSettings(user *db.User) (db.DigestSettings, error) {
+	digest, err := h.db.GetDigest(user.ID)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			return db.DigestSettings{}, err
+		}
+		digest = nil
+	}
+
+	return u.DigestSettingsFromDB(digest)
+}
+
+func findOutputByTitle(outputs []db.Output, title string) *db.Output {
+	for _, output := range outputs {
+		if strings.EqualFold(output.Title, title) {
+			return &output
+		}
+	}
+	return nil
+}
+
+// digestOutputTitle returns the title of the output digest is sent to, or an
+// empty string if it's sent to the chat of user.
+func digestOutputTitle(outputs []db.Output, settings *db.DigestSettings) string {
+	if !settings.OutputChatID.Valid {
+		return ""
+	}
+	for _, output := range outputs {
+		if output.ChatID == settings.OutputChatID.Int64 {
+			return output.Title
+		}
+	}
+	return ""
+}
+
+// repliedQuote returns the quote of the "Quote added" message which message is replying to.
+// The quote is found by the ID in the buttons of the message. returns nil if no quote was found.
+func (h Handlers) repliedQuote(user *db.User, message *models.Message) (*db.QuoteWithData, error) {
+	replied := message.ReplyToMessage
+	if replied == nil {
+		return nil, nil
+	}
+
+	quoteID, ok := u.QuoteIDFromReplyMarkup(replied.ReplyMarkup)
+	if !ok {
+		return nil, nil
+	}
+
+	quote, err := h.db.GetQuoteWithData(user.LibraryID, quoteID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return quote, nil
+}
+
+func (h Handlers) startEditingQuote(user *db.User, chatID int64, quote *db.QuoteWithData) (u.Reaction, error) {
+	syntax, err := u.ParseQuoteSyntax(user.QuoteSyntax)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+	if _, err = h.db.SetUserStateEditingQuote(user.ID, quote.ID); err != nil {
+		return u.Reaction{}, err
+	}
+
+	return u.Reaction{Messages: []bot.SendMessageParams{{
+		ChatID:    chatID,
+		Text:      s.EditQuote(u.QuoteFromDB(quote), &syntax),
+		ParseMode: models.ParseModeMarkdown,
+	}}}, nil
 }
 
 func (h Handlers) reactMyChatMember(update *models.Update) (u.Reaction, error) {
@@ -635,135 +1784,484 @@ func (h Handlers) reactDeactivateSource(user *db.User, update *models.Update) (u
 	return u.ReplyReaction(update.Message, s.ActiveSourceDeactivated(activeSource)), nil
 }
 
-func (h Handlers) reactCallbackQuery(update *models.Update) (u.Reaction, error) {
-	// TODO: test reactCallbackQuery
-	user, err := h.db.GetUser(update.CallbackQuery.Sender.ID)
-	if err != nil {
-		if !errors.Is(err, db.ErrNotFound) {
-			return u.Reaction{}, err
-		}
-		return u.Reaction{}, nil
-	}
+func (h Handlers) reactCallbackQuery(update *models.Update) (u.Reaction, error) {
+	// TODO: test reactCallbackQuery
+	user, err := h.db.GetUser(update.CallbackQuery.Sender.ID)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			return u.Reaction{}, err
+		}
+		return u.Reaction{}, nil
+	}
+
+	callbackData, err := m.UnmarshalCallbackData(update.CallbackQuery.Data)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	if user.State == db.UserStateChangingLibrary {
+		if callbackData.Action == m.CALLBACK_COMMAND_DELETE_LIBRARY || callbackData.Action == m.CALLBACK_COMMAND_MERGE_LIBRARY {
+			var stateData db.StateChangingLibraryData
+			if err = json.Unmarshal(user.StateData.Bytes, &stateData); err != nil {
+				return u.Reaction{}, err
+			}
+
+			library, err := h.db.GetLibrary(stateData.LibraryID)
+			if err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+						return u.Reaction{}, err
+					}
+					return u.TextReaction(user.ChatID, s.LibraryNoLongerExistsOPCanceled), nil
+				}
+				return u.Reaction{}, err
+			}
+
+			if library.TokenExpiresOn.Time.Before(time.Now()) {
+				if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+					return u.Reaction{}, err
+				}
+				return u.TextReaction(user.ChatID, s.LibraryTokenExpired), nil
+			}
+
+			mode := db.ChangeLibraryMergeMode
+			if callbackData.Action == m.CALLBACK_COMMAND_DELETE_LIBRARY {
+				mode = db.ChangeLibraryDeleteMode
+			}
+
+			_, err = h.db.SetUserStateConfirmingLibraryChange(user.ID, stateData.LibraryID, mode)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+
+			text := s.ConfirmLibraryChange(s.ConfirmLibraryChangeYesAnswer, s.CancelAnswer)
+			return u.TextReaction(user.ChatID, text), nil
+		}
+	}
+
+	if callbackData.Action != "" {
+		switch callbackData.Action {
+		case m.CALLBACK_COMMAND_ACTIVATE_OUTPUT:
+			outputChatID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			if _, err = h.db.ActivateOutput(user.ID, outputChatID); err != nil {
+				return u.Reaction{}, err
+			}
+		case m.CALLBACK_COMMAND_DEACTIVATE_OUTPUT:
+			outputChatID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			if _, err = h.db.DeactivateOutput(user.ID, outputChatID); err != nil {
+				return u.Reaction{}, err
+			}
+		case m.CALLBACK_COMMAND_OUTPUT_FILTERS:
+			outputChatID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			output, err := h.db.GetOutput(user.ID, outputChatID)
+			if err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					return u.TextReaction(user.ChatID, s.OutputNoLongerExists), nil
+				}
+				return u.Reaction{}, err
+			}
+
+			filters, err := u.ParseOutputFilters(output.Filters)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+
+			if _, err = h.db.SetUserStateEditingOutputFilters(user.ID, outputChatID); err != nil {
+				return u.Reaction{}, err
+			}
+
+			return u.TextReaction(user.ChatID, s.EditOutputFilters(output.Title, &filters)), nil
+		case m.CALLBACK_COMMAND_SOURCE_INFO:
+			sourceID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			source, err := h.db.GetSourceByID(user.LibraryID, sourceID)
+			if err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					return u.TextReaction(user.ChatID, s.SourceNoLongerExists), nil
+				}
+				return u.Reaction{}, err
+			}
+
+			sourceData, err := u.ParseSourceData(source.Kind, source.Data)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+
+			infoStr, err := s.SourceInfo(source, sourceData)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			return u.TextReaction(user.ChatID, infoStr), nil
+		case m.CALLBACK_COMMAND_SOURCE_EDIT:
+			sourceID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			source, err := h.db.GetSourceByID(user.LibraryID, sourceID)
+			if err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					return u.TextReaction(user.ChatID, s.SourceNoLongerExists), nil
+				}
+				return u.Reaction{}, err
+			}
+
+			if _, err = h.db.SetUserStateEditingSource(user.ID, sourceID); err != nil {
+				return u.Reaction{}, err
+			}
 
-	callbackData, err := m.UnmarshalCallbackData(update.CallbackQuery.Data)
-	if err != nil {
-		return u.Reaction{}, err
-	}
+			sourceData, err := u.ParseSourceData(source.Kind, source.Data)
+			if err != nil {
+				return u.Reaction{}, err
+			}
 
-	if user.State == db.UserStateChangingLibrary {
-		if callbackData.Action == m.CALLBACK_COMMAND_DELETE_LIBRARY || callbackData.Action == m.CALLBACK_COMMAND_MERGE_LIBRARY {
-			var stateData db.StateChangingLibraryData
-			if err = json.Unmarshal(user.StateData.Bytes, &stateData); err != nil {
+			msgText, err := s.EditSource(source, sourceData)
+			if err != nil {
 				return u.Reaction{}, err
 			}
 
-			library, err := h.db.GetLibrary(stateData.LibraryID)
+			return u.TextReaction(user.ChatID, msgText), nil
+		case m.CALLBACK_COMMAND_SOURCE_MERGE, m.CALLBACK_COMMAND_SOURCE_DELETE:
+			sourceID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			source, err := h.db.GetSourceByID(user.LibraryID, sourceID)
 			if err != nil {
 				if errors.Is(err, db.ErrNotFound) {
-					if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
-						return u.Reaction{}, err
-					}
-					return u.TextReaction(user.ChatID, s.LibraryNoLongerExistsOPCanceled), nil
+					return u.TextReaction(user.ChatID, s.SourceNoLongerExists), nil
 				}
 				return u.Reaction{}, err
 			}
 
-			if library.TokenExpiresOn.Time.Before(time.Now()) {
-				if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+			if callbackData.Action == m.CALLBACK_COMMAND_SOURCE_MERGE {
+				if _, err = h.db.SetUserStateMergingSource(user.ID, sourceID); err != nil {
 					return u.Reaction{}, err
 				}
-				return u.TextReaction(user.ChatID, s.LibraryTokenExpired), nil
+				return u.TextReaction(user.ChatID, s.EnterSourceToMergeInto(source.Name)), nil
 			}
 
-			mode := db.ChangeLibraryMergeMode
-			if callbackData.Action == m.CALLBACK_COMMAND_DELETE_LIBRARY {
-				mode = db.ChangeLibraryDeleteMode
+			if _, err = h.db.SetUserStateConfirmingSourceChange(user.ID, sourceID, 0, db.ChangeSourceDeleteMode); err != nil {
+				return u.Reaction{}, err
+			}
+			return u.TextReaction(user.ChatID, s.ConfirmDeleteSource(source.Name)), nil
+		case m.CALLBACK_COMMAND_QUOTE_EDIT:
+			quoteID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			quote, err := h.db.GetQuoteWithData(user.LibraryID, quoteID)
+			if err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					return u.TextReaction(user.ChatID, s.QuoteNoLongerExists), nil
+				}
+				return u.Reaction{}, err
 			}
 
-			_, err = h.db.SetUserStateConfirmingLibraryChange(user.ID, stateData.LibraryID, mode)
+			return h.startEditingQuote(user, user.ChatID, quote)
+		case m.CALLBACK_COMMAND_QUOTE_DELETE:
+			quoteID, err := strconv.ParseInt(callbackData.Data, 10, 0)
 			if err != nil {
 				return u.Reaction{}, err
 			}
 
-			text := s.ConfirmLibraryChange(s.ConfirmLibraryChangeYesAnswer, s.ConfirmLibraryChangeCancelAnswer)
-			return u.TextReaction(user.ChatID, text), nil
-		}
-	}
+			msg := u.TextMessage(user.ChatID, s.ConfirmDeleteQuote)
+			msg.ReplyMarkup = u.ConfirmDeleteQuoteReplyMarkup(quoteID)
+			return u.Reaction{Messages: []bot.SendMessageParams{msg}}, nil
+		case m.CALLBACK_COMMAND_QUOTE_CONFIRM_DELETE:
+			quoteID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			if err = h.db.DeleteQuote(user.LibraryID, quoteID); err != nil {
+				return u.Reaction{}, err
+			}
 
-	if callbackData.Action != "" {
-		switch callbackData.Action {
-		case m.CALLBACK_COMMAND_ACTIVATE_OUTPUT:
-			outputChatID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+			return u.Reaction{
+				EditMessages: []bot.EditMessageTextParams{
+					{
+						ChatID:    update.CallbackQuery.Message.Chat.ID,
+						MessageID: update.CallbackQuery.Message.ID,
+						Text:      s.QuoteDeleted,
+					},
+				},
+			}, nil
+		case m.CALLBACK_COMMAND_QUOTE_VIEW, m.CALLBACK_COMMAND_QUOTE_COPY:
+			quoteID, err := strconv.ParseInt(callbackData.Data, 10, 0)
 			if err != nil {
 				return u.Reaction{}, err
 			}
-			if _, err = h.db.ActivateOutput(user.ID, outputChatID); err != nil {
+			quote, err := h.db.GetQuoteWithData(user.LibraryID, quoteID)
+			if err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					return u.TextReaction(user.ChatID, s.QuoteNoLongerExists), nil
+				}
 				return u.Reaction{}, err
 			}
-		case m.CALLBACK_COMMAND_DEACTIVATE_OUTPUT:
-			outputChatID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+
+			msg := bot.SendMessageParams{ChatID: user.ChatID, ParseMode: models.ParseModeMarkdown}
+			if callbackData.Action == m.CALLBACK_COMMAND_QUOTE_COPY {
+				syntax, err := u.ParseQuoteSyntax(user.QuoteSyntax)
+				if err != nil {
+					return u.Reaction{}, err
+				}
+				msg.Text = s.CopyQuote(u.QuoteFromDB(quote), &syntax)
+			} else {
+				msg.Text = s.Quote(u.QuoteFromDB(quote))
+				msg.ReplyMarkup = u.QuoteActionsReplyMarkup(quote.ID)
+			}
+			return u.Reaction{Messages: []bot.SendMessageParams{msg}}, nil
+		case m.CALLBACK_COMMAND_QUOTE_FORWARD:
+			quoteID, err := strconv.ParseInt(callbackData.Data, 10, 0)
 			if err != nil {
 				return u.Reaction{}, err
 			}
-			if _, err = h.db.DeactivateOutput(user.ID, outputChatID); err != nil {
+			outputs, err := h.db.GetOutputs(user.ID)
+			if err != nil {
 				return u.Reaction{}, err
 			}
-		case m.CALLBACK_COMMAND_SOURCE_INFO:
-			sourceID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+			if len(outputs) == 0 {
+				return u.TextReaction(user.ChatID, s.NoOutputsToForwardQuote), nil
+			}
+
+			msg := u.TextMessage(user.ChatID, s.ChooseOutputToForwardQuote)
+			msg.ReplyMarkup = u.ForwardQuoteReplyMarkup(quoteID, outputs)
+			return u.Reaction{Messages: []bot.SendMessageParams{msg}}, nil
+		case m.CALLBACK_COMMAND_QUOTE_SEND_TO_OUTPUT:
+			quoteID, outputChatID, err := u.ParseSendToOutputCallbackData(callbackData.Data)
 			if err != nil {
 				return u.Reaction{}, err
 			}
-			source, err := h.db.GetSourceByID(user.LibraryID, sourceID)
+			output, err := h.db.GetOutput(user.ID, outputChatID)
 			if err != nil {
 				if errors.Is(err, db.ErrNotFound) {
-					return u.TextReaction(user.ChatID, s.SourceNoLongerExists), nil
+					return u.TextReaction(user.ChatID, s.OutputNoLongerExists), nil
+				}
+				return u.Reaction{}, err
+			}
+			quote, err := h.db.GetQuoteWithData(user.LibraryID, quoteID)
+			if err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					return u.TextReaction(user.ChatID, s.QuoteNoLongerExists), nil
 				}
 				return u.Reaction{}, err
 			}
 
-			sourceData, err := u.ParseSourceData(source.Kind, source.Data)
+			return u.Reaction{
+				Messages: []bot.SendMessageParams{
+					{ChatID: output.ChatID, ParseMode: models.ParseModeMarkdown, Text: s.Quote(u.QuoteFromDB(quote))},
+				},
+				EditMessages: []bot.EditMessageTextParams{
+					{
+						ChatID:    update.CallbackQuery.Message.Chat.ID,
+						MessageID: update.CallbackQuery.Message.ID,
+						Text:      s.QuoteForwarded(output.Title),
+					},
+				},
+			}, nil
+		case m.CALLBACK_COMMAND_TAG_RENAME, m.CALLBACK_COMMAND_TAG_MERGE:
+			tagID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			tag, err := h.db.GetTagByID(user.LibraryID, tagID)
 			if err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					return u.TextReaction(user.ChatID, s.TagNoLongerExists), nil
+				}
 				return u.Reaction{}, err
 			}
 
-			infoStr, err := s.SourceInfo(source, sourceData)
+			mode, text := db.EditTagRenameMode, s.EnterNewTagName(tag.Name)
+			if callbackData.Action == m.CALLBACK_COMMAND_TAG_MERGE {
+				mode, text = db.EditTagMergeMode, s.EnterTagToMergeInto(tag.Name)
+			}
+
+			if _, err = h.db.SetUserStateEditingTag(user.ID, tagID, mode); err != nil {
+				return u.Reaction{}, err
+			}
+			return u.TextReaction(user.ChatID, text), nil
+		case m.CALLBACK_COMMAND_TAG_DELETE:
+			tagID, err := strconv.ParseInt(callbackData.Data, 10, 0)
 			if err != nil {
 				return u.Reaction{}, err
 			}
-			return u.TextReaction(user.ChatID, infoStr), nil
-		case m.CALLBACK_COMMAND_SOURCE_EDIT:
-			sourceID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+			tag, err := h.db.GetTagByID(user.LibraryID, tagID)
 			if err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					return u.TextReaction(user.ChatID, s.TagNoLongerExists), nil
+				}
 				return u.Reaction{}, err
 			}
-			source, err := h.db.GetSourceByID(user.LibraryID, sourceID)
+
+			msg := u.TextMessage(user.ChatID, s.ConfirmDeleteTag(tag.Name))
+			msg.ReplyMarkup = u.ConfirmDeleteTagReplyMarkup(tagID)
+			return u.Reaction{Messages: []bot.SendMessageParams{msg}}, nil
+		case m.CALLBACK_COMMAND_TAG_CONFIRM_DELETE:
+			tagID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			tag, err := h.db.GetTagByID(user.LibraryID, tagID)
 			if err != nil {
 				if errors.Is(err, db.ErrNotFound) {
-					return u.TextReaction(user.ChatID, s.SourceNoLongerExists), nil
+					return u.TextReaction(user.ChatID, s.TagNoLongerExists), nil
 				}
 				return u.Reaction{}, err
 			}
+			if err = h.db.DeleteTag(user.LibraryID, tagID); err != nil {
+				return u.Reaction{}, err
+			}
 
-			if _, err = h.db.SetUserStateEditingSource(user.ID, sourceID); err != nil {
+			return u.Reaction{
+				EditMessages: []bot.EditMessageTextParams{
+					{
+						ChatID:    update.CallbackQuery.Message.Chat.ID,
+						MessageID: update.CallbackQuery.Message.ID,
+						Text:      s.TagDeleted(tag.Name),
+					},
+				},
+			}, nil
+		case m.CALLBACK_COMMAND_DRAFT_SAVE, m.CALLBACK_COMMAND_DRAFT_DISCARD, m.CALLBACK_COMMAND_DRAFT_SOURCES,
+			m.CALLBACK_COMMAND_DRAFT_TAGS, m.CALLBACK_COMMAND_DRAFT_MAIN_SOURCE:
+			return h.reactQuoteDraftCallback(user, update, &callbackData)
+		case m.CALLBACK_COMMAND_REVIEW_QUOTE:
+			quoteID, grade, err := u.ParseReviewCallbackData(callbackData.Data)
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			card, err := h.db.GetReviewCard(user.ID, user.LibraryID, quoteID)
+			if err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					return u.TextReaction(user.ChatID, s.QuoteNoLongerExists), nil
+				}
 				return u.Reaction{}, err
 			}
 
-			sourceData, err := u.ParseSourceData(source.Kind, source.Data)
+			schedule, err := u.ScheduleReview(card, grade, time.Now())
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			if _, err = h.db.UpdateReviewCard(user.ID, quoteID, &schedule); err != nil {
+				return u.Reaction{}, err
+			}
+
+			// text of the message has lost its formatting, so the reviewed quote is sent again with the edit
+			quote, err := h.db.GetQuoteWithData(user.LibraryID, quoteID)
 			if err != nil {
 				return u.Reaction{}, err
 			}
 
-			msgText, err := s.EditSource(source, sourceData)
+			nextMsg, err := nextReviewMessage(h.db, user)
 			if err != nil {
 				return u.Reaction{}, err
 			}
 
-			return u.TextReaction(user.ChatID, msgText), nil
+			return u.Reaction{
+				Messages: []bot.SendMessageParams{nextMsg},
+				EditMessages: []bot.EditMessageTextParams{
+					{
+						ChatID:    update.CallbackQuery.Message.Chat.ID,
+						MessageID: update.CallbackQuery.Message.ID,
+						ParseMode: models.ParseModeMarkdown,
+						Text:      s.Quote(u.QuoteFromDB(quote)) + bot.EscapeMarkdown("\n\n"+s.QuoteReviewed(schedule.IntervalDays)),
+					},
+				},
+			}, nil
 		default:
 			return u.Reaction{}, errors.New("unknown callback data action")
 		}
 	}
 
+	if callbackData.ReplaceMessageWith == m.CALLBACK_MSG_NEXT_TAG_PAGE || callbackData.ReplaceMessageWith == m.CALLBACK_MSG_PREV_TAG_PAGE {
+		baseID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+		if err != nil {
+			return u.Reaction{}, err
+		}
+
+		text, replyMarkup, err := h.tagsPage(user.LibraryID, baseID, callbackData.ReplaceMessageWith == m.CALLBACK_MSG_PREV_TAG_PAGE)
+		if err != nil {
+			return u.Reaction{}, err
+		}
+
+		return u.Reaction{
+			EditMessages: []bot.EditMessageTextParams{
+				{
+					ChatID:      update.CallbackQuery.Message.Chat.ID,
+					MessageID:   update.CallbackQuery.Message.ID,
+					Text:        text,
+					ParseMode:   models.ParseModeMarkdown,
+					ReplyMarkup: replyMarkup,
+				},
+			},
+		}, nil
+	}
+
+	if callbackData.ReplaceMessageWith == m.CALLBACK_MSG_NEXT_SOURCE_PAGE || callbackData.ReplaceMessageWith == m.CALLBACK_MSG_PREV_SOURCE_PAGE {
+		baseID, filter, err := u.ParseSourcePageCallbackData(callbackData.Data)
+		if err != nil {
+			return u.Reaction{}, err
+		}
+
+		text, replyMarkup, err := h.sourcesPage(user.LibraryID, filter, baseID, callbackData.ReplaceMessageWith == m.CALLBACK_MSG_PREV_SOURCE_PAGE)
+		if err != nil {
+			return u.Reaction{}, err
+		}
+
+		return u.Reaction{
+			EditMessages: []bot.EditMessageTextParams{
+				{
+					ChatID:      update.CallbackQuery.Message.Chat.ID,
+					MessageID:   update.CallbackQuery.Message.ID,
+					Text:        text,
+					ParseMode:   models.ParseModeMarkdown,
+					ReplyMarkup: replyMarkup,
+				},
+			},
+		}, nil
+	}
+
+	if callbackData.ReplaceMessageWith == m.CALLBACK_MSG_SEARCH_PAGE {
+		offset, err := strconv.ParseInt(callbackData.Data, 10, 32)
+		if err != nil {
+			return u.Reaction{}, err
+		}
+
+		text, replyMarkup := bot.EscapeMarkdown(s.SearchIsNoLongerAvailable), models.InlineKeyboardMarkup{}
+		searchMessage := update.CallbackQuery.Message.ReplyToMessage
+		if searchMessage != nil {
+			search, err := m.ParseSearchQuery(strings.TrimPrefix(searchMessage.Text, s.COMMAND_SEARCH))
+			if err != nil {
+				return u.Reaction{}, err
+			}
+			if text, replyMarkup, err = h.searchPage(user.LibraryID, &search, int32(offset)); err != nil {
+				return u.Reaction{}, err
+			}
+		}
+
+		return u.Reaction{
+			EditMessages: []bot.EditMessageTextParams{
+				{
+					ChatID:      update.CallbackQuery.Message.Chat.ID,
+					MessageID:   update.CallbackQuery.Message.ID,
+					Text:        text,
+					ParseMode:   models.ParseModeMarkdown,
+					ReplyMarkup: replyMarkup,
+				},
+			},
+		}, nil
+	}
+
 	if callbackData.ReplaceMessageWith == m.CALLBACK_MSG_OUTPUTS_LIST {
 		outputs, err := h.db.GetOutputs(user.ID)
 		if err != nil {
@@ -791,30 +2289,129 @@ func (h Handlers) reactCallbackQuery(update *models.Update) (u.Reaction, error)
 	return u.Reaction{}, nil
 }
 
-func (h Handlers) reactInlineQuery(update *models.Update) ([]models.InlineQueryResult, error) {
-	// TODO: test reactInlineQuery
+// reactQuoteDraftCallback reacts to the buttons of a quote draft, buttons of drafts which are
+// already saved or discarded only remove themselves
+func (h Handlers) reactQuoteDraftCallback(user *db.User, update *models.Update, callbackData *m.CallbackData) (u.Reaction, error) {
+	messageID, err := strconv.Atoi(callbackData.Data)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	var stateData db.StateConfirmingQuoteData
+	if user.State == db.UserStateConfirmingQuote {
+		if err = json.Unmarshal(user.StateData.Bytes, &stateData); err != nil {
+			return u.Reaction{}, err
+		}
+	}
+	if user.State != db.UserStateConfirmingQuote || stateData.Draft.MessageID != messageID {
+		return u.Reaction{
+			EditMessages: []bot.EditMessageTextParams{
+				{
+					ChatID:    update.CallbackQuery.Message.Chat.ID,
+					MessageID: update.CallbackQuery.Message.ID,
+					Text:      s.QuoteDraftNoLongerExists,
+				},
+			},
+		}, nil
+	}
+
+	draft := &stateData.Draft
+	switch callbackData.Action {
+	case m.CALLBACK_COMMAND_DRAFT_SAVE:
+		q := u.QuoteFromDraft(draft)
+		messages, err := h.addQuote(user, user.ChatID, draft.MessageID, q)
+		if err != nil {
+			return u.Reaction{}, err
+		}
+		if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+
+		return u.Reaction{
+			Messages: messages,
+			EditMessages: []bot.EditMessageTextParams{
+				{
+					ChatID:    update.CallbackQuery.Message.Chat.ID,
+					MessageID: update.CallbackQuery.Message.ID,
+					Text:      s.Quote(q),
+					ParseMode: models.ParseModeMarkdown,
+				},
+			},
+		}, nil
+	case m.CALLBACK_COMMAND_DRAFT_DISCARD:
+		if _, err = h.db.SetUserStateNormal(user.ID); err != nil {
+			return u.Reaction{}, err
+		}
+
+		return u.Reaction{
+			EditMessages: []bot.EditMessageTextParams{
+				{
+					ChatID:    update.CallbackQuery.Message.Chat.ID,
+					MessageID: update.CallbackQuery.Message.ID,
+					Text:      s.QuoteDraftDiscarded,
+				},
+			},
+		}, nil
+	}
+
+	mode, text := db.ConfirmQuoteSourcesMode, s.EnterDraftSources
+	if callbackData.Action == m.CALLBACK_COMMAND_DRAFT_TAGS {
+		mode, text = db.ConfirmQuoteTagsMode, s.EnterDraftTags
+	} else if callbackData.Action == m.CALLBACK_COMMAND_DRAFT_MAIN_SOURCE {
+		mode, text = db.ConfirmQuoteMainSourceMode, s.EnterDraftMainSource
+	}
+	if _, err = h.db.SetUserStateConfirmingQuote(user.ID, draft, mode); err != nil {
+		return u.Reaction{}, err
+	}
+	return u.TextReaction(user.ChatID, text), nil
+}
+
+// reactInlineQuery returns results of the inline query and the offset of their next page,
+// which is empty if there are no more results. Empty queries return the newest quotes.
+func (h Handlers) reactInlineQuery(update *models.Update) ([]models.InlineQueryResult, string, error) {
 	if update.InlineQuery.From.IsBot {
-		return nil, nil
+		return nil, "", nil
 	}
 
 	user, err := h.db.GetUser(update.InlineQuery.From.ID)
 	if err != nil {
 		if !errors.Is(err, db.ErrNotFound) {
-			return nil, err
+			return nil, "", err
 		}
-		return nil, nil
+		return nil, "", nil
 	}
 
-	query := strings.Join(strings.Fields(update.InlineQuery.Query), " & ")
-	// https://core.telegram.org/bots/api#answerinlinequery no more than 50 results per query is allowed
-	quotes, err := h.db.SearchQuotes(user.LibraryID, query, 50)
+	search, err := m.ParseSearchQuery(update.InlineQuery.Query)
 	if err != nil {
-		return nil, err
+		// dates are usually invalid while they are being typed
+		if errors.Is(err, m.ErrInvalidSearchDate) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+
+	var offset int32
+	if update.InlineQuery.Offset != "" {
+		offset64, err := strconv.ParseInt(update.InlineQuery.Offset, 10, 32)
+		if err != nil || offset64 < 0 {
+			return nil, "", fmt.Errorf("invalid inline query offset '%s'", update.InlineQuery.Offset)
+		}
+		offset = int32(offset64)
+	}
+
+	quotes, err := h.db.SearchQuotes(user.LibraryID, &search, INLINE_QUERY_PAGE_LIMIT, offset)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nextOffset := ""
+	if len(quotes) == INLINE_QUERY_PAGE_LIMIT {
+		nextOffset = strconv.Itoa(int(offset) + len(quotes))
 	}
 
-	// TODO: add tags
 	results := make([]models.InlineQueryResult, 0, len(quotes))
-	for _, q := range quotes {
+	for i := range quotes {
+		q := &quotes[i]
 		title := "No Source"
 		description := q.Text
 		if len(q.Text) > 40 {
@@ -828,11 +2425,11 @@ func (h Handlers) reactInlineQuery(update *models.Update) ([]models.InlineQueryR
 			Title:       title,
 			Description: description,
 			InputMessageContent: models.InputTextMessageContent{
-				MessageText: s.Quote(&u.Quote{Text: q.Text, MainSource: q.MainSource.String}),
+				MessageText: s.Quote(u.QuoteFromDB(q)),
 				ParseMode:   models.ParseModeMarkdown,
 			},
 		})
 	}
 
-	return results, nil
+	return results, nextOffset, nil
 }
@@ -0,0 +1,131 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/aigic8/warmlight/pkg/bot/strs"
+	u "github.com/aigic8/warmlight/pkg/bot/utils"
+	"github.com/go-co-op/gocron"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/rs/zerolog"
+)
+
+// DigestSender sends the daily digests of users. Digests are checked every
+// minute and each one is sent once a day after its time of day has passed.
+type DigestSender struct {
+	db        db.Store
+	b         *bot.Bot
+	ctx       context.Context
+	scheduler *gocron.Scheduler
+	l         zerolog.Logger
+	r         *rand.Rand
+}
+
+func NewDigestSender(db db.Store, b *bot.Bot, isDev bool, logPath string, ctx context.Context) (*DigestSender, error) {
+	l, err := u.NewDigestSenderLogger(isDev, logPath)
+	if err != nil {
+		return nil, err
+	}
+	return &DigestSender{db: db, b: b, ctx: ctx, l: l, r: rand.New(rand.NewSource(time.Now().UnixNano()))}, nil
+}
+
+func (ds *DigestSender) Schedule() {
+	ds.scheduler = gocron.NewScheduler(time.UTC)
+	ds.scheduler.Every(1).Minutes().SingletonMode().Do(ds.sendDueDigests)
+	ds.scheduler.StartAsync()
+}
+
+func (ds *DigestSender) sendDueDigests() {
+	digests, err := ds.db.GetActiveDigests()
+	if err != nil {
+		ds.l.Error().Err(err).Msg("getting active digests")
+		return
+	}
+
+	now := time.Now()
+	for _, digest := range digests {
+		if !u.IsDigestDue(&digest, now) {
+			continue
+		}
+
+		// digest is marked as sent first, so a failing digest is not retried every minute
+		if _, err = ds.db.SetDigestLastSentAt(digest.UserID, now); err != nil {
+			ds.l.Error().Err(err).Int64("userID", digest.UserID).Msg("setting digest last sent time")
+			continue
+		}
+
+		r, err := ds.digestReaction(&digest)
+		if err != nil {
+			ds.l.Error().Err(err).Int64("userID", digest.UserID).Msg("creating digest")
+			continue
+		}
+
+		if err = r.Do(ds.ctx, ds.b); err != nil {
+			ds.l.Error().Err(err).Int64("userID", digest.UserID).Msg("sending digest")
+		}
+	}
+}
+
+func (ds *DigestSender) digestReaction(digest *db.Digest) (u.Reaction, error) {
+	user, err := ds.db.GetUser(digest.UserID)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	quotes, err := ds.db.GetLibraryQuotesWithData(user.LibraryID)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	sources, err := ds.db.GetLibrarySources(user.LibraryID)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	filters, err := u.ParseOutputFilters(digest.Filters)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	picked := u.PickDigestQuotes(quotes, sources, &filters, int(digest.Count), ds.r)
+	if len(picked) == 0 {
+		return u.Reaction{}, nil
+	}
+
+	chatID := user.ChatID
+	if digest.OutputChatID.Valid {
+		output, err := ds.db.GetOutput(user.ID, digest.OutputChatID.Int64)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return u.Reaction{}, err
+		}
+		// digests of deactivated outputs are sent to the private chat
+		if err == nil && output.IsActive {
+			chatID = output.ChatID
+		}
+	}
+
+	messages := []bot.SendMessageParams{}
+	if chatID == user.ChatID {
+		messages = append(messages, u.TextMessage(chatID, strs.YourDailyDigest))
+	}
+	for _, quote := range picked {
+		messages = append(messages, bot.SendMessageParams{
+			ChatID:    chatID,
+			ParseMode: models.ParseModeMarkdown,
+			Text:      strs.Quote(u.QuoteFromDB(&quote)),
+		})
+	}
+
+	return u.Reaction{Messages: messages}, nil
+}
+
+func (ds *DigestSender) Stop() {
+	if ds.scheduler != nil {
+		ds.scheduler.Stop()
+	}
+}
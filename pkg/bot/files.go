@@ -0,0 +1,53 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+const TELEGRAM_FILE_URL = "https://api.telegram.org/file/bot"
+const FILE_DOWNLOAD_TIMEOUT = time.Minute
+
+type FileDownloader interface {
+	Download(fileID string) ([]byte, error)
+}
+
+// telegramFileDownloader downloads files users send to the bot. b is set after
+// the bot is created, since handlers are needed to create the bot.
+type telegramFileDownloader struct {
+	b     *bot.Bot
+	token string
+	ctx   context.Context
+}
+
+func (d *telegramFileDownloader) Download(fileID string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(d.ctx, FILE_DOWNLOAD_TIMEOUT)
+	defer cancel()
+
+	file, err := d.b.GetFile(ctx, &bot.GetFileParams{FileID: fileID})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, TELEGRAM_FILE_URL+d.token+"/"+file.FilePath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading file: unexpected status code %d", res.StatusCode)
+	}
+
+	return io.ReadAll(res.Body)
+}
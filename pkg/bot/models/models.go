@@ -3,6 +3,10 @@ package models
 import (
 	"errors"
 	"strings"
+	"time"
+	"unicode"
+
+	"github.com/aigic8/warmlight/internal/db"
 )
 
 const CALLBACK_MSG_OUTPUTS_LIST = "olm"
@@ -10,11 +14,40 @@ const CALLBACK_MSG_OUTPUTS_LIST = "olm"
 const CALLBACK_MSG_NEXT_SOURCE_PAGE = "nsp"
 const CALLBACK_MSG_PREV_SOURCE_PAGE = "psp"
 
+const CALLBACK_MSG_NEXT_TAG_PAGE = "ntp"
+const CALLBACK_MSG_PREV_TAG_PAGE = "ptp"
+
+const CALLBACK_MSG_SEARCH_PAGE = "srp"
+
 const CALLBACK_COMMAND_ACTIVATE_OUTPUT = "ac_op"
 const CALLBACK_COMMAND_DEACTIVATE_OUTPUT = "de_op"
+const CALLBACK_COMMAND_OUTPUT_FILTERS = "fl_op"
 
 const CALLBACK_COMMAND_SOURCE_INFO = "in_sr"
 const CALLBACK_COMMAND_SOURCE_EDIT = "ed_sr"
+const CALLBACK_COMMAND_SOURCE_MERGE = "mr_sr"
+const CALLBACK_COMMAND_SOURCE_DELETE = "dl_sr"
+
+const CALLBACK_COMMAND_QUOTE_EDIT = "ed_qt"
+const CALLBACK_COMMAND_QUOTE_DELETE = "dl_qt"
+const CALLBACK_COMMAND_QUOTE_CONFIRM_DELETE = "cd_qt"
+const CALLBACK_COMMAND_QUOTE_VIEW = "vw_qt"
+const CALLBACK_COMMAND_QUOTE_FORWARD = "fw_qt"
+const CALLBACK_COMMAND_QUOTE_SEND_TO_OUTPUT = "so_qt"
+const CALLBACK_COMMAND_QUOTE_COPY = "cp_qt"
+
+const CALLBACK_COMMAND_REVIEW_QUOTE = "rv_qt"
+
+const CALLBACK_COMMAND_DRAFT_SAVE = "sv_dr"
+const CALLBACK_COMMAND_DRAFT_DISCARD = "dc_dr"
+const CALLBACK_COMMAND_DRAFT_SOURCES = "sr_dr"
+const CALLBACK_COMMAND_DRAFT_TAGS = "tg_dr"
+const CALLBACK_COMMAND_DRAFT_MAIN_SOURCE = "ms_dr"
+
+const CALLBACK_COMMAND_TAG_RENAME = "rn_tg"
+const CALLBACK_COMMAND_TAG_MERGE = "mr_tg"
+const CALLBACK_COMMAND_TAG_DELETE = "dl_tg"
+const CALLBACK_COMMAND_TAG_CONFIRM_DELETE = "cd_tg"
 
 const CALLBACK_COMMAND_MERGE_LIBRARY = "mr_lb"
 const CALLBACK_COMMAND_DELETE_LIBRARY = "dl_lb"
@@ -22,6 +55,7 @@ const CALLBACK_COMMAND_DELETE_LIBRARY = "dl_lb"
 var ErrMalformedCallbackString = errors.New("malformed callback string")
 
 var ErrMultipleSourceKindFilters = errors.New("multiple source kinds")
+var ErrInvalidSearchDate = errors.New("invalid search date")
 
 type CallbackData struct {
 	ReplaceMessageWith string
@@ -51,39 +85,164 @@ type SourceFilter struct {
 	SourceKind string
 }
 
+var sourceKindFilters = map[string]bool{
+	"@article": true,
+	"@book":    true,
+	"@person":  true,
+	"@unknown": true,
+}
+
 func ParseSourceFilter(text string) (SourceFilter, error) {
 	var sf SourceFilter
-	sourceKindFilters := map[string]bool{
-		"@article": true,
-		"@book":    true,
-		"@person":  true,
-		"@unknown": true,
+	words := []string{}
+	for _, word := range strings.Fields(text) {
+		if _, isSourceKindFilter := sourceKindFilters[word]; !isSourceKindFilter {
+			words = append(words, word)
+			continue
+		}
+
+		if sf.SourceKind != "" {
+			return sf, ErrMultipleSourceKindFilters
+		}
+		sf.SourceKind = strings.TrimPrefix(word, "@")
 	}
+	sf.Text = strings.Join(words, " ")
+
+	return sf, nil
+}
+
+const SEARCH_OR = "OR"
+const SEARCH_SOURCE_PREFIX = "source:"
+const SEARCH_AFTER_PREFIX = "after:"
+const SEARCH_BEFORE_PREFIX = "before:"
+
+var searchDateLayouts = []string{"2006-01-02", "2006-01", "2006"}
+
+// ParseSearchQuery parses a search query of quotes. Words are joined with AND and 'OR'
+// joins the words around it. Other than words, the query can have:
+//   - "quoted phrases"
+//   - #tags
+//   - source:name or source:"name with spaces"
+//   - source kinds like @book
+//   - after:2023-01 and before:2023-06-15, which can be a year, month or day
+//
+// Every term other than dates can be negated with a '-' prefix, like -#fiction.
+func ParseSearchQuery(text string) (db.QuoteSearch, error) {
+	var search db.QuoteSearch
+	joinNext := false
+	for _, token := range searchQueryTokens(text) {
+		if token == SEARCH_OR {
+			joinNext = len(search.Clauses) != 0
+			continue
+		}
 
-	fields := strings.Fields(text)
-	sourceKindFilterIndex := -1
-	for i, word := range fields {
-		if _, isSourceKindFilter := sourceKindFilters[word]; isSourceKindFilter {
-			if sf.SourceKind != "" {
-				return sf, ErrMultipleSourceKindFilters
+		if strings.HasPrefix(token, SEARCH_AFTER_PREFIX) {
+			t, err := parseSearchDate(strings.TrimPrefix(token, SEARCH_AFTER_PREFIX))
+			if err != nil {
+				return search, err
+			}
+			search.After, joinNext = t, false
+			continue
+		}
+		if strings.HasPrefix(token, SEARCH_BEFORE_PREFIX) {
+			t, err := parseSearchDate(strings.TrimPrefix(token, SEARCH_BEFORE_PREFIX))
+			if err != nil {
+				return search, err
 			}
-			sf.SourceKind = strings.TrimPrefix(word, "@")
-			sourceKindFilterIndex = i
+			search.Before, joinNext = t, false
+			continue
 		}
+
+		term, ok := parseSearchTerm(token)
+		if !ok {
+			continue
+		}
+
+		if joinNext {
+			last := len(search.Clauses) - 1
+			search.Clauses[last] = append(search.Clauses[last], term)
+		} else {
+			search.Clauses = append(search.Clauses, []db.SearchTerm{term})
+		}
+		joinNext = false
+	}
+
+	return search, nil
+}
+
+func parseSearchTerm(token string) (db.SearchTerm, bool) {
+	var term db.SearchTerm
+	if len(token) > 1 && token[0] == '-' {
+		term.Negated = true
+		token = token[1:]
 	}
 
-	// TODO: use more efficient way using strings.Builder
-	if sourceKindFilterIndex != 0 {
-		sf.Text = fields[0]
+	switch {
+	case isSearchQuote(firstRune(token)):
+		term.Kind, term.Value = db.SearchTermPhrase, unquoteSearchValue(token)
+	case strings.HasPrefix(token, "#"):
+		term.Kind, term.Value = db.SearchTermTag, strings.TrimPrefix(token, "#")
+	case sourceKindFilters[token]:
+		term.Kind, term.Value = db.SearchTermSourceKind, strings.TrimPrefix(token, "@")
+	case strings.HasPrefix(token, SEARCH_SOURCE_PREFIX):
+		term.Kind, term.Value = db.SearchTermSource, unquoteSearchValue(strings.TrimPrefix(token, SEARCH_SOURCE_PREFIX))
+	default:
+		term.Kind, term.Value = db.SearchTermWord, token
+		// words without any letters or numbers, like '-', can not be searched
+		if strings.IndexFunc(token, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }) == -1 {
+			return term, false
+		}
 	}
 
-	if len(fields) > 1 {
-		for i, item := range fields[1:] {
-			if i+1 != sourceKindFilterIndex {
-				sf.Text += " " + item
+	return term, term.Value != ""
+}
+
+// searchQueryTokens splits the query by spaces which are not in quotes
+func searchQueryTokens(text string) []string {
+	tokens := []string{}
+	var token strings.Builder
+	inQuotes := false
+	for _, r := range text {
+		if isSearchQuote(r) {
+			inQuotes = !inQuotes
+		}
+		if unicode.IsSpace(r) && !inQuotes {
+			if token.Len() != 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
 			}
+			continue
 		}
+		token.WriteRune(r)
+	}
+	if token.Len() != 0 {
+		tokens = append(tokens, token.String())
 	}
 
-	return sf, nil
+	return tokens
+}
+
+// phone keyboards usually replace '"' with curly quotes
+func isSearchQuote(r rune) bool {
+	return r == '"' || r == '“' || r == '”'
+}
+
+func firstRune(text string) rune {
+	for _, r := range text {
+		return r
+	}
+	return 0
+}
+
+func unquoteSearchValue(value string) string {
+	return strings.TrimSpace(strings.TrimFunc(value, isSearchQuote))
+}
+
+func parseSearchDate(value string) (time.Time, error) {
+	for _, layout := range searchDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrInvalidSearchDate
 }
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+type parseSearchQueryTestCase struct {
+	Name   string
+	Query  string
+	Search db.QuoteSearch
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	word := func(value string) db.SearchTerm { return db.SearchTerm{Kind: db.SearchTermWord, Value: value} }
+
+	testCases := []parseSearchQueryTestCase{
+		{Name: "empty", Query: "  ", Search: db.QuoteSearch{}},
+		{Name: "words", Query: "premature optimization", Search: db.QuoteSearch{Clauses: [][]db.SearchTerm{{word("premature")}, {word("optimization")}}}},
+		{Name: "punctuation", Query: "evil! - ...", Search: db.QuoteSearch{Clauses: [][]db.SearchTerm{{word("evil!")}}}},
+		{Name: "or", Query: "OR crazy OR evil root OR", Search: db.QuoteSearch{Clauses: [][]db.SearchTerm{{word("crazy"), word("evil")}, {word("root")}}}},
+		{
+			Name:   "phrase",
+			Query:  `"root of all  evil" -“curly quotes”`,
+			Search: db.QuoteSearch{Clauses: [][]db.SearchTerm{{{Kind: db.SearchTermPhrase, Value: "root of all  evil"}}, {{Kind: db.SearchTermPhrase, Value: "curly quotes", Negated: true}}}},
+		},
+		{
+			Name:  "filters",
+			Query: `#stoicism -#fiction source:"Marcus Aurelius" -source:Seneca @book`,
+			Search: db.QuoteSearch{Clauses: [][]db.SearchTerm{
+				{{Kind: db.SearchTermTag, Value: "stoicism"}},
+				{{Kind: db.SearchTermTag, Value: "fiction", Negated: true}},
+				{{Kind: db.SearchTermSource, Value: "Marcus Aurelius"}},
+				{{Kind: db.SearchTermSource, Value: "Seneca", Negated: true}},
+				{{Kind: db.SearchTermSourceKind, Value: "book"}},
+			}},
+		},
+		{Name: "unknownKind", Query: "@bot", Search: db.QuoteSearch{Clauses: [][]db.SearchTerm{{word("@bot")}}}},
+		{
+			Name:   "dates",
+			Query:  "after:2023-01 before:2023-06-15",
+			Search: db.QuoteSearch{After: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Before: time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			search, err := ParseSearchQuery(tc.Query)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.Search, search)
+		})
+	}
+
+	_, err := ParseSearchQuery("after:2023-13")
+	assert.ErrorIs(t, err, ErrInvalidSearchDate)
+}
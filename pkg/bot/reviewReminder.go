@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/aigic8/warmlight/pkg/bot/strs"
+	u "github.com/aigic8/warmlight/pkg/bot/utils"
+	"github.com/go-co-op/gocron"
+	"github.com/go-telegram/bot"
+	"github.com/rs/zerolog"
+)
+
+// REVIEW_REMINDER_INTERVAL is the minimum time between two reminders of a user
+const REVIEW_REMINDER_INTERVAL = 24 * time.Hour
+
+// ReviewReminder reminds users who have quotes due for review. Users are
+// checked every hour and reminded at most once a day.
+type ReviewReminder struct {
+	db        db.Store
+	b         *bot.Bot
+	ctx       context.Context
+	scheduler *gocron.Scheduler
+	l         zerolog.Logger
+}
+
+func NewReviewReminder(db db.Store, b *bot.Bot, isDev bool, logPath string, ctx context.Context) (*ReviewReminder, error) {
+	l, err := u.NewReviewReminderLogger(isDev, logPath)
+	if err != nil {
+		return nil, err
+	}
+	return &ReviewReminder{db: db, b: b, ctx: ctx, l: l}, nil
+}
+
+func (rr *ReviewReminder) Schedule() {
+	rr.scheduler = gocron.NewScheduler(time.UTC)
+	rr.scheduler.Every(1).Hours().SingletonMode().Do(rr.remindDueReviews)
+	rr.scheduler.StartAsync()
+}
+
+func (rr *ReviewReminder) remindDueReviews() {
+	// cards of users who have started reviewing are synced here too, so they are reminded of quotes added later
+	if err := rr.db.SyncAllReviewCards(); err != nil {
+		rr.l.Error().Err(err).Msg("syncing review cards")
+	}
+
+	now := time.Now()
+	users, err := rr.db.GetUsersToRemindReview(now.Add(-REVIEW_REMINDER_INTERVAL))
+	if err != nil {
+		rr.l.Error().Err(err).Msg("getting users to remind review")
+		return
+	}
+
+	for _, user := range users {
+		// user is marked as reminded first, so a failing reminder is not retried every hour
+		if err = rr.db.SetReviewReminded(user.ID, now); err != nil {
+			rr.l.Error().Err(err).Int64("userID", user.ID).Msg("setting review reminded time")
+			continue
+		}
+
+		r, err := rr.reminderReaction(&user)
+		if err != nil {
+			rr.l.Error().Err(err).Int64("userID", user.ID).Msg("creating review reminder")
+			continue
+		}
+
+		if err = r.Do(rr.ctx, rr.b); err != nil {
+			rr.l.Error().Err(err).Int64("userID", user.ID).Msg("sending review reminder")
+		}
+	}
+}
+
+// reminderReaction reminds the user with the next due card, so it can be reviewed right away
+func (rr *ReviewReminder) reminderReaction(user *db.User) (u.Reaction, error) {
+	msg, err := nextReviewMessage(rr.db, user)
+	if err != nil {
+		return u.Reaction{}, err
+	}
+
+	return u.Reaction{Messages: []bot.SendMessageParams{u.TextMessage(user.ChatID, strs.ReviewReminder), msg}}, nil
+}
+
+func (rr *ReviewReminder) Stop() {
+	if rr.scheduler != nil {
+		rr.scheduler.Stop()
+	}
+}
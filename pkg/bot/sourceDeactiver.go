@@ -13,14 +13,14 @@ import (
 )
 
 type SourceDeactiver struct {
-	db        *db.DB
+	db        db.Store
 	b         *bot.Bot
 	ctx       context.Context
 	scheduler *gocron.Scheduler
 	l         zerolog.Logger
 }
 
-func NewSourceDeactiver(db *db.DB, b *bot.Bot, isDev bool, logPath string, ctx context.Context) (*SourceDeactiver, error) {
+func NewSourceDeactiver(db db.Store, b *bot.Bot, isDev bool, logPath string, ctx context.Context) (*SourceDeactiver, error) {
 	l, err := u.NewSourceDeactiverLogger(isDev, logPath)
 	if err != nil {
 		return nil, err
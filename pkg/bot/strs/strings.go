@@ -19,6 +19,19 @@ const COMMAND_DEACTIVATE_SOURCE = "/deactivatesource"
 const COMMAND_GET_SOURCES = "/getsources"
 const COMMAND_GET_LIBRARY_TOKEN = "/getlibtoken"
 const COMMAND_SET_LIBRARY_TOKEN = "/setlibtoken"
+const COMMAND_EDIT_QUOTE = "/editquote"
+const COMMAND_DELETE_QUOTE = "/deletequote"
+const COMMAND_EXPORT = "/export"
+const COMMAND_IMPORT = "/import"
+const COMMAND_DIGEST = "/digest"
+const COMMAND_REVIEW = "/review"
+const COMMAND_TAGS = "/tags"
+const COMMAND_SEARCH = "/search"
+const COMMAND_LANGUAGE = "/language"
+const COMMAND_SYNTAX = "/syntax"
+const COMMAND_PREVIEW = "/preview"
+const COMMAND_CONFIRM_QUOTES = "/confirmquotes"
+const COMMAND_UNDO = "/undo"
 
 // COMMON STRINGS ////////////////////////////////////////////////
 const InternalServerErr = "❌ Something unexpected happened.Text me (@aigic8) if the issue persists."
@@ -32,8 +45,10 @@ This bot is created to help you saving your quotes. You can add a quote simply b
 We should forget about small efficiencies, say about 97%% of the time: premature optimization is the root of all evil.
 sources: Donald Ervin Knuth
 #programming #optimization 
+For quotes with more than one paragraph, put the text between two lines of """. You can also add the page of the quote in its main source with a line like "page: 142", and your own notes about it with lines starting with "note:", notes are searched like the text of quotes.
+You can also forward messages or send photos with captions, forwarded quotes without sources get the channel or the person they are forwarded from as their source, with a link to the original post of channels. Formatting of the text like bold, italic, spoilers and links is kept.
 There are several important commands in this bot:
-%s you can search your sources, it will return results and you can view their info and also edit them. For example:
+%s you can search your sources, it will return results and you can view their info, edit them, merge them into another source or delete them. For example:
 %s Animal Farm
 will search for a source with name of "Animal Farm". Also, you can use source type specifier to search more specifically for source. For example:
 %s Animal Farm @book
@@ -43,9 +58,28 @@ Will only search for books with name "Animal Farm".
 Will set source "Animal Farm" as active source for "20 minutes". The time period is optional, for example:
 %s Animal Farm
 This command will set source "Animal Farm" as active source for default timeout (60 minutes)
-%s will show you your outputs. Outputs are Telegram channels when you send a new quote, your quotes will be forwarded to there. You can activate and deactivate your outputs with this command.
+%s will show you your outputs. Outputs are Telegram channels when you send a new quote, your quotes will be forwarded to there. You can activate and deactivate your outputs with this command. Using the "Filters" button of an output, you can choose which tags, sources and source kinds are published to it.
 %s and %s are used to share a quote library between multiple accounts. The owner of the library will use command %s to get his library token. The second account will use command %s to set library token received by the owner.
-`, COMMAND_GET_SOURCES, COMMAND_GET_SOURCES, COMMAND_GET_SOURCES, COMMAND_SET_ACTIVE_SOURCE, COMMAND_SET_ACTIVE_SOURCE, COMMAND_SET_ACTIVE_SOURCE, COMMAND_GET_OUTPUTS, COMMAND_GET_LIBRARY_TOKEN, COMMAND_SET_LIBRARY_TOKEN, COMMAND_GET_LIBRARY_TOKEN, COMMAND_SET_LIBRARY_TOKEN)
+%s and %s are used to edit or delete a quote. Send them as a reply to the "Quote added" message of the quote. You can also use the buttons below the "Quote added" message.
+%s will send you a file containing all of your quotes with their sources and tags. You can choose the format of the file (json, csv or md), formatting of quotes is kept in json and md files. For example:
+%s csv
+The default format is json.
+%s will add quotes of a json or csv file created with %s to your library. You can also import your Kindle highlights by sending the "My Clippings.txt" file of your Kindle, the book of each highlight is added as its source. Quotes which already exist in your library are not added again.
+%s will show the settings of your daily digest. The digest sends random quotes from your library to you or to one of your outputs every day at the time you choose. You can choose the number of quotes and filter them by tags, sources and source kinds.
+%s will show you quotes of your library which are due for review. After reading a quote, tell the bot how well you remembered it (Forgot, Hard, Good or Easy), and it will show the quote again after a few days. Quotes you remember well are shown less often. Once you start reviewing, you'll get a daily reminder when you have quotes to review.
+%s will list your tags with the number of quotes of each one. You can rename a tag, merge it into another tag or delete it using the buttons below the list.
+%s will search your quotes. Words of the search are all matched, even with small typos, and OR matches either of the words around it. You can also search for "exact phrases", #tags, sources with source:"Animal Farm", source kinds like @book and filter quotes by the date they are added with after:2023-01 and before:2023-06-15. Put '-' before a term to exclude it, for example:
+%s "all animals" #politics -@article after:2023
+Results are shown in pages, the most relevant ones first, and you can view, edit, delete, forward to an output or copy each quote using the buttons below them. You can use the same search in inline mode of the bot, like "@yourbot #stoicism", to share quotes with their sources and tags. Inline mode shows your newest quotes before you type anything.
+%s will show the language your quotes are searched in, which is English by default. Searches find other forms of the words you search in that language, for example "animals" finds "animal". To change it, send the name of the language after the command, for example:
+%s german
+%s will show the keywords you write quotes with, which are "sources:", "#", "page:" and "note:" by default. You can change them to words of your own language, for example:
+%s sources منابع:
+%s reset will change them back to the default ones.
+%s will show how a quote is read before you save it. Send the quote after the command in the same message, nothing is saved.
+%s on will show each quote you send with buttons to save it, edit its sources or tags, change its main source or discard it, instead of saving it right away. Sending another quote replaces the one waiting to be saved. %s off will save quotes right away again.
+%s will undo your last change if it's made in the last 30 minutes, which can be adding a quote, editing a source or merging tags. Send it again to undo the change before it.
+`, COMMAND_GET_SOURCES, COMMAND_GET_SOURCES, COMMAND_GET_SOURCES, COMMAND_SET_ACTIVE_SOURCE, COMMAND_SET_ACTIVE_SOURCE, COMMAND_SET_ACTIVE_SOURCE, COMMAND_GET_OUTPUTS, COMMAND_GET_LIBRARY_TOKEN, COMMAND_SET_LIBRARY_TOKEN, COMMAND_GET_LIBRARY_TOKEN, COMMAND_SET_LIBRARY_TOKEN, COMMAND_EDIT_QUOTE, COMMAND_DELETE_QUOTE, COMMAND_EXPORT, COMMAND_EXPORT, COMMAND_IMPORT, COMMAND_EXPORT, COMMAND_DIGEST, COMMAND_REVIEW, COMMAND_TAGS, COMMAND_SEARCH, COMMAND_SEARCH, COMMAND_LANGUAGE, COMMAND_LANGUAGE, COMMAND_SYNTAX, COMMAND_SYNTAX, COMMAND_SYNTAX, COMMAND_PREVIEW, COMMAND_CONFIRM_QUOTES, COMMAND_CONFIRM_QUOTES, COMMAND_UNDO)
 
 func WelcomeToBot(firstName string) string {
 	return fmt.Sprintf(`👋 Welcome %s.
@@ -67,6 +101,10 @@ const OnlyOneSourceKindFilterIsAllowed = "You can only filter sources based on o
 const SourceNoLongerExists = "❌ Source no longer exists."
 const GoingBackToNormalMode = "❌ There was an error in operation. Operation is canceled and you went back to normal state."
 
+func SourceFilterIsTooLong(maxLen int) string {
+	return fmt.Sprintf("Source filter is too long, it should be at most %d characters. 🧐", maxLen)
+}
+
 func MalformedSetActiveSource(defaultTimeMins int) string {
 	return fmt.Sprintf(`Couldn't understand what you mean. 🤔
 To use %s properly you should follow this format:
@@ -95,7 +133,7 @@ book: %s, %s, %s
 person: %s, %s, %s
 article: %s, %s
 unknown: [HAVE NO OPTIONS]
-You can also send '%s' to cancel the operation.`, SOURCE_KIND, SOURCE_BOOK_INFO_URL, SOURCE_BOOK_AUTHOR, SOURCE_BOOK_AUTHOR_URL, SOURCE_KIND, SOURCE_BOOK_INFO_URL, SOURCE_BOOK_AUTHOR, SOURCE_BOOK_AUTHOR_URL, SOURCE_PERSON_INFO_URL, SOURCE_PERSON_LIVED_IN, SOURCE_PERSON_TITLE, SOURCE_ARTICLE_URL, SOURCE_ARTICLE_AUTHOR, ConfirmLibraryChangeCancelAnswer)
+You can also send '%s' to cancel the operation.`, SOURCE_KIND, SOURCE_BOOK_INFO_URL, SOURCE_BOOK_AUTHOR, SOURCE_BOOK_AUTHOR_URL, SOURCE_KIND, SOURCE_BOOK_INFO_URL, SOURCE_BOOK_AUTHOR, SOURCE_BOOK_AUTHOR_URL, SOURCE_PERSON_INFO_URL, SOURCE_PERSON_LIVED_IN, SOURCE_PERSON_TITLE, SOURCE_ARTICLE_URL, SOURCE_ARTICLE_AUTHOR, CancelAnswer)
 
 var MalformedPersonDates = fmt.Sprintf(`Malformed value for '%s'. The correct format is:
 %s: 1960-2000`, SOURCE_PERSON_LIVED_IN, SOURCE_PERSON_LIVED_IN)
@@ -126,6 +164,30 @@ func SourceDoesNotExist(sourceName string) string {
 	return fmt.Sprintf("Source '%s' does not exist. 🤔", sourceName)
 }
 
+const ConfirmSourceChangeYesAnswer = "Yes, I'm sure."
+const UnknownSourceConfirmationMessage = "Couldn't understand what you mean.\nValid answers are either '" + ConfirmSourceChangeYesAnswer + "' or '" + CancelAnswer + "'."
+const CanNotMergeSourceIntoItself = "A source can not be merged into itself. 🧐"
+
+func EnterSourceToMergeInto(sourceName string) string {
+	return fmt.Sprintf("Send the name of the source you want to merge '%s' into.\nYou can also send '%s' to cancel the operation.", sourceName, CancelAnswer)
+}
+
+func ConfirmDeleteSource(sourceName string) string {
+	return fmt.Sprintf("Are you sure you want to delete source '%s'?\nThe source will be removed from all of your quotes, but the quotes won't be deleted. This action is IRREVERSIBLE. If yes send '%s'. Send '%s' to cancel.", sourceName, ConfirmSourceChangeYesAnswer, CancelAnswer)
+}
+
+func ConfirmMergeSource(fromName, toName string) string {
+	return fmt.Sprintf("Are you sure you want to merge source '%s' into '%s'?\nQuotes of '%s' will get '%s' as their source and '%s' will be deleted. This action is IRREVERSIBLE. If yes send '%s'. Send '%s' to cancel.", fromName, toName, fromName, toName, fromName, ConfirmSourceChangeYesAnswer, CancelAnswer)
+}
+
+func SourceDeleted(sourceName string) string {
+	return fmt.Sprintf("✅ Source '%s' is deleted.", sourceName)
+}
+
+func SourcesMerged(fromName, toName string) string {
+	return fmt.Sprintf("✅ Source '%s' is merged into '%s'.", fromName, toName)
+}
+
 func InvalidSourceKind(sourceKind string) string {
 	return fmt.Sprintf("Source kind '%s' is not a valid source kind.🤔\nValid source kinds are %s.", sourceKind, strings.Join(db.VALID_SOURCE_KINDS, ", "))
 }
@@ -191,7 +253,7 @@ book: %s, %s, %s
 person: %s, %s, %s
 article: %s, %s
 unknown: [HAVE NO OPTIONS]
-You can also send '%s' to cancel the operation.`, sourceInfo, SOURCE_KIND, SOURCE_BOOK_INFO_URL, SOURCE_BOOK_AUTHOR, SOURCE_BOOK_AUTHOR_URL, SOURCE_KIND, SOURCE_BOOK_INFO_URL, SOURCE_BOOK_AUTHOR, SOURCE_BOOK_AUTHOR_URL, SOURCE_PERSON_INFO_URL, SOURCE_PERSON_LIVED_IN, SOURCE_PERSON_TITLE, SOURCE_ARTICLE_URL, SOURCE_ARTICLE_AUTHOR, ConfirmLibraryChangeCancelAnswer), nil
+You can also send '%s' to cancel the operation.`, sourceInfo, SOURCE_KIND, SOURCE_BOOK_INFO_URL, SOURCE_BOOK_AUTHOR, SOURCE_BOOK_AUTHOR_URL, SOURCE_KIND, SOURCE_BOOK_INFO_URL, SOURCE_BOOK_AUTHOR, SOURCE_BOOK_AUTHOR_URL, SOURCE_PERSON_INFO_URL, SOURCE_PERSON_LIVED_IN, SOURCE_PERSON_TITLE, SOURCE_ARTICLE_URL, SOURCE_ARTICLE_AUTHOR, CancelAnswer), nil
 
 }
 
@@ -209,8 +271,121 @@ func ListOfSources(sources []db.Source) string {
 	return text
 }
 
+// TAGS //////////////////////////////////////////////////////////
+const TagNoLongerExists = "❌ Tag no longer exists."
+const InvalidTagName = "Tag name should be a single word, like #programming. 🧐"
+const CanNotMergeTagIntoItself = "A tag can not be merged into itself. 🧐"
+
+// IMPORTANT needs support for Markdown parseMode
+func ListOfTags(tags []db.TagWithCount) string {
+	if len(tags) == 0 {
+		return bot.EscapeMarkdown("You have no tags yet. 😕")
+	}
+
+	text := "🏷 Your tags:\n"
+	for i, tag := range tags {
+		text += strconv.Itoa(i+1) + bot.EscapeMarkdown(". ") + "*" + bot.EscapeMarkdown("#"+tag.Name) + "*" + bot.EscapeMarkdown(" - "+quotesCount(tag.QuoteCount)) + "\n"
+	}
+
+	return text
+}
+
+func quotesCount(count int64) string {
+	if count == 1 {
+		return "1 quote"
+	}
+	return fmt.Sprintf("%d quotes", count)
+}
+
+func EnterNewTagName(name string) string {
+	return fmt.Sprintf("Send the new name of #%s.\nYou can also send '%s' to cancel the operation.", name, CancelAnswer)
+}
+
+func EnterTagToMergeInto(name string) string {
+	return fmt.Sprintf("Send the name of the tag you want to merge #%s into. Quotes of #%s will get the new tag and #%s will be deleted.\nYou can also send '%s' to cancel the operation.", name, name, name, CancelAnswer)
+}
+
+func TagAlreadyExists(name string) string {
+	return fmt.Sprintf("Tag #%s already exists. 🤔\nUse the 'Merge' button of '%s' command to merge the tags.", name, COMMAND_TAGS)
+}
+
+func TagNotFound(name string) string {
+	return fmt.Sprintf("Couldn't find tag #%s. 🤔\nYou can see your tags with '%s' command.", name, COMMAND_TAGS)
+}
+
+func TagRenamed(oldName, newName string) string {
+	return fmt.Sprintf("✅ #%s is renamed to #%s.", oldName, newName)
+}
+
+func TagsMerged(fromName, toName string) string {
+	return fmt.Sprintf("✅ #%s is merged into #%s.", fromName, toName)
+}
+
+func NothingToUndo(window string) string {
+	return fmt.Sprintf("There is nothing to undo. 🧐\nOnly quotes you added, sources you edited and tags you merged in the last %s can be undone.", window)
+}
+
+const QuoteAddingUndone = "↩️ The quote you added last is deleted."
+const ActivityCanNotBeUndone = "❌ Your last change can not be undone, what it changed no longer exists."
+
+func SourceEditUndone(name string) string {
+	return fmt.Sprintf("↩️ Your last edit of '%s' is undone.", name)
+}
+
+func TagsMergeUndone(fromName string) string {
+	return fmt.Sprintf("↩️ #%s is no longer merged, quotes which had it have it again.", fromName)
+}
+
+func ConfirmDeleteTag(name string) string {
+	return fmt.Sprintf("Are you sure you want to delete #%s? The tag will be removed from all of your quotes, but the quotes won't be deleted.", name)
+}
+
+func TagDeleted(name string) string {
+	return fmt.Sprintf("✅ #%s is deleted.", name)
+}
+
 // OUTPUTS ///////////////////////////////////////////////////////
 // IMPORTANT needs support for Markdown parseMode
+const OUTPUT_FILTER_INCLUDE_TAGS = "include tags"
+const OUTPUT_FILTER_EXCLUDE_TAGS = "exclude tags"
+const OUTPUT_FILTER_INCLUDE_SOURCES = "include sources"
+const OUTPUT_FILTER_EXCLUDE_SOURCES = "exclude sources"
+const OUTPUT_FILTER_INCLUDE_KINDS = "include kinds"
+const OUTPUT_FILTER_EXCLUDE_KINDS = "exclude kinds"
+
+const OutputNoLongerExists = "❌ Output no longer exists."
+
+var outputFiltersFormat = fmt.Sprintf(`[option1]: [value1], [value2]
+[option2]: [value1]
+...
+For example:
+%s: work, productivity
+%s: book, article
+Available options are: %s, %s, %s, %s, %s, %s
+A quote is published only if it has at least one of the included values and none of the excluded ones. Send an option with an empty value to remove it.
+You can also send '%s' to cancel the operation.`, OUTPUT_FILTER_INCLUDE_TAGS, OUTPUT_FILTER_EXCLUDE_KINDS, OUTPUT_FILTER_INCLUDE_TAGS, OUTPUT_FILTER_EXCLUDE_TAGS, OUTPUT_FILTER_INCLUDE_SOURCES, OUTPUT_FILTER_EXCLUDE_SOURCES, OUTPUT_FILTER_INCLUDE_KINDS, OUTPUT_FILTER_EXCLUDE_KINDS, CancelAnswer)
+
+var MalformedEditOutputFiltersText = "Couldn't understand what you mean. 🤔\nTo edit the filters properly, you should use this format:\n" + outputFiltersFormat
+
+func OutputFilters(filters *db.OutputFilters) string {
+	return fmt.Sprintf("%s: %s\n%s: %s\n%s: %s\n%s: %s\n%s: %s\n%s: %s",
+		OUTPUT_FILTER_INCLUDE_TAGS, strings.Join(filters.IncludeTags, ", "),
+		OUTPUT_FILTER_EXCLUDE_TAGS, strings.Join(filters.ExcludeTags, ", "),
+		OUTPUT_FILTER_INCLUDE_SOURCES, strings.Join(filters.IncludeSources, ", "),
+		OUTPUT_FILTER_EXCLUDE_SOURCES, strings.Join(filters.ExcludeSources, ", "),
+		OUTPUT_FILTER_INCLUDE_KINDS, strings.Join(filters.IncludeSourceKinds, ", "),
+		OUTPUT_FILTER_EXCLUDE_KINDS, strings.Join(filters.ExcludeSourceKinds, ", "),
+	)
+}
+
+func EditOutputFilters(outputTitle string, filters *db.OutputFilters) string {
+	return fmt.Sprintf("Current filters of %s:\n%s\nSend a message in this format to edit the filters:\n%s", outputTitle, OutputFilters(filters), outputFiltersFormat)
+}
+
+func UpdatedOutputFilters(outputTitle string, filters *db.OutputFilters) string {
+	return fmt.Sprintf("✅ Updated successfully. New filters of %s:\n%s", outputTitle, OutputFilters(filters))
+}
+
 func ListOfYourOutputs(outputs []db.Output) string {
 	if len(outputs) == 0 {
 		return bot.EscapeMarkdown(`You have no outputs.
@@ -229,13 +404,227 @@ If you have already done that, please remove the bot and make it as admin again.
 	return text
 }
 
+// DIGESTS ///////////////////////////////////////////////////////
+const DIGEST_OPTION_ENABLED = "enabled"
+const DIGEST_OPTION_TIME = "time"
+const DIGEST_OPTION_TIMEZONE = "timezone"
+const DIGEST_OPTION_COUNT = "count"
+const DIGEST_OPTION_OUTPUT = "output"
+const DIGEST_ENABLED_YES = "yes"
+const DIGEST_ENABLED_NO = "no"
+
+const YourDailyDigest = "☀️ Your daily quotes"
+const InvalidDigestTime = "Time should be in 24-hour format, like 08:30. 🧐"
+
+var InvalidDigestEnabled = fmt.Sprintf("Valid values for '%s' are '%s' and '%s'. 🧐", DIGEST_OPTION_ENABLED, DIGEST_ENABLED_YES, DIGEST_ENABLED_NO)
+var InvalidDigestCount = fmt.Sprintf("Count should be a number between 1 and %d. 🧐", utils.MAX_DIGEST_COUNT)
+
+func InvalidTimezone(timezone string) string {
+	return fmt.Sprintf("Timezone '%s' is not valid. 🤔\nUse names like UTC, Europe/Berlin or Asia/Tehran.", timezone)
+}
+
+func DigestOutputNotFound(title string) string {
+	return fmt.Sprintf("Couldn't find an output with title '%s'. 🤔\nYou can see your outputs with '%s' command.", title, COMMAND_GET_OUTPUTS)
+}
+
+var digestFormat = fmt.Sprintf(`[option1]: [value1]
+[option2]: [value2]
+...
+For example:
+%s: %s
+%s: 08:30
+%s: Europe/Berlin
+%s: 3
+Available options are: %s, %s, %s, %s, %s and the filter options (%s, %s, %s, %s, %s, %s)
+Send '%s' with the title of one of your outputs to send the digest there, or with an empty value to send it to this chat.
+You can also send '%s' to cancel the operation.`, DIGEST_OPTION_ENABLED, DIGEST_ENABLED_YES, DIGEST_OPTION_TIME, DIGEST_OPTION_TIMEZONE, DIGEST_OPTION_COUNT, DIGEST_OPTION_ENABLED, DIGEST_OPTION_TIME, DIGEST_OPTION_TIMEZONE, DIGEST_OPTION_COUNT, DIGEST_OPTION_OUTPUT, OUTPUT_FILTER_INCLUDE_TAGS, OUTPUT_FILTER_EXCLUDE_TAGS, OUTPUT_FILTER_INCLUDE_SOURCES, OUTPUT_FILTER_EXCLUDE_SOURCES, OUTPUT_FILTER_INCLUDE_KINDS, OUTPUT_FILTER_EXCLUDE_KINDS, DIGEST_OPTION_OUTPUT, CancelAnswer)
+
+var MalformedEditDigestText = "Couldn't understand what you mean. 🤔\nTo edit the digest properly, you should use this format:\n" + digestFormat
+
+// DigestSettings shows settings of a digest, outputTitle is empty when the digest is sent to the chat of user
+func DigestSettings(settings *db.DigestSettings, outputTitle string) string {
+	enabled := DIGEST_ENABLED_NO
+	if settings.IsActive {
+		enabled = DIGEST_ENABLED_YES
+	}
+
+	return fmt.Sprintf("%s: %s\n%s: %s\n%s: %s\n%s: %d\n%s: %s\n%s",
+		DIGEST_OPTION_ENABLED, enabled,
+		DIGEST_OPTION_TIME, settings.SendAt,
+		DIGEST_OPTION_TIMEZONE, settings.Timezone,
+		DIGEST_OPTION_COUNT, settings.Count,
+		DIGEST_OPTION_OUTPUT, outputTitle,
+		OutputFilters(&settings.Filters),
+	)
+}
+
+func EditDigest(settings *db.DigestSettings, outputTitle string) string {
+	return fmt.Sprintf("Current settings of your daily digest:\n%s\nSend a message in this format to edit the digest:\n%s", DigestSettings(settings, outputTitle), digestFormat)
+}
+
+func DigestUpdated(settings *db.DigestSettings, outputTitle string) string {
+	return fmt.Sprintf("✅ Updated successfully. New settings of your daily digest:\n%s", DigestSettings(settings, outputTitle))
+}
+
+// REVIEWS ///////////////////////////////////////////////////////
+const NothingToReview = "🎉 You've reviewed all of your due quotes. Come back later for more."
+
+const ReviewReminder = "🧠 Some of your quotes are waiting to be reviewed."
+
+// ReviewQuote is sent in markdown, dueCount includes the quote itself
+func ReviewQuote(q *utils.Quote, dueCount int64) string {
+	return bot.EscapeMarkdown(fmt.Sprintf("🧠 Review (%d due)", dueCount)) + "\n\n" + Quote(q)
+}
+
+func QuoteReviewed(intervalDays int32) string {
+	if intervalDays == 1 {
+		return "✅ Reviewed, you'll see this quote again tomorrow."
+	}
+	return fmt.Sprintf("✅ Reviewed, you'll see this quote again in %d days.", intervalDays)
+}
+
+// SEARCH ////////////////////////////////////////////////////////
+const SEARCH_RESULT_MAX_LEN = 300
+
+var SearchUsage = fmt.Sprintf(`Send your search after the command, for example:
+%s "all animals" #politics -@article after:2023
+Words are all matched and OR matches either of the words around it. You can also use "exact phrases", #tags, source:"Animal Farm", source kinds like @book, after:2023-01 and before:2023-06-15. Put '-' before a term to exclude it.`, COMMAND_SEARCH)
+
+const InvalidSearchDate = "Couldn't understand the date of the search. 🤔\nDates should be a year, a month or a day, like 2023, 2023-01 or 2023-01-15."
+
+const SearchIsNoLongerAvailable = "❌ Couldn't find the search of these results, send the search again."
+const ChooseOutputToForwardQuote = "Choose the output you want to forward the quote to:"
+
+var NoOutputsToForwardQuote = fmt.Sprintf("You have no outputs to forward the quote to. 😕\nYou can see how to add an output with '%s' command.", COMMAND_GET_OUTPUTS)
+
+func QuoteForwarded(outputTitle string) string {
+	return fmt.Sprintf("✅ Quote forwarded to %s.", outputTitle)
+}
+
+// IMPORTANT needs support for Markdown parseMode, offset is the number of results before quotes
+func ListOfSearchResults(quotes []db.QuoteWithData, offset int32) string {
+	if len(quotes) == 0 {
+		return bot.EscapeMarkdown("No quote was found. 😕")
+	}
+
+	text := bot.EscapeMarkdown("🔎 Found quotes:") + "\n"
+	for i, quote := range quotes {
+		quoteText := quote.Text
+		if runes := []rune(quoteText); len(runes) > SEARCH_RESULT_MAX_LEN {
+			quoteText = string(runes[:SEARCH_RESULT_MAX_LEN-3]) + "..."
+		}
+
+		text += "\n" + strconv.Itoa(int(offset)+i+1) + bot.EscapeMarkdown(". "+quoteText)
+		if quote.MainSource.Valid {
+			text += "\n" + "*" + bot.EscapeMarkdown(quote.MainSource.String) + "*"
+		}
+		text += "\n"
+	}
+
+	return text
+}
+
+func SearchLanguage(language string) string {
+	return fmt.Sprintf(`Your quotes are searched in %s.
+To change it, send the name of the language after the command, for example:
+%s german
+Supported languages are: %s.
+Quotes in other languages are searched with '%s', which only finds the exact words you search.`, language, COMMAND_LANGUAGE, strings.Join(db.SEARCH_LANGUAGES, ", "), db.FALLBACK_SEARCH_LANGUAGE)
+}
+
+func QuoteSyntax(syntax *db.QuoteSyntax) string {
+	return fmt.Sprintf(`Keywords you write quotes with:
+%s: %s
+%s: %s
+%s: %s
+%s: %s
+To change a keyword, send its name and the new keyword after the command, for example:
+%s sources منابع:
+%s %s
+will change all keywords back to the default ones. You can see how a quote is read with '%s' command.`,
+		utils.QUOTE_SYNTAX_SOURCES, syntax.SourcesPrefix,
+		utils.QUOTE_SYNTAX_TAG, syntax.TagMarker,
+		utils.QUOTE_SYNTAX_PAGE, syntax.PagePrefix,
+		utils.QUOTE_SYNTAX_NOTE, syntax.NotePrefix,
+		COMMAND_SYNTAX, COMMAND_SYNTAX, utils.QUOTE_SYNTAX_RESET, COMMAND_PREVIEW)
+}
+
+var UnknownQuoteSyntaxKeyword = fmt.Sprintf("Couldn't understand the keyword. 🤔\nKeywords are %s.", strings.Join(utils.QUOTE_SYNTAX_KEYWORDS, ", "))
+var InvalidQuoteSyntaxKeyword = `Couldn't use this keyword. 🧐
+Keywords should be on one line and keywords of sources, page and note should not start with each other. The tag keyword can't have spaces.`
+
+const QuoteSyntaxReset = "✅ Quotes are read with the default keywords from now on."
+
+func QuoteSyntaxSet(keyword string, value string) string {
+	return fmt.Sprintf("✅ '%s' is the %s keyword from now on.", value, keyword)
+}
+
+var SendQuoteToPreview = fmt.Sprintf(`Send the quote after the command, in the same message, for example:
+%s Waste no more time arguing what a good man should be. Be one.
+sources: Meditations`, COMMAND_PREVIEW)
+
+// IMPORTANT needs support for Markdown parseMode
+func QuotePreview(q *utils.Quote) string {
+	return bot.EscapeMarkdown("👀 The quote will be saved like this:") + "\n\n" + Quote(q)
+}
+
+// IMPORTANT needs support for Markdown parseMode
+func QuoteDraft(q *utils.Quote) string {
+	return bot.EscapeMarkdown("👀 Save this quote?") + "\n\n" + Quote(q)
+}
+
+// DraftEmptyAnswer removes all sources or tags of a draft
+const DraftEmptyAnswer = "-"
+
+var EnterDraftSources = fmt.Sprintf("Send the sources of the quote separated by commas, the first one will be the main source.\nYou can also send '%s' to remove all sources, or '%s' to go back to the quote.", DraftEmptyAnswer, CancelAnswer)
+var EnterDraftTags = fmt.Sprintf("Send the tags of the quote, like #stoicism #philosophy.\nYou can also send '%s' to remove all tags, or '%s' to go back to the quote.", DraftEmptyAnswer, CancelAnswer)
+var EnterDraftMainSource = fmt.Sprintf("Send the main source of the quote.\nYou can also send '%s' to go back to the quote.", CancelAnswer)
+
+const QuoteDraftDiscarded = "🗑 Quote discarded."
+const QuoteDraftNoLongerExists = "❌ This quote is already saved or discarded."
+
+func ConfirmQuotes(confirmQuotes bool) string {
+	state := "saved as soon as you send them"
+	if confirmQuotes {
+		state = "shown to you to be saved or discarded first"
+	}
+	return fmt.Sprintf(`New quotes are %s.
+To change it, send 'on' or 'off' after the command, for example:
+%s on`, state, COMMAND_CONFIRM_QUOTES)
+}
+
+const ConfirmQuotesEnabled = "✅ New quotes are shown to you to be saved or discarded first from now on."
+const ConfirmQuotesDisabled = "✅ New quotes are saved as soon as you send them from now on."
+
+func SearchLanguageSet(language string) string {
+	return fmt.Sprintf("✅ Your quotes are searched in %s from now on.", language)
+}
+
+func SearchLanguageNotSupported(language string) string {
+	return fmt.Sprintf("%s is not supported, so your quotes are searched with '%s' from now on, which only finds the exact words you search. 😕", language, db.FALLBACK_SEARCH_LANGUAGE)
+}
+
 // QUOTES ////////////////////////////////////////////////////////
 // IMPORTANT needs support Markdown parseMode
 func Quote(q *utils.Quote) string {
-	message := bot.EscapeMarkdown(q.Text)
+	message := utils.EntitiesToMarkdown(q.Text, q.Entities)
 
-	if q.MainSource != "" {
+	if q.MainSource != "" && q.Location != "" {
+		message += "\n" + "*" + bot.EscapeMarkdown(q.MainSource) + "*" + bot.EscapeMarkdown(", p. "+q.Location)
+	} else if q.MainSource != "" {
 		message += "\n" + "*" + bot.EscapeMarkdown(q.MainSource) + "*"
+	} else if q.Location != "" {
+		message += "\n" + bot.EscapeMarkdown("p. "+q.Location)
+	}
+
+	otherSources := []string{}
+	for _, source := range q.Sources {
+		if source != q.MainSource {
+			otherSources = append(otherSources, bot.EscapeMarkdown(source))
+		}
+	}
+	if len(otherSources) != 0 {
+		message += "\n" + strings.Join(otherSources, ", ")
 	}
 
 	if q.Tags != nil && len(q.Tags) != 0 {
@@ -250,9 +639,101 @@ func Quote(q *utils.Quote) string {
 		message += "\n" + bot.EscapeMarkdown(tagsStr)
 	}
 
+	if q.Note != "" {
+		message += "\n\n" + "📝 _" + bot.EscapeMarkdown(q.Note) + "_"
+	}
+
+	if q.OriginURL != "" {
+		message += "\n" + "[" + bot.EscapeMarkdown(OriginalMessage) + "](" + q.OriginURL + ")"
+	}
+
 	return message
 }
 
+const OriginalMessage = "Original message"
+
+const QuoteUpdated = "✅ Quote updated"
+const QuoteDeleted = "✅ Quote deleted"
+const QuoteNoLongerExists = "❌ Quote no longer exists."
+const QuoteHasNoText = "Quote has no text. 🧐"
+
+var QuoteTextBlockNotClosed = fmt.Sprintf("Text of the quote is not closed. 🧐\nWrite %s on its own line after the text too.", utils.QUOTE_TEXT_BLOCK_DELIMITER)
+
+const ConfirmDeleteQuote = "Are you sure you want to delete this quote?\nThis action is IRREVERSIBLE."
+
+var ReplyToQuoteToEdit = fmt.Sprintf(`Couldn't find the quote you mean. 🤔
+To use '%s' command, send it as a reply to the "Quote added" message of the quote.`, COMMAND_EDIT_QUOTE)
+
+var ReplyToQuoteToDelete = fmt.Sprintf(`Couldn't find the quote you mean. 🤔
+To use '%s' command, send it as a reply to the "Quote added" message of the quote.`, COMMAND_DELETE_QUOTE)
+
+// IMPORTANT needs support for Markdown parseMode. CopyQuote returns the raw quote as a
+// code block, which Telegram copies by tapping on it.
+func CopyQuote(q *utils.Quote, syntax *db.QuoteSyntax) string {
+	escaper := strings.NewReplacer("\\", "\\\\", "`", "\\`")
+	return "```\n" + escaper.Replace(RawQuote(q, syntax)) + "\n```"
+}
+
+// RawQuote returns the quote in the same format users send quotes with, using keywords of syntax
+func RawQuote(q *utils.Quote, syntax *db.QuoteSyntax) string {
+	return rawQuote(q, syntax, q.Text, func(text string) string { return text })
+}
+
+// rawQuote returns the raw quote with text as its already formatted text and the rest escaped with escape
+func rawQuote(q *utils.Quote, syntax *db.QuoteSyntax, text string, escape func(string) string) string {
+	message := text
+	if strings.Contains(q.Text, "\n") {
+		message = escape(utils.QUOTE_TEXT_BLOCK_DELIMITER+"\n") + text + escape("\n"+utils.QUOTE_TEXT_BLOCK_DELIMITER)
+	}
+	if len(q.Sources) != 0 {
+		message += escape("\n" + syntax.SourcesPrefix + " " + strings.Join(q.Sources, ", "))
+	}
+
+	if q.Location != "" {
+		message += escape("\n" + syntax.PagePrefix + " " + q.Location)
+	}
+
+	if q.Note != "" {
+		message += escape("\n" + syntax.NotePrefix + " " + strings.Join(strings.Split(q.Note, "\n"), "\n"+syntax.NotePrefix+" "))
+	}
+
+	if len(q.Tags) != 0 {
+		message += escape("\n" + syntax.TagMarker + strings.Join(q.Tags, " "+syntax.TagMarker))
+	}
+
+	return message
+}
+
+// IMPORTANT needs support for Markdown parseMode. The text of the quote keeps its formatting,
+// so it is kept when the quote is copied and sent back.
+func EditQuote(q *utils.Quote, syntax *db.QuoteSyntax) string {
+	return bot.EscapeMarkdown("Current quote:\n") +
+		rawQuote(q, syntax, utils.EntitiesToMarkdown(q.Text, q.Entities), bot.EscapeMarkdown) +
+		bot.EscapeMarkdown(fmt.Sprintf(`
+Send the new version of the quote in the same format you add quotes. Text, sources, tags, page and note of the quote will be replaced with it. The first source will be the main source.
+You can also send '%s' to cancel the operation.`, CancelAnswer))
+}
+
+const NothingToExport = "You have no quotes to export yet. 🧐"
+
+var UnknownExportFormat = fmt.Sprintf(`Couldn't understand the format. 🤔
+Valid formats are %s. For example:
+%s csv`, strings.Join(utils.EXPORT_FORMATS, ", "), COMMAND_EXPORT)
+
+func LibraryExported(quotesCount int) string {
+	return "✅ Exported " + strconv.Itoa(quotesCount) + " quotes."
+}
+
+var SendImportFile = fmt.Sprintf("Send the json or csv file you got from '%s' command, or the 'My Clippings.txt' file of your Kindle.\nYou can also send '%s' to cancel the operation.", COMMAND_EXPORT, CancelAnswer)
+var SendImportFileAsDocument = fmt.Sprintf("Couldn't find any file in your message. 🤔\nSend the file you got from '%s' command as a document, or send '%s' to cancel the operation.", COMMAND_EXPORT, CancelAnswer)
+var UnsupportedImportFormat = fmt.Sprintf("Only %s files can be imported. 🧐\nSend another file or send '%s' to cancel the operation.", strings.Join(utils.IMPORT_FORMATS, ", "), CancelAnswer)
+var ImportFileTooLarge = fmt.Sprintf("The file is too large, files can be at most %dMB. 🧐\nSend another file or send '%s' to cancel the operation.", utils.MAX_IMPORT_FILE_SIZE/1024/1024, CancelAnswer)
+var MalformedImportFile = fmt.Sprintf("❌ Couldn't read the file, nothing is imported.\nMake sure the file is created with '%s' command or is the clippings file of your Kindle and send it again, or send '%s' to cancel the operation.", COMMAND_EXPORT, CancelAnswer)
+
+func LibraryImported(res *db.ImportResult) string {
+	return fmt.Sprintf("✅ Import finished.\nCreated: %d\nDuplicates: %d\nSkipped (without text): %d", res.Created, res.Duplicates, res.Skipped)
+}
+
 // LIBRARIES /////////////////////////////////////////////////////
 const OnlyTheOwnerCanAddNewUsers = "❌ Only the owner of library can add new users. (the first person who have created the library)"
 const NoLibraryExistsWithToken = "❌ Library token is not valid."
@@ -261,9 +742,11 @@ If you merge, you current data will be added to the library your joining.
 If you delete, your current data will PERMANENTLY deleted.`
 const LibraryTokenExpired = "❌ Library Token is expired.\nAsk the owner for a new token."
 const LibraryNoLongerExistsOPCanceled = "❌ Library you wanted to join, no longer exists."
-const ConfirmLibraryChangeCancelAnswer = "cancel"
+
+// CancelAnswer cancels the operation of the state users are in
+const CancelAnswer = "cancel"
 const ConfirmLibraryChangeYesAnswer = "Yes, I want use this library."
-const UnknownLibraryConfirmationMessage = "Couldn't understand what you mean.\nValid answers are either '" + ConfirmLibraryChangeYesAnswer + "' or '" + ConfirmLibraryChangeCancelAnswer + "'."
+const UnknownLibraryConfirmationMessage = "Couldn't understand what you mean.\nValid answers are either '" + ConfirmLibraryChangeYesAnswer + "' or '" + CancelAnswer + "'."
 const LibraryChangedSuccessfully = "✅ Library changed successfully."
 
 func YourLibraryToken(token string, lifetimeStr string) string {
@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/go-telegram/bot"
+)
+
+// transport modes of the bot, selected by 'mode' in config
+const MODE_WEBHOOK = "webhook"
+const MODE_HTTP_WEBHOOK = "httpWebhook"
+const MODE_POLLING = "polling"
+
+// WEBHOOK_SECRET_HEADER is the header Telegram sends the secret token of the webhook in
+const WEBHOOK_SECRET_HEADER = "X-Telegram-Bot-Api-Secret-Token"
+
+// runPolling receives updates with getUpdates until ctx is done.
+// Telegram does not send updates with getUpdates while a webhook is set, so it is deleted first.
+func runPolling(ctx context.Context, b *bot.Bot) error {
+	if _, err := b.DeleteWebhook(ctx, &bot.DeleteWebhookParams{}); err != nil {
+		return err
+	}
+
+	b.Start(ctx)
+	return nil
+}
+
+// runWebhook sets the webhook and serves it until ctx is done. If useTLS is false,
+// the webhook is served over plain HTTP, which is useful behind a TLS-terminating reverse proxy.
+// Requests without the secret token of the webhook are rejected, the token is generated for
+// every run if it is not set in config.
+func runWebhook(ctx context.Context, b *bot.Bot, config *Config, useTLS bool) error {
+	secret := config.WebhookSecret
+	if secret == "" {
+		var err error
+		if secret, err = generateWebhookSecret(); err != nil {
+			return err
+		}
+	}
+
+	go b.StartWebhook(ctx)
+
+	_, err := b.SetWebhook(ctx, &bot.SetWebhookParams{
+		URL:         config.WebhookAddress,
+		SecretToken: secret,
+	})
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:    net.JoinHostPort(config.ListenAddress, strconv.Itoa(config.Port)),
+		Handler: webhookSecretHandler(secret, b.WebhookHandler()),
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	if useTLS {
+		err = server.ListenAndServeTLS(config.CertFilePath, config.PrivKeyFilePath)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// webhookSecretHandler passes requests to next only if they have the secret token of the webhook
+func webhookSecretHandler(secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := req.Header.Get(WEBHOOK_SECRET_HEADER)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// generateWebhookSecret returns a random secret token which only has characters Telegram allows in it
+func generateWebhookSecret() (string, error) {
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secretBytes), nil
+}
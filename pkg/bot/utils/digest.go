@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/aigic8/warmlight/internal/db"
+)
+
+const MAX_DIGEST_COUNT = 10
+const DIGEST_TIME_LAYOUT = "15:04"
+
+var ErrInvalidDigestTime = errors.New("invalid digest time")
+var ErrInvalidTimezone = errors.New("invalid timezone")
+
+// ParseDigestTime validates the time of day a digest is sent at and returns it
+// in DIGEST_TIME_LAYOUT format.
+func ParseDigestTime(value string) (string, error) {
+	t, err := time.Parse(DIGEST_TIME_LAYOUT, value)
+	if err != nil {
+		return "", ErrInvalidDigestTime
+	}
+	return t.Format(DIGEST_TIME_LAYOUT), nil
+}
+
+// ParseTimezone validates an IANA timezone name, like "Europe/Berlin".
+func ParseTimezone(value string) (string, error) {
+	if value == "" {
+		return "", ErrInvalidTimezone
+	}
+
+	loc, err := time.LoadLocation(value)
+	if err != nil {
+		return "", ErrInvalidTimezone
+	}
+	return loc.String(), nil
+}
+
+// IsDigestDue reports whether the digest should be sent at now. A digest is due
+// when its time of day has passed in its timezone and it's not sent since then.
+func IsDigestDue(digest *db.Digest, now time.Time) bool {
+	loc, err := time.LoadLocation(digest.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	sendAt, err := time.Parse(DIGEST_TIME_LAYOUT, digest.SendAt)
+	if err != nil {
+		return false
+	}
+
+	localNow := now.In(loc)
+	sendTime := time.Date(localNow.Year(), localNow.Month(), localNow.Day(), sendAt.Hour(), sendAt.Minute(), 0, 0, loc)
+	if localNow.Before(sendTime) {
+		return false
+	}
+
+	return !digest.LastSentAt.Valid || digest.LastSentAt.Time.Before(sendTime)
+}
+
+// DigestSettingsFromDB returns settings of the digest, digest can be nil for
+// users who haven't set their digest yet.
+func DigestSettingsFromDB(digest *db.Digest) (db.DigestSettings, error) {
+	if digest == nil {
+		return db.DigestSettings{
+			SendAt:   db.DEFAULT_DIGEST_SEND_AT,
+			Timezone: db.DEFAULT_DIGEST_TIMEZONE,
+			Count:    db.DEFAULT_DIGEST_COUNT,
+		}, nil
+	}
+
+	filters, err := ParseOutputFilters(digest.Filters)
+	if err != nil {
+		return db.DigestSettings{}, err
+	}
+
+	return db.DigestSettings{
+		IsActive:     digest.IsActive,
+		SendAt:       digest.SendAt,
+		Timezone:     digest.Timezone,
+		Count:        digest.Count,
+		OutputChatID: digest.OutputChatID,
+		Filters:      filters,
+	}, nil
+}
+
+// PickDigestQuotes returns at most count random quotes which pass the filters.
+func PickDigestQuotes(quotes []db.QuoteWithData, sources []db.Source, filters *db.OutputFilters, count int, r *rand.Rand) []db.QuoteWithData {
+	sourcesMap := map[string]db.Source{}
+	for _, source := range sources {
+		sourcesMap[source.Name] = source
+	}
+
+	accepted := []db.QuoteWithData{}
+	for _, quote := range quotes {
+		quoteSources := []db.Source{}
+		for _, name := range quote.Sources {
+			if source, ok := sourcesMap[name]; ok {
+				quoteSources = append(quoteSources, source)
+			}
+		}
+
+		if OutputAcceptsQuote(filters, quote.Tags, quoteSources) {
+			accepted = append(accepted, quote)
+		}
+	}
+
+	r.Shuffle(len(accepted), func(i, j int) { accepted[i], accepted[j] = accepted[j], accepted[i] })
+	if len(accepted) > count {
+		accepted = accepted[:count]
+	}
+	return accepted
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"database/sql"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDigestTime(t *testing.T) {
+	sendAt, err := ParseDigestTime("8:30")
+	assert.Nil(t, err)
+	assert.Equal(t, "08:30", sendAt)
+
+	_, err = ParseDigestTime("25:00")
+	assert.ErrorIs(t, err, ErrInvalidDigestTime)
+
+	_, err = ParseDigestTime("morning")
+	assert.ErrorIs(t, err, ErrInvalidDigestTime)
+}
+
+func TestParseTimezone(t *testing.T) {
+	timezone, err := ParseTimezone("Europe/Berlin")
+	assert.Nil(t, err)
+	assert.Equal(t, "Europe/Berlin", timezone)
+
+	_, err = ParseTimezone("Mars/Olympus")
+	assert.ErrorIs(t, err, ErrInvalidTimezone)
+
+	_, err = ParseTimezone("")
+	assert.ErrorIs(t, err, ErrInvalidTimezone)
+}
+
+func TestIsDigestDue(t *testing.T) {
+	// 07:30 UTC is 09:30 in Berlin in summer
+	now := time.Date(2023, 6, 10, 7, 30, 0, 0, time.UTC)
+
+	testCases := []struct {
+		Name     string
+		Digest   db.Digest
+		Expected bool
+	}{
+		{Name: "not sent yet", Digest: db.Digest{SendAt: "09:00", Timezone: "Europe/Berlin"}, Expected: true},
+		{Name: "before time", Digest: db.Digest{SendAt: "10:00", Timezone: "Europe/Berlin"}, Expected: false},
+		{Name: "before time in UTC", Digest: db.Digest{SendAt: "09:00", Timezone: "UTC"}, Expected: false},
+		{Name: "sent yesterday", Digest: db.Digest{SendAt: "09:00", Timezone: "Europe/Berlin", LastSentAt: sql.NullTime{Valid: true, Time: now.Add(-24 * time.Hour)}}, Expected: true},
+		{Name: "sent today", Digest: db.Digest{SendAt: "09:00", Timezone: "Europe/Berlin", LastSentAt: sql.NullTime{Valid: true, Time: now.Add(-10 * time.Minute)}}, Expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, IsDigestDue(&tc.Digest, now))
+		})
+	}
+}
+
+func TestPickDigestQuotes(t *testing.T) {
+	quotes := []db.QuoteWithData{
+		{ID: 1, Text: "All animals are equal", Tags: []string{"politics"}, Sources: []string{"Animal Farm"}},
+		{ID: 2, Text: "Big Brother is watching you", Tags: []string{"politics"}, Sources: []string{"1984"}},
+		{ID: 3, Text: "Simplicity is prerequisite for reliability", Tags: []string{"programming"}},
+	}
+	sources := []db.Source{{Name: "Animal Farm", Kind: db.SourceKindBook}, {Name: "1984", Kind: db.SourceKindBook}}
+	r := rand.New(rand.NewSource(1))
+
+	picked := PickDigestQuotes(quotes, sources, &db.OutputFilters{}, 2, r)
+	assert.Equal(t, 2, len(picked))
+
+	picked = PickDigestQuotes(quotes, sources, &db.OutputFilters{IncludeTags: []string{"politics"}, ExcludeSources: []string{"1984"}}, 2, r)
+	assert.Equal(t, 1, len(picked))
+	assert.Equal(t, int64(1), picked[0].ID)
+
+	picked = PickDigestQuotes(quotes, sources, &db.OutputFilters{IncludeSourceKinds: []string{"article"}}, 2, r)
+	assert.Equal(t, 0, len(picked))
+}
@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const ENTITY_TYPE_SPOILER = "spoiler"
+
+// TEXT_ENTITY_TYPES are types of entities which are kept in quotes. Other entities like
+// mentions, hashtags and urls are not formatting and are found by Telegram itself.
+var TEXT_ENTITY_TYPES = []string{
+	string(models.MessageEntityTypeBold),
+	string(models.MessageEntityTypeItalic),
+	string(models.MessageEntityTypeUnderline),
+	string(models.MessageEntityTypeStrikethrough),
+	ENTITY_TYPE_SPOILER,
+	string(models.MessageEntityTypeCode),
+	string(models.MessageEntityTypePre),
+	string(models.MessageEntityTypeTextLink),
+}
+
+// TextEntitiesFromMessage returns the formatting entities of a message, for its text or its caption
+func TextEntitiesFromMessage(entities []models.MessageEntity) []db.TextEntity {
+	var res []db.TextEntity
+	for _, entity := range entities {
+		if !isTextEntityType(string(entity.Type)) {
+			continue
+		}
+		res = append(res, db.TextEntity{
+			Type:     string(entity.Type),
+			Offset:   entity.Offset,
+			Length:   entity.Length,
+			URL:      entity.URL,
+			Language: entity.Language,
+		})
+	}
+	return res
+}
+
+// EntitiesToMarkdown returns text with its entities in MarkdownV2 of Telegram, with the rest of text escaped
+func EntitiesToMarkdown(text string, entities []db.TextEntity) string {
+	return formatTextEntities(text, entities, markdownEntityFormat)
+}
+
+// EntitiesToHTML returns text with its entities in HTML, with the rest of text escaped
+func EntitiesToHTML(text string, entities []db.TextEntity) string {
+	return formatTextEntities(text, entities, htmlEntityFormat)
+}
+
+type entityFormat struct {
+	Escape      func(text string, inCode bool) string
+	Open        func(entity *db.TextEntity) string
+	Close       func(entity *db.TextEntity) string
+	Concatenate func(text string, marker string) string
+}
+
+var markdownEntityFormat = entityFormat{
+	Escape: func(text string, inCode bool) string {
+		if inCode {
+			return strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(text)
+		}
+		return bot.EscapeMarkdown(text)
+	},
+	Open: func(entity *db.TextEntity) string {
+		switch entity.Type {
+		case string(models.MessageEntityTypePre):
+			return "```" + entity.Language + "\n"
+		case string(models.MessageEntityTypeTextLink):
+			return "["
+		default:
+			return markdownEntityMarkers[entity.Type]
+		}
+	},
+	Close: func(entity *db.TextEntity) string {
+		switch entity.Type {
+		case string(models.MessageEntityTypePre):
+			return "```"
+		case string(models.MessageEntityTypeTextLink):
+			return "](" + strings.NewReplacer("\\", "\\\\", ")", "\\)").Replace(entity.URL) + ")"
+		default:
+			return markdownEntityMarkers[entity.Type]
+		}
+	},
+	// https://core.telegram.org/bots/api#markdownv2-style '_' of italic and '__' of underline are
+	// ambiguous when they are next to each other, '\r' between them is ignored by Telegram
+	Concatenate: func(text string, marker string) string {
+		if strings.HasSuffix(text, "_") && strings.HasPrefix(marker, "_") {
+			return text + "\r" + marker
+		}
+		return text + marker
+	},
+}
+
+var markdownEntityMarkers = map[string]string{
+	string(models.MessageEntityTypeBold):          "*",
+	string(models.MessageEntityTypeItalic):        "_",
+	string(models.MessageEntityTypeUnderline):     "__",
+	string(models.MessageEntityTypeStrikethrough): "~",
+	ENTITY_TYPE_SPOILER:                           "||",
+	string(models.MessageEntityTypeCode):          "`",
+}
+
+var htmlEntityFormat = entityFormat{
+	Escape: func(text string, inCode bool) string {
+		return html.EscapeString(text)
+	},
+	Open: func(entity *db.TextEntity) string {
+		switch entity.Type {
+		case string(models.MessageEntityTypePre):
+			if entity.Language != "" {
+				return `<pre><code class="language-` + html.EscapeString(entity.Language) + `">`
+			}
+			return "<pre>"
+		case string(models.MessageEntityTypeTextLink):
+			return `<a href="` + html.EscapeString(entity.URL) + `">`
+		default:
+			return "<" + htmlEntityTags[entity.Type] + ">"
+		}
+	},
+	Close: func(entity *db.TextEntity) string {
+		switch entity.Type {
+		case string(models.MessageEntityTypePre):
+			if entity.Language != "" {
+				return "</code></pre>"
+			}
+			return "</pre>"
+		case string(models.MessageEntityTypeTextLink):
+			return "</a>"
+		default:
+			return "</" + htmlEntityTags[entity.Type] + ">"
+		}
+	},
+	Concatenate: func(text string, marker string) string { return text + marker },
+}
+
+var htmlEntityTags = map[string]string{
+	string(models.MessageEntityTypeBold):          "b",
+	string(models.MessageEntityTypeItalic):        "i",
+	string(models.MessageEntityTypeUnderline):     "u",
+	string(models.MessageEntityTypeStrikethrough): "s",
+	ENTITY_TYPE_SPOILER:                           "tg-spoiler",
+	string(models.MessageEntityTypeCode):          "code",
+}
+
+// formatTextEntities writes text with markers of its entities around their parts. Entities should
+// be nested like Telegram entities, parts of entities which are not nested are left out, as well as
+// entities inside code and pre entities.
+func formatTextEntities(text string, entities []db.TextEntity, format entityFormat) string {
+	units := utf16.Encode([]rune(text))
+
+	valid := make([]db.TextEntity, 0, len(entities))
+	for _, entity := range entities {
+		if isTextEntityType(entity.Type) && entity.Offset >= 0 && entity.Length > 0 && entity.Offset+entity.Length <= len(units) {
+			valid = append(valid, entity)
+		}
+	}
+	// outer entities first, so they are opened before the entities inside them
+	sort.SliceStable(valid, func(i, j int) bool {
+		if valid[i].Offset != valid[j].Offset {
+			return valid[i].Offset < valid[j].Offset
+		}
+		return valid[i].Length > valid[j].Length
+	})
+
+	type openEntity struct {
+		entity *db.TextEntity
+		end    int
+	}
+	var open []openEntity
+	inCode := func() bool {
+		if len(open) == 0 {
+			return false
+		}
+		t := open[len(open)-1].entity.Type
+		return t == string(models.MessageEntityTypeCode) || t == string(models.MessageEntityTypePre)
+	}
+
+	res := ""
+	pos, next := 0, 0
+	for pos < len(units) {
+		for next < len(valid) && valid[next].Offset == pos {
+			entity := &valid[next]
+			next++
+			if inCode() {
+				continue
+			}
+
+			end := entity.Offset + entity.Length
+			if len(open) != 0 && end > open[len(open)-1].end {
+				end = open[len(open)-1].end
+			}
+			open = append(open, openEntity{entity: entity, end: end})
+			res = format.Concatenate(res, format.Open(entity))
+		}
+
+		// text is written until the next entity starts or an open entity ends
+		end := len(units)
+		if next < len(valid) && valid[next].Offset < end {
+			end = valid[next].Offset
+		}
+		if len(open) != 0 && open[len(open)-1].end < end {
+			end = open[len(open)-1].end
+		}
+		res += format.Escape(string(utf16.Decode(units[pos:end])), inCode())
+		pos = end
+
+		for len(open) != 0 && open[len(open)-1].end <= pos {
+			res = format.Concatenate(res, format.Close(open[len(open)-1].entity))
+			open = open[:len(open)-1]
+		}
+	}
+
+	return res
+}
+
+func isTextEntityType(entityType string) bool {
+	for _, t := range TEXT_ENTITY_TYPES {
+		if t == entityType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/go-telegram/bot/models"
+	"github.com/stretchr/testify/assert"
+)
+
+type formatTextEntitiesTestCase struct {
+	Name     string
+	Text     string
+	Entities []db.TextEntity
+	Markdown string
+	HTML     string
+}
+
+func TestFormatTextEntities(t *testing.T) {
+	testCases := []formatTextEntitiesTestCase{
+		{
+			Name:     "noEntities",
+			Text:     "1 < 2, isn't it?",
+			Markdown: "1 < 2, isn't it?",
+			HTML:     "1 &lt; 2, isn&#39;t it?",
+		},
+		{
+			Name:     "bold",
+			Text:     "Be one.",
+			Entities: []db.TextEntity{{Type: "bold", Offset: 0, Length: 6}},
+			Markdown: "*Be one*\\.",
+			HTML:     "<b>Be one</b>.",
+		},
+		{
+			Name:     "nested",
+			Text:     "all animals are equal",
+			Entities: []db.TextEntity{{Type: "italic", Offset: 4, Length: 7}, {Type: "bold", Offset: 0, Length: 21}},
+			Markdown: "*all _animals_ are equal*",
+			HTML:     "<b>all <i>animals</i> are equal</b>",
+		},
+		{
+			// offsets are in UTF-16 code units, so the emoji is 2 units
+			Name:     "emoji",
+			Text:     "🔥 fire walk with me",
+			Entities: []db.TextEntity{{Type: "strikethrough", Offset: 3, Length: 4}, {Type: ENTITY_TYPE_SPOILER, Offset: 8, Length: 4}},
+			Markdown: "🔥 ~fire~ ||walk|| with me",
+			HTML:     "🔥 <s>fire</s> <tg-spoiler>walk</tg-spoiler> with me",
+		},
+		{
+			Name:     "link",
+			Text:     "read the docs",
+			Entities: []db.TextEntity{{Type: "text_link", Offset: 9, Length: 4, URL: "https://example.com/a_(b)"}},
+			Markdown: "read the [docs](https://example.com/a_(b\\))",
+			HTML:     `read the <a href="https://example.com/a_(b)">docs</a>`,
+		},
+		{
+			Name:     "code",
+			Text:     "run `go test` *now*",
+			Entities: []db.TextEntity{{Type: "code", Offset: 4, Length: 9}, {Type: "bold", Offset: 5, Length: 2}},
+			Markdown: "run `\\`go test\\`` \\*now\\*",
+			HTML:     "run <code>`go test`</code> *now*",
+		},
+		{
+			Name:     "pre",
+			Text:     "fmt.Println(1)",
+			Entities: []db.TextEntity{{Type: "pre", Offset: 0, Length: 14, Language: "go"}},
+			Markdown: "```go\nfmt.Println(1)```",
+			HTML:     `<pre><code class="language-go">fmt.Println(1)</code></pre>`,
+		},
+		{
+			Name:     "italicUnderline",
+			Text:     "who watches",
+			Entities: []db.TextEntity{{Type: "underline", Offset: 0, Length: 11}, {Type: "italic", Offset: 4, Length: 7}},
+			Markdown: "__who _watches_\r__",
+			HTML:     "<u>who <i>watches</i></u>",
+		},
+		{
+			Name:     "invalid",
+			Text:     "short",
+			Entities: []db.TextEntity{{Type: "bold", Offset: 2, Length: 10}, {Type: "mention", Offset: 0, Length: 5}, {Type: "italic", Offset: 0, Length: 0}},
+			Markdown: "short",
+			HTML:     "short",
+		},
+		{
+			Name:     "intersecting",
+			Text:     "one two three",
+			Entities: []db.TextEntity{{Type: "bold", Offset: 0, Length: 7}, {Type: "italic", Offset: 4, Length: 9}},
+			Markdown: "*one _two_* three",
+			HTML:     "<b>one <i>two</i></b> three",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Markdown, EntitiesToMarkdown(tc.Text, tc.Entities))
+			assert.Equal(t, tc.HTML, EntitiesToHTML(tc.Text, tc.Entities))
+		})
+	}
+}
+
+func TestTextEntitiesFromMessage(t *testing.T) {
+	entities := TextEntitiesFromMessage([]models.MessageEntity{
+		{Type: models.MessageEntityTypeBold, Offset: 0, Length: 3},
+		{Type: models.MessageEntityTypeHashtag, Offset: 4, Length: 5},
+		{Type: models.MessageEntityTypeTextLink, Offset: 10, Length: 4, URL: "https://example.com"},
+	})
+	assert.Equal(t, []db.TextEntity{
+		{Type: "bold", Offset: 0, Length: 3},
+		{Type: "text_link", Offset: 10, Length: 4, URL: "https://example.com"},
+	}, entities)
+}
@@ -0,0 +1,251 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/jackc/pgtype"
+)
+
+const EXPORT_FORMAT_JSON = "json"
+const EXPORT_FORMAT_CSV = "csv"
+const EXPORT_FORMAT_MARKDOWN = "md"
+
+const EXPORT_VERSION = 1
+
+var EXPORT_FORMATS = []string{EXPORT_FORMAT_JSON, EXPORT_FORMAT_CSV, EXPORT_FORMAT_MARKDOWN}
+
+var CSV_EXPORT_HEADER = []string{"text", "main source", "sources", "tags", "created at", "updated at", "location", "note"}
+
+// CSV_LEGACY_HEADER_LENGTH is the number of columns in files exported before location and note of quotes
+const CSV_LEGACY_HEADER_LENGTH = 6
+
+var ErrUnknownExportFormat = errors.New("unknown export format")
+
+type (
+	LibraryExport struct {
+		Version    int             `json:"version"`
+		ExportedAt time.Time       `json:"exportedAt"`
+		Quotes     []ExportedQuote `json:"quotes"`
+	}
+
+	ExportedQuote struct {
+		Text         string           `json:"text"`
+		TextEntities []db.TextEntity  `json:"textEntities,omitempty"`
+		MainSource   string           `json:"mainSource,omitempty"`
+		Location     string           `json:"location,omitempty"`
+		Note         string           `json:"note,omitempty"`
+		Sources      []ExportedSource `json:"sources"`
+		Tags         []string         `json:"tags"`
+		CreatedAt    time.Time        `json:"createdAt"`
+		UpdatedAt    time.Time        `json:"updatedAt"`
+	}
+
+	// ExportedSource data is one of SourceBookData, SourcePersonData or SourceArticleData in JSON based on kind.
+	ExportedSource struct {
+		Name string          `json:"name"`
+		Kind string          `json:"kind"`
+		Data json.RawMessage `json:"data,omitempty"`
+	}
+)
+
+func MakeLibraryExport(quotes []db.QuoteWithData, sources []db.Source) *LibraryExport {
+	sourcesMap := map[string]db.Source{}
+	for _, source := range sources {
+		sourcesMap[source.Name] = source
+	}
+
+	export := LibraryExport{Version: EXPORT_VERSION, ExportedAt: time.Now(), Quotes: []ExportedQuote{}}
+	for _, quote := range quotes {
+		q := QuoteFromDB(&quote)
+		exportedQuote := ExportedQuote{
+			Text:         q.Text,
+			TextEntities: q.Entities,
+			MainSource:   q.MainSource,
+			Location:     q.Location,
+			Note:         q.Note,
+			Sources:      []ExportedSource{},
+			Tags:         []string{},
+			CreatedAt:    quote.CreatedAt,
+			UpdatedAt:    quote.UpdatedAt,
+		}
+		exportedQuote.Tags = append(exportedQuote.Tags, q.Tags...)
+
+		for _, sourceName := range q.Sources {
+			exportedSource := ExportedSource{Name: sourceName, Kind: string(db.SourceKindUnknown)}
+			if source, ok := sourcesMap[sourceName]; ok {
+				exportedSource.Kind = string(source.Kind)
+				if source.Data.Status == pgtype.Present {
+					exportedSource.Data = json.RawMessage(source.Data.Bytes)
+				}
+			}
+			exportedQuote.Sources = append(exportedQuote.Sources, exportedSource)
+		}
+
+		export.Quotes = append(export.Quotes, exportedQuote)
+	}
+
+	return &export
+}
+
+// Encode returns content of the export file in format. Format is also used as the file extension.
+func (e *LibraryExport) Encode(format string) ([]byte, error) {
+	switch format {
+	case EXPORT_FORMAT_JSON:
+		return json.MarshalIndent(e, "", "  ")
+	case EXPORT_FORMAT_CSV:
+		return e.csv()
+	case EXPORT_FORMAT_MARKDOWN:
+		return e.markdown()
+	default:
+		return nil, ErrUnknownExportFormat
+	}
+}
+
+func (e *LibraryExport) csv() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(CSV_EXPORT_HEADER); err != nil {
+		return nil, err
+	}
+
+	for _, quote := range e.Quotes {
+		sourcesBytes, err := json.Marshal(quote.Sources)
+		if err != nil {
+			return nil, err
+		}
+
+		record := []string{
+			quote.Text,
+			quote.MainSource,
+			string(sourcesBytes),
+			strings.Join(quote.Tags, " "),
+			quote.CreatedAt.Format(time.RFC3339),
+			quote.UpdatedAt.Format(time.RFC3339),
+			quote.Location,
+			quote.Note,
+		}
+		if err = w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *LibraryExport) markdown() ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("# WarmLight quotes\n")
+	b.WriteString(fmt.Sprintf("Exported on %s, %d quotes.\n", e.ExportedAt.Format("2006-01-02"), len(e.Quotes)))
+
+	for _, quote := range e.Quotes {
+		b.WriteString("\n---\n\n")
+		text := quote.Text
+		if len(quote.TextEntities) != 0 {
+			// markdown has no underline and spoiler, so formatting is written in HTML which markdown allows
+			text = EntitiesToHTML(quote.Text, quote.TextEntities)
+		}
+		for _, line := range strings.Split(text, "\n") {
+			b.WriteString("> " + line + "\n")
+		}
+
+		if quote.MainSource != "" && quote.Location != "" {
+			b.WriteString("\n— " + quote.MainSource + ", p. " + quote.Location + "\n")
+		} else if quote.MainSource != "" {
+			b.WriteString("\n— " + quote.MainSource + "\n")
+		} else if quote.Location != "" {
+			b.WriteString("\n— p. " + quote.Location + "\n")
+		}
+
+		if quote.Note != "" {
+			b.WriteString("\nNote: " + quote.Note + "\n")
+		}
+
+		if len(quote.Sources) != 0 {
+			b.WriteString("\nSources:\n")
+			for _, source := range quote.Sources {
+				details, err := exportedSourceDetails(&source)
+				if err != nil {
+					return nil, err
+				}
+				b.WriteString("- " + source.Name + " (" + source.Kind + ")")
+				if len(details) != 0 {
+					b.WriteString(": " + strings.Join(details, ", "))
+				}
+				b.WriteString("\n")
+			}
+		}
+
+		if len(quote.Tags) != 0 {
+			b.WriteString("\nTags: #" + strings.Join(quote.Tags, " #") + "\n")
+		}
+
+		b.WriteString("\nAdded on " + quote.CreatedAt.Format("2006-01-02") + "\n")
+	}
+
+	return []byte(b.String()), nil
+}
+
+func exportedSourceDetails(source *ExportedSource) ([]string, error) {
+	if len(source.Data) == 0 {
+		return nil, nil
+	}
+
+	data := pgtype.JSON{Bytes: source.Data, Status: pgtype.Present}
+	sourceData, err := ParseSourceData(db.SourceKind(source.Kind), data)
+	if err != nil {
+		return nil, err
+	}
+
+	details := []string{}
+	addDetail := func(name, value string) {
+		if value != "" {
+			details = append(details, name+": "+value)
+		}
+	}
+
+	switch sd := sourceData.(type) {
+	case db.SourceBookData:
+		addDetail("author", sd.Author)
+		addDetail("info url", sd.LinkToInfo)
+		addDetail("author url", sd.LinkToAuthor)
+	case db.SourcePersonData:
+		addDetail("title", sd.Title)
+		addDetail("info url", sd.LinkToInfo)
+		if !sd.BornOn.IsZero() || !sd.DeathOn.IsZero() {
+			bornOnStr, deathOnStr := "", ""
+			if !sd.BornOn.IsZero() {
+				bornOnStr = strconv.Itoa(sd.BornOn.Year())
+			}
+			if !sd.DeathOn.IsZero() {
+				deathOnStr = strconv.Itoa(sd.DeathOn.Year())
+			}
+			addDetail("lived in", bornOnStr+"-"+deathOnStr)
+		}
+	case db.SourceArticleData:
+		addDetail("author", sd.Author)
+		addDetail("url", sd.URL)
+	}
+
+	return details, nil
+}
+
+func IsValidExportFormat(format string) bool {
+	for _, validFormat := range EXPORT_FORMATS {
+		if format == validFormat {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestLibraryExport() *LibraryExport {
+	createdAt := time.Date(2023, 3, 10, 12, 0, 0, 0, time.UTC)
+	quotes := []db.QuoteWithData{
+		{
+			ID:         1,
+			Text:       "All animals are equal, but some animals are more equal than others.",
+			MainSource: sql.NullString{Valid: true, String: "Animal Farm"},
+			Location:   sql.NullString{Valid: true, String: "112"},
+			Note:       sql.NullString{Valid: true, String: "the last commandment"},
+			CreatedAt:  createdAt,
+			UpdatedAt:  createdAt,
+			Tags:       []string{"politics"},
+			Sources:    []string{"Animal Farm"},
+		},
+		{
+			ID:           2,
+			Text:         "Simplicity is prerequisite for reliability.",
+			TextEntities: []db.TextEntity{{Type: "bold", Offset: 0, Length: 10}},
+			CreatedAt:    createdAt,
+			UpdatedAt:    createdAt,
+		},
+	}
+
+	bookData, err := json.Marshal(db.SourceBookData{Author: "George Orwell"})
+	if err != nil {
+		panic(err)
+	}
+	sources := []db.Source{{Name: "Animal Farm", Kind: db.SourceKindBook, Data: pgtype.JSON{Bytes: bookData, Status: pgtype.Present}}}
+
+	return MakeLibraryExport(quotes, sources)
+}
+
+func TestMakeLibraryExport(t *testing.T) {
+	export := makeTestLibraryExport()
+	assert.Equal(t, EXPORT_VERSION, export.Version)
+	assert.Equal(t, 2, len(export.Quotes))
+
+	q1 := export.Quotes[0]
+	assert.Equal(t, "Animal Farm", q1.MainSource)
+	assert.Equal(t, []string{"politics"}, q1.Tags)
+	assert.Equal(t, 1, len(q1.Sources))
+	assert.Equal(t, string(db.SourceKindBook), q1.Sources[0].Kind)
+	assert.JSONEq(t, `{"author": "George Orwell"}`, string(q1.Sources[0].Data))
+	assert.Equal(t, "112", q1.Location)
+	assert.Equal(t, "the last commandment", q1.Note)
+
+	q2 := export.Quotes[1]
+	assert.Equal(t, "", q2.MainSource)
+	assert.Equal(t, []string{}, q2.Tags)
+	assert.Equal(t, []ExportedSource{}, q2.Sources)
+	assert.Equal(t, []db.TextEntity{{Type: "bold", Offset: 0, Length: 10}}, q2.TextEntities)
+}
+
+func TestLibraryExportEncode(t *testing.T) {
+	export := makeTestLibraryExport()
+
+	jsonContent, err := export.Encode(EXPORT_FORMAT_JSON)
+	assert.Nil(t, err)
+	var decoded LibraryExport
+	assert.Nil(t, json.Unmarshal(jsonContent, &decoded))
+	assert.Equal(t, 2, len(decoded.Quotes))
+	assert.Equal(t, export.Quotes[0].Text, decoded.Quotes[0].Text)
+	assert.Equal(t, export.Quotes[0].Tags, decoded.Quotes[0].Tags)
+	assert.JSONEq(t, string(export.Quotes[0].Sources[0].Data), string(decoded.Quotes[0].Sources[0].Data))
+	assert.True(t, export.Quotes[0].CreatedAt.Equal(decoded.Quotes[0].CreatedAt))
+
+	csvContent, err := export.Encode(EXPORT_FORMAT_CSV)
+	assert.Nil(t, err)
+	records, err := csv.NewReader(bytes.NewReader(csvContent)).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, CSV_EXPORT_HEADER, records[0])
+	assert.Equal(t, "Animal Farm", records[1][1])
+	assert.Equal(t, "politics", records[1][3])
+	assert.Equal(t, "2023-03-10T12:00:00Z", records[1][4])
+	assert.Equal(t, "112", records[1][6])
+	assert.Equal(t, "the last commandment", records[1][7])
+
+	mdContent, err := export.Encode(EXPORT_FORMAT_MARKDOWN)
+	assert.Nil(t, err)
+	md := string(mdContent)
+	assert.True(t, strings.Contains(md, "> All animals are equal, but some animals are more equal than others.\n"))
+	assert.True(t, strings.Contains(md, "- Animal Farm (book): author: George Orwell\n"))
+	assert.True(t, strings.Contains(md, "Tags: #politics\n"))
+	assert.True(t, strings.Contains(md, "— Animal Farm, p. 112\n"))
+	assert.True(t, strings.Contains(md, "Note: the last commandment\n"))
+	assert.True(t, strings.Contains(md, "> <b>Simplicity</b> is prerequisite for reliability.\n"))
+
+	_, err = export.Encode("pdf")
+	assert.ErrorIs(t, err, ErrUnknownExportFormat)
+}
+
+func TestExportedSourceDetailsLivedIn(t *testing.T) {
+	bornOn := time.Date(1903, 1, 1, 1, 1, 1, 1, time.UTC)
+	deathOn := time.Date(1950, 1, 1, 1, 1, 1, 1, time.UTC)
+	testCases := []struct {
+		Name    string
+		Data    db.SourcePersonData
+		Details []string
+	}{
+		{Name: "bothDates", Data: db.SourcePersonData{BornOn: bornOn, DeathOn: deathOn}, Details: []string{"lived in: 1903-1950"}},
+		{Name: "onlyBornOn", Data: db.SourcePersonData{BornOn: bornOn}, Details: []string{"lived in: 1903-"}},
+		{Name: "onlyDeathOn", Data: db.SourcePersonData{DeathOn: deathOn}, Details: []string{"lived in: -1950"}},
+		{Name: "noDates", Data: db.SourcePersonData{}, Details: []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			data, err := json.Marshal(tc.Data)
+			if err != nil {
+				panic(err)
+			}
+			details, err := exportedSourceDetails(&ExportedSource{Name: "George Orwell", Kind: string(db.SourceKindPerson), Data: data})
+			assert.Nil(t, err)
+			assert.Equal(t, tc.Details, details)
+		})
+	}
+}
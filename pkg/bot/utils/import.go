@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/jackc/pgtype"
+)
+
+// Telegram bots can not download files larger than 20MB
+const MAX_IMPORT_FILE_SIZE = 20 * 1024 * 1024
+
+// IMPORT_FORMAT_KINDLE is the "My Clippings.txt" file of Kindle devices
+const IMPORT_FORMAT_KINDLE = "txt"
+
+var IMPORT_FORMATS = []string{EXPORT_FORMAT_JSON, EXPORT_FORMAT_CSV, IMPORT_FORMAT_KINDLE}
+
+var ErrMalformedImportFile = errors.New("malformed import file")
+
+// ImportFormat returns the format of an import file based on its name.
+// Returns an empty string if the format is not supported.
+func ImportFormat(fileName string) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(fileName), "."))
+	for _, format := range IMPORT_FORMATS {
+		if ext == format {
+			return format
+		}
+	}
+	return ""
+}
+
+// ParseLibraryImport parses a file created with /export or a Kindle clippings file in the given format.
+// The whole file is rejected with ErrMalformedImportFile if any part of it is
+// not valid, so nothing is imported from a broken file.
+func ParseLibraryImport(content []byte, format string) ([]db.ImportedQuote, error) {
+	var quotes []ExportedQuote
+	var err error
+	switch format {
+	case EXPORT_FORMAT_JSON:
+		quotes, err = parseJSONImport(content)
+	case EXPORT_FORMAT_CSV:
+		quotes, err = parseCSVImport(content)
+	case IMPORT_FORMAT_KINDLE:
+		return ParseKindleClippings(content)
+	default:
+		return nil, ErrUnknownExportFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]db.ImportedQuote, 0, len(quotes))
+	for _, quote := range quotes {
+		imported := db.ImportedQuote{
+			Text:       quote.Text,
+			MainSource: strings.TrimSpace(quote.MainSource),
+			Location:   quote.Location,
+			Note:       quote.Note,
+			Tags:       []string{},
+			Sources:    []db.ImportedSource{},
+		}
+		for _, entity := range quote.TextEntities {
+			if isTextEntityType(entity.Type) {
+				imported.TextEntities = append(imported.TextEntities, entity)
+			}
+		}
+
+		for _, tag := range quote.Tags {
+			if tag = strings.TrimPrefix(strings.TrimSpace(tag), "#"); tag != "" {
+				imported.Tags = append(imported.Tags, tag)
+			}
+		}
+
+		for _, source := range quote.Sources {
+			importedSource, err := importedSource(&source)
+			if err != nil {
+				return nil, err
+			}
+			imported.Sources = append(imported.Sources, *importedSource)
+		}
+
+		res = append(res, imported)
+	}
+
+	return res, nil
+}
+
+func parseJSONImport(content []byte) ([]ExportedQuote, error) {
+	var export LibraryExport
+	if err := json.Unmarshal(content, &export); err != nil {
+		return nil, ErrMalformedImportFile
+	}
+
+	if export.Version < 1 || export.Version > EXPORT_VERSION || export.Quotes == nil {
+		return nil, ErrMalformedImportFile
+	}
+
+	return export.Quotes, nil
+}
+
+func parseCSVImport(content []byte) ([]ExportedQuote, error) {
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil || len(records) == 0 {
+		return nil, ErrMalformedImportFile
+	}
+
+	header := records[0]
+	if len(header) != len(CSV_EXPORT_HEADER) && len(header) != CSV_LEGACY_HEADER_LENGTH {
+		return nil, ErrMalformedImportFile
+	}
+	for i, column := range CSV_EXPORT_HEADER[:len(header)] {
+		if strings.TrimSpace(header[i]) != column {
+			return nil, ErrMalformedImportFile
+		}
+	}
+
+	quotes := make([]ExportedQuote, 0, len(records)-1)
+	for _, record := range records[1:] {
+		quote := ExportedQuote{Text: record[0], MainSource: record[1], Tags: strings.Fields(record[3])}
+		if len(record) > CSV_LEGACY_HEADER_LENGTH {
+			quote.Location, quote.Note = record[6], record[7]
+		}
+		if sourcesStr := strings.TrimSpace(record[2]); sourcesStr != "" {
+			if err = json.Unmarshal([]byte(sourcesStr), &quote.Sources); err != nil {
+				return nil, ErrMalformedImportFile
+			}
+		}
+		quotes = append(quotes, quote)
+	}
+
+	return quotes, nil
+}
+
+func importedSource(source *ExportedSource) (*db.ImportedSource, error) {
+	name := strings.TrimSpace(source.Name)
+	if name == "" {
+		return nil, ErrMalformedImportFile
+	}
+
+	kind := db.SourceKind(source.Kind)
+	if kind == "" {
+		kind = db.SourceKindUnknown
+	}
+	if !IsValidSourceKind(string(kind)) {
+		return nil, ErrMalformedImportFile
+	}
+
+	res := db.ImportedSource{Name: name, Kind: kind, Data: pgtype.JSON{Status: pgtype.Null}}
+	if len(source.Data) != 0 && string(source.Data) != "null" {
+		res.Data = pgtype.JSON{Bytes: source.Data, Status: pgtype.Present}
+	}
+
+	if _, err := ParseSourceData(kind, res.Data); err != nil {
+		return nil, ErrMalformedImportFile
+	}
+	if kind == db.SourceKindUnknown {
+		res.Data = pgtype.JSON{Status: pgtype.Null}
+	}
+
+	return &res, nil
+}
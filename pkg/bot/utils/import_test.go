@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportFormat(t *testing.T) {
+	assert.Equal(t, EXPORT_FORMAT_JSON, ImportFormat("warmlight-2023-03-10.json"))
+	assert.Equal(t, EXPORT_FORMAT_CSV, ImportFormat("quotes.CSV"))
+	assert.Equal(t, "", ImportFormat("quotes.md"))
+	assert.Equal(t, "", ImportFormat("quotes"))
+}
+
+func TestParseLibraryImport(t *testing.T) {
+	export := makeTestLibraryExport()
+
+	for _, format := range []string{EXPORT_FORMAT_JSON, EXPORT_FORMAT_CSV} {
+		t.Run(format, func(t *testing.T) {
+			content, err := export.Encode(format)
+			if err != nil {
+				panic(err)
+			}
+
+			quotes, err := ParseLibraryImport(content, format)
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(quotes))
+			assert.Equal(t, export.Quotes[0].Text, quotes[0].Text)
+			assert.Equal(t, "Animal Farm", quotes[0].MainSource)
+			assert.Equal(t, []string{"politics"}, quotes[0].Tags)
+			assert.Equal(t, 1, len(quotes[0].Sources))
+			assert.Equal(t, db.SourceKindBook, quotes[0].Sources[0].Kind)
+			assert.Equal(t, pgtype.Present, quotes[0].Sources[0].Data.Status)
+			assert.JSONEq(t, `{"author": "George Orwell"}`, string(quotes[0].Sources[0].Data.Bytes))
+			assert.Equal(t, "112", quotes[0].Location)
+			assert.Equal(t, "the last commandment", quotes[0].Note)
+			assert.Equal(t, []string{}, quotes[1].Tags)
+			assert.Equal(t, []db.ImportedSource{}, quotes[1].Sources)
+		})
+	}
+
+	// only JSON keeps the formatting of quotes
+	content, err := export.Encode(EXPORT_FORMAT_JSON)
+	if err != nil {
+		panic(err)
+	}
+	quotes, err := ParseLibraryImport(content, EXPORT_FORMAT_JSON)
+	assert.Nil(t, err)
+	assert.Equal(t, export.Quotes[1].TextEntities, quotes[1].TextEntities)
+}
+
+func TestParseLibraryImportLegacyCSV(t *testing.T) {
+	// files exported before quotes had location and note
+	content := "text,main source,sources,tags,created at,updated at\nbla,Animal Farm,,politics,,\n"
+	quotes, err := ParseLibraryImport([]byte(content), EXPORT_FORMAT_CSV)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(quotes))
+	assert.Equal(t, "bla", quotes[0].Text)
+	assert.Equal(t, "Animal Farm", quotes[0].MainSource)
+	assert.Equal(t, "", quotes[0].Location)
+	assert.Equal(t, "", quotes[0].Note)
+}
+
+func TestParseLibraryImportMalformed(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Format  string
+		Content string
+	}{
+		{Name: "invalid json", Format: EXPORT_FORMAT_JSON, Content: `{"version": 1, "quotes": [`},
+		{Name: "no version", Format: EXPORT_FORMAT_JSON, Content: `{"quotes": []}`},
+		{Name: "no quotes", Format: EXPORT_FORMAT_JSON, Content: `{"version": 1}`},
+		{Name: "invalid source kind", Format: EXPORT_FORMAT_JSON, Content: `{"version": 1, "quotes": [{"text": "bla", "sources": [{"name": "bla", "kind": "movie"}]}]}`},
+		{Name: "invalid source data", Format: EXPORT_FORMAT_JSON, Content: `{"version": 1, "quotes": [{"text": "bla", "sources": [{"name": "bla", "kind": "book", "data": "author"}]}]}`},
+		{Name: "source without name", Format: EXPORT_FORMAT_JSON, Content: `{"version": 1, "quotes": [{"text": "bla", "sources": [{"name": " ", "kind": "book"}]}]}`},
+		{Name: "empty csv", Format: EXPORT_FORMAT_CSV, Content: ``},
+		{Name: "wrong csv header", Format: EXPORT_FORMAT_CSV, Content: "text,tags\nbla,bla\n"},
+		{Name: "wrong csv sources", Format: EXPORT_FORMAT_CSV, Content: "text,main source,sources,tags,created at,updated at\nbla,,bla,,,\n"},
+		{Name: "wrong csv fields count", Format: EXPORT_FORMAT_CSV, Content: "text,main source,sources,tags,created at,updated at\nbla,,\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := ParseLibraryImport([]byte(tc.Content), tc.Format)
+			assert.ErrorIs(t, err, ErrMalformedImportFile)
+		})
+	}
+}
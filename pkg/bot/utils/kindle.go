@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/jackc/pgtype"
+)
+
+const KINDLE_CLIPPINGS_SEPARATOR = "=========="
+
+type kindleHighlight struct {
+	Title  string
+	Author string
+	Text   string
+}
+
+// ParseKindleClippings parses the "My Clippings.txt" file of Kindle devices.
+// Every highlight becomes a quote with its book as the source. Notes and
+// bookmarks are skipped. When a highlight is extended on Kindle, both versions
+// are kept in the file, so highlights which are part of a later highlight of the
+// same book are dropped.
+func ParseKindleClippings(content []byte) ([]db.ImportedQuote, error) {
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\ufeff", "")
+	if !strings.Contains(text, KINDLE_CLIPPINGS_SEPARATOR) {
+		return nil, ErrMalformedImportFile
+	}
+
+	highlights := []kindleHighlight{}
+	for _, clipping := range strings.Split(text, KINDLE_CLIPPINGS_SEPARATOR) {
+		clipping = strings.TrimSpace(clipping)
+		if clipping == "" {
+			continue
+		}
+
+		lines := strings.Split(clipping, "\n")
+		if len(lines) < 2 {
+			return nil, ErrMalformedImportFile
+		}
+
+		if !isKindleHighlight(lines[1]) {
+			continue
+		}
+
+		highlightText := strings.TrimSpace(strings.Join(lines[2:], "\n"))
+		if highlightText == "" {
+			continue
+		}
+
+		title, author := parseKindleTitle(lines[0])
+		highlight := kindleHighlight{Title: title, Author: author, Text: highlightText}
+		highlights = appendKindleHighlight(highlights, highlight)
+	}
+
+	quotes := make([]db.ImportedQuote, 0, len(highlights))
+	for _, highlight := range highlights {
+		source, err := kindleBookSource(highlight.Title, highlight.Author)
+		if err != nil {
+			return nil, err
+		}
+
+		quote := db.ImportedQuote{Text: highlight.Text, Tags: []string{}, Sources: []db.ImportedSource{}}
+		if source != nil {
+			quote.MainSource = source.Name
+			quote.Sources = append(quote.Sources, *source)
+		}
+		quotes = append(quotes, quote)
+	}
+
+	return quotes, nil
+}
+
+// kindleNonHighlightWords are the words used for notes and bookmarks in the metadata line
+// of clippings in english, german, french, spanish, portuguese, italian, dutch, japanese and chinese
+var kindleNonHighlightWords = []string{
+	"note", "bookmark", "notiz", "lesezeichen", "signet", "nota", "marcador", "segnalibro",
+	"notitie", "bladwijzer", "メモ", "ブックマーク", "笔记", "书签",
+}
+
+// isKindleHighlight checks the metadata line of a clipping, which is like
+// "- Your Highlight on page 12 | Location 180-181 | Added on ...". The line is
+// localized, so every clipping which is not a note or a bookmark is a highlight.
+func isKindleHighlight(metadata string) bool {
+	metadata = strings.ToLower(metadata)
+	if !strings.HasPrefix(metadata, "- ") {
+		return false
+	}
+
+	for _, word := range kindleNonHighlightWords {
+		if strings.Contains(metadata, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseKindleTitle splits the title line of a clipping which is like
+// "Animal Farm (George Orwell)" to title and author.
+func parseKindleTitle(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if !strings.HasSuffix(line, ")") {
+		return line, ""
+	}
+
+	openIndex := strings.LastIndex(line, "(")
+	if openIndex <= 0 {
+		return line, ""
+	}
+
+	title := strings.TrimSpace(line[:openIndex])
+	author := strings.TrimSpace(line[openIndex+1 : len(line)-1])
+	return title, author
+}
+
+func appendKindleHighlight(highlights []kindleHighlight, highlight kindleHighlight) []kindleHighlight {
+	for i, prev := range highlights {
+		if prev.Title != highlight.Title {
+			continue
+		}
+
+		if strings.Contains(prev.Text, highlight.Text) {
+			return highlights
+		}
+
+		if strings.Contains(highlight.Text, prev.Text) {
+			highlights[i] = highlight
+			return highlights
+		}
+	}
+
+	return append(highlights, highlight)
+}
+
+func kindleBookSource(title, author string) (*db.ImportedSource, error) {
+	if title == "" {
+		return nil, nil
+	}
+
+	source := db.ImportedSource{Name: title, Kind: db.SourceKindBook, Data: pgtype.JSON{Status: pgtype.Null}}
+	if author != "" {
+		dataBytes, err := json.Marshal(db.SourceBookData{Author: author})
+		if err != nil {
+			return nil, err
+		}
+		source.Data = pgtype.JSON{Bytes: dataBytes, Status: pgtype.Present}
+	}
+
+	return &source, nil
+}
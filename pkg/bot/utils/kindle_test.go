@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/assert"
+)
+
+const testKindleClippings = "\ufeffAnimal Farm (George Orwell)\r\n" +
+	"- Your Highlight on page 12 | Location 180-181 | Added on Monday, March 6, 2023 10:11:12 PM\r\n" +
+	"\r\n" +
+	"All animals are equal\r\n" +
+	"==========\r\n" +
+	"\ufeffAnimal Farm (George Orwell)\r\n" +
+	"- Your Highlight on page 12 | Location 180-182 | Added on Monday, March 6, 2023 10:11:40 PM\r\n" +
+	"\r\n" +
+	"All animals are equal, but some animals are more equal than others.\r\n" +
+	"==========\r\n" +
+	"\ufeffAnimal Farm (George Orwell)\r\n" +
+	"- Your Note on page 12 | Location 182 | Added on Monday, March 6, 2023 10:12:00 PM\r\n" +
+	"\r\n" +
+	"the main idea of the book\r\n" +
+	"==========\r\n" +
+	"\ufeffThe Pragmatic Programmer (Hunt, Andrew;Thomas, David)\r\n" +
+	"- Your Bookmark on page 40 | Location 610 | Added on Tuesday, March 7, 2023 9:00:00 AM\r\n" +
+	"\r\n" +
+	"\r\n" +
+	"==========\r\n" +
+	"\ufeffThe Pragmatic Programmer (Hunt, Andrew;Thomas, David)\r\n" +
+	"- Your Highlight on page 41 | Location 612-613 | Added on Tuesday, March 7, 2023 9:01:00 AM\r\n" +
+	"\r\n" +
+	"Don't live with broken windows.\r\n" +
+	"==========\r\n" +
+	"\ufeffThe Pragmatic Programmer (Hunt, Andrew;Thomas, David)\r\n" +
+	"- Your Highlight on page 41 | Location 612-613 | Added on Tuesday, March 7, 2023 9:01:00 AM\r\n" +
+	"\r\n" +
+	"Don't live with broken windows.\r\n" +
+	"==========\r\n" +
+	"\ufeffSome notes\r\n" +
+	"- Your Highlight on Location 10-11 | Added on Tuesday, March 7, 2023 9:05:00 AM\r\n" +
+	"\r\n" +
+	"A highlight without an author\r\n" +
+	"==========\r\n"
+
+func TestParseKindleClippings(t *testing.T) {
+	quotes, err := ParseKindleClippings([]byte(testKindleClippings))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(quotes))
+
+	animalFarm := quotes[0]
+	assert.Equal(t, "All animals are equal, but some animals are more equal than others.", animalFarm.Text)
+	assert.Equal(t, "Animal Farm", animalFarm.MainSource)
+	assert.Equal(t, 1, len(animalFarm.Sources))
+	assert.Equal(t, db.SourceKindBook, animalFarm.Sources[0].Kind)
+	assert.JSONEq(t, `{"author": "George Orwell"}`, string(animalFarm.Sources[0].Data.Bytes))
+
+	pragmatic := quotes[1]
+	assert.Equal(t, "Don't live with broken windows.", pragmatic.Text)
+	assert.Equal(t, "The Pragmatic Programmer", pragmatic.MainSource)
+	assert.JSONEq(t, `{"author": "Hunt, Andrew;Thomas, David"}`, string(pragmatic.Sources[0].Data.Bytes))
+
+	noAuthor := quotes[2]
+	assert.Equal(t, "Some notes", noAuthor.MainSource)
+	assert.Equal(t, pgtype.Null, noAuthor.Sources[0].Data.Status)
+}
+
+const testKindleClippingsGerman = "\ufeffFarm der Tiere (George Orwell)\r\n" +
+	"- Ihre Markierung auf Seite 12 | Position 180-181 | Hinzugefügt am Montag, 6. März 2023 22:11:12\r\n" +
+	"\r\n" +
+	"Alle Tiere sind gleich\r\n" +
+	"==========\r\n" +
+	"\ufeffFarm der Tiere (George Orwell)\r\n" +
+	"- Ihre Notiz auf Seite 12 | Position 182 | Hinzugefügt am Montag, 6. März 2023 22:12:00\r\n" +
+	"\r\n" +
+	"die Hauptidee des Buches\r\n" +
+	"==========\r\n" +
+	"\ufeffFarm der Tiere (George Orwell)\r\n" +
+	"- Ihr Lesezeichen auf Seite 40 | Position 610 | Hinzugefügt am Dienstag, 7. März 2023 09:00:00\r\n" +
+	"\r\n" +
+	"\r\n" +
+	"==========\r\n"
+
+func TestParseKindleClippingsGerman(t *testing.T) {
+	quotes, err := ParseKindleClippings([]byte(testKindleClippingsGerman))
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(quotes)) {
+		assert.Equal(t, "Alle Tiere sind gleich", quotes[0].Text)
+		assert.Equal(t, "Farm der Tiere", quotes[0].MainSource)
+		assert.JSONEq(t, `{"author": "George Orwell"}`, string(quotes[0].Sources[0].Data.Bytes))
+	}
+}
+
+func TestParseKindleClippingsMalformed(t *testing.T) {
+	_, err := ParseKindleClippings([]byte("just some text"))
+	assert.ErrorIs(t, err, ErrMalformedImportFile)
+
+	_, err = ParseKindleClippings([]byte("Animal Farm (George Orwell)\n==========\n"))
+	assert.ErrorIs(t, err, ErrMalformedImportFile)
+}
+
+func TestParseLibraryImportKindle(t *testing.T) {
+	assert.Equal(t, IMPORT_FORMAT_KINDLE, ImportFormat("My Clippings.txt"))
+
+	quotes, err := ParseLibraryImport([]byte(strings.ReplaceAll(testKindleClippings, "\r\n", "\n")), IMPORT_FORMAT_KINDLE)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(quotes))
+}
@@ -25,6 +25,22 @@ func NewSourceDeactiverLogger(dev bool, logPath string) (zerolog.Logger, error)
 	return zerolog.New(output).With().Timestamp().Str("part", "bot").Logger(), nil
 }
 
+func NewDigestSenderLogger(dev bool, logPath string) (zerolog.Logger, error) {
+	output, err := getLoggerOutput(dev, logPath)
+	if err != nil {
+		return zerolog.New(os.Stderr).With().Timestamp().Str("part", "digest").Logger(), err
+	}
+	return zerolog.New(output).With().Timestamp().Str("part", "digest").Logger(), nil
+}
+
+func NewReviewReminderLogger(dev bool, logPath string) (zerolog.Logger, error) {
+	output, err := getLoggerOutput(dev, logPath)
+	if err != nil {
+		return zerolog.New(os.Stderr).With().Timestamp().Str("part", "review").Logger(), err
+	}
+	return zerolog.New(output).With().Timestamp().Str("part", "review").Logger(), nil
+}
+
 func getLoggerOutput(dev bool, logPath string) (io.Writer, error) {
 	if dev {
 		return zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}, nil
@@ -1,36 +1,127 @@
 package utils
 
 import (
+	"encoding/json"
 	"errors"
+	"strconv"
 	"strings"
+	"unicode"
+
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/go-telegram/bot/models"
+	"github.com/jackc/pgtype"
 )
 
+// QUOTE_TEXT_BLOCK_DELIMITER is written on lines before and after the text of a quote, so the
+// text can have more than one line.
+const QUOTE_TEXT_BLOCK_DELIMITER = `"""`
+
+const QUOTE_SYNTAX_SOURCES = "sources"
+const QUOTE_SYNTAX_TAG = "tag"
+const QUOTE_SYNTAX_PAGE = "page"
+const QUOTE_SYNTAX_NOTE = "note"
+
+// QUOTE_SYNTAX_RESET changes all keywords back to the default ones
+const QUOTE_SYNTAX_RESET = "reset"
+
+// QUOTE_SYNTAX_KEYWORDS are names of the keywords of the syntax, which users change them with
+var QUOTE_SYNTAX_KEYWORDS = []string{QUOTE_SYNTAX_SOURCES, QUOTE_SYNTAX_TAG, QUOTE_SYNTAX_PAGE, QUOTE_SYNTAX_NOTE}
+
+var DEFAULT_QUOTE_SYNTAX = db.QuoteSyntax{
+	SourcesPrefix: "sources:",
+	TagMarker:     "#",
+	PagePrefix:    "page:",
+	NotePrefix:    "note:",
+}
+
+var ErrQuoteHasNoText = errors.New("quote has no text")
+var ErrQuoteTextBlockNotClosed = errors.New("quote text block is not closed")
+var ErrUnknownQuoteSyntaxKeyword = errors.New("unknown quote syntax keyword")
+var ErrInvalidQuoteSyntaxKeyword = errors.New("invalid quote syntax keyword")
+
 type Quote struct {
 	Text       string
 	MainSource string
 	Sources    []string
 	Tags       []string
+	OriginURL  string
+	// Entities are the formatting of Text
+	Entities []db.TextEntity
+	// Location is where the quote is in its main source, like a page
+	Location string
+	Note     string
 }
 
 func ParseQuote(text string) (*Quote, error) {
+	return ParseQuoteWithEntities(text, nil)
+}
+
+// ParseQuoteWithEntities parses the quote like ParseQuote and keeps the entities of text which format the text of the quote
+func ParseQuoteWithEntities(text string, entities []db.TextEntity) (*Quote, error) {
+	return ParseQuoteWithSyntax(text, entities, &DEFAULT_QUOTE_SYNTAX)
+}
+
+// ParseQuoteWithSyntax parses the quote like ParseQuoteWithEntities, with keywords of syntax instead of the default ones
+func ParseQuoteWithSyntax(text string, entities []db.TextEntity, syntax *db.QuoteSyntax) (*Quote, error) {
 	lines := strings.Split(text, "\n")
 	q := Quote{}
 
 	sourcesMap := map[string]bool{}
 	tagsMap := map[string]bool{}
+	notes := []string{}
+	inTextBlock := false
+	textBlockStart := 0
+	lineStart := 0
 	for _, line := range lines {
+		rawLine := line
+		rawLineStart := lineStart
+		lineStart += len(line) + 1
+
 		line = strings.TrimSpace(line)
+		if inTextBlock {
+			if line == QUOTE_TEXT_BLOCK_DELIMITER {
+				inTextBlock = false
+				block := text[textBlockStart:rawLineStart]
+				q.Text = strings.TrimSpace(block)
+				if q.Text == "" {
+					return nil, ErrQuoteHasNoText
+				}
+				textStart := textBlockStart + strings.Index(block, q.Text)
+				q.Entities = db.SliceTextEntities(text, entities, textStart, textStart+len(q.Text))
+			}
+			continue
+		}
+
 		if line == "" {
 			continue
 		}
 
 		if q.Text == "" {
+			if line == QUOTE_TEXT_BLOCK_DELIMITER {
+				inTextBlock = true
+				textBlockStart = lineStart
+				continue
+			}
 			q.Text = line
+			textStart := rawLineStart + strings.Index(rawLine, line)
+			q.Entities = db.SliceTextEntities(text, entities, textStart, textStart+len(line))
 			continue
 		}
 
-		if strings.HasPrefix(line, "sources:") {
-			sources := strings.Split(strings.TrimPrefix(line, "sources:"), ",")
+		if strings.HasPrefix(line, syntax.PagePrefix) {
+			q.Location = strings.TrimSpace(strings.TrimPrefix(line, syntax.PagePrefix))
+			continue
+		}
+
+		if strings.HasPrefix(line, syntax.NotePrefix) {
+			if note := strings.TrimSpace(strings.TrimPrefix(line, syntax.NotePrefix)); note != "" {
+				notes = append(notes, note)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, syntax.SourcesPrefix) {
+			sources := strings.Split(strings.TrimPrefix(line, syntax.SourcesPrefix), ",")
 			for _, sourceRaw := range sources {
 				source := strings.TrimSpace(sourceRaw)
 				if q.MainSource == "" {
@@ -42,14 +133,18 @@ func ParseQuote(text string) (*Quote, error) {
 
 		words := strings.Fields(line)
 		for _, word := range words {
-			if strings.HasPrefix(word, "#") {
-				tagsMap[word[1:]] = true
+			if tag := strings.TrimPrefix(word, syntax.TagMarker); tag != word && tag != "" {
+				tagsMap[tag] = true
 			}
 		}
 	}
 
+	if inTextBlock {
+		return nil, ErrQuoteTextBlockNotClosed
+	}
+
 	if q.Text == "" {
-		return nil, errors.New("quote has no text")
+		return nil, ErrQuoteHasNoText
 	}
 
 	for source := range sourcesMap {
@@ -60,5 +155,159 @@ func ParseQuote(text string) (*Quote, error) {
 		q.Tags = append(q.Tags, tag)
 	}
 
+	q.Note = strings.Join(notes, "\n")
+
 	return &q, nil
 }
+
+// ParseDraftSources returns the sources separated by commas in text, keeping their order
+func ParseDraftSources(text string) []string {
+	sources := []string{}
+	for _, sourceRaw := range strings.Split(text, ",") {
+		source := strings.TrimSpace(sourceRaw)
+		if source != "" && !includesString(sources, source) {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// ParseDraftTags returns the tags separated by commas or spaces in text, with or without the tag marker
+func ParseDraftTags(text string, syntax *db.QuoteSyntax) []string {
+	tags := []string{}
+	words := strings.FieldsFunc(text, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	for _, word := range words {
+		tag := strings.TrimPrefix(word, syntax.TagMarker)
+		if tag != "" && !includesString(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func includesString(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
+
+// DraftFromQuote returns the quote as a draft which is made from the message with messageID
+func DraftFromQuote(q *Quote, messageID int) *db.QuoteDraft {
+	return &db.QuoteDraft{
+		Text:         q.Text,
+		MainSource:   q.MainSource,
+		Sources:      q.Sources,
+		Tags:         q.Tags,
+		OriginURL:    q.OriginURL,
+		TextEntities: q.Entities,
+		Location:     q.Location,
+		Note:         q.Note,
+		MessageID:    messageID,
+	}
+}
+
+func QuoteFromDraft(draft *db.QuoteDraft) *Quote {
+	return &Quote{
+		Text:       draft.Text,
+		MainSource: draft.MainSource,
+		Sources:    draft.Sources,
+		Tags:       draft.Tags,
+		OriginURL:  draft.OriginURL,
+		Entities:   draft.TextEntities,
+		Location:   draft.Location,
+		Note:       draft.Note,
+	}
+}
+
+// ParseQuoteSyntax returns the syntax of a user, with the default keywords for the keywords which are not set
+func ParseQuoteSyntax(syntax pgtype.JSON) (db.QuoteSyntax, error) {
+	data := db.QuoteSyntax{}
+	if syntax.Status == pgtype.Present {
+		if err := json.Unmarshal(syntax.Bytes, &data); err != nil {
+			return DEFAULT_QUOTE_SYNTAX, err
+		}
+	}
+
+	if data.SourcesPrefix == "" {
+		data.SourcesPrefix = DEFAULT_QUOTE_SYNTAX.SourcesPrefix
+	}
+	if data.TagMarker == "" {
+		data.TagMarker = DEFAULT_QUOTE_SYNTAX.TagMarker
+	}
+	if data.PagePrefix == "" {
+		data.PagePrefix = DEFAULT_QUOTE_SYNTAX.PagePrefix
+	}
+	if data.NotePrefix == "" {
+		data.NotePrefix = DEFAULT_QUOTE_SYNTAX.NotePrefix
+	}
+	return data, nil
+}
+
+// SetQuoteSyntaxKeyword changes one of the keywords of syntax. Prefixes of lines should be different
+// from each other and the tag marker can not have spaces, since tags are words of the lines.
+func SetQuoteSyntaxKeyword(syntax *db.QuoteSyntax, keyword string, value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" || value == QUOTE_TEXT_BLOCK_DELIMITER || strings.Contains(value, "\n") {
+		return ErrInvalidQuoteSyntaxKeyword
+	}
+
+	res := *syntax
+	switch keyword {
+	case QUOTE_SYNTAX_SOURCES:
+		res.SourcesPrefix = value
+	case QUOTE_SYNTAX_TAG:
+		if len(strings.Fields(value)) != 1 {
+			return ErrInvalidQuoteSyntaxKeyword
+		}
+		res.TagMarker = value
+	case QUOTE_SYNTAX_PAGE:
+		res.PagePrefix = value
+	case QUOTE_SYNTAX_NOTE:
+		res.NotePrefix = value
+	default:
+		return ErrUnknownQuoteSyntaxKeyword
+	}
+
+	// a prefix which starts with another one is never matched
+	prefixes := []string{res.SourcesPrefix, res.PagePrefix, res.NotePrefix}
+	for i, prefix := range prefixes {
+		for j, other := range prefixes {
+			if i != j && strings.HasPrefix(prefix, other) {
+				return ErrInvalidQuoteSyntaxKeyword
+			}
+		}
+	}
+
+	*syntax = res
+	return nil
+}
+
+// ForwardOrigin returns the name of the chat or the user a message is forwarded from and a link
+// to the original message, which is only available for messages forwarded from channels.
+func ForwardOrigin(message *models.Message) (string, string) {
+	switch {
+	case message.ForwardFromChat != nil:
+		chat := message.ForwardFromChat
+		if message.ForwardFromMessageID == 0 {
+			return chat.Title, ""
+		}
+
+		messageID := strconv.Itoa(message.ForwardFromMessageID)
+		if chat.Username != "" {
+			return chat.Title, "https://t.me/" + chat.Username + "/" + messageID
+		}
+		// links of private chats use their ID without the -100 prefix
+		if chatID := strconv.FormatInt(chat.ID, 10); strings.HasPrefix(chatID, "-100") {
+			return chat.Title, "https://t.me/c/" + strings.TrimPrefix(chatID, "-100") + "/" + messageID
+		}
+		return chat.Title, ""
+	case message.ForwardFrom != nil:
+		return strings.TrimSpace(message.ForwardFrom.FirstName + " " + message.ForwardFrom.LastName), ""
+	default:
+		// users who hide their account in forwarded messages only have a name
+		return message.ForwardSenderName, ""
+	}
+}
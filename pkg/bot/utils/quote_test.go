@@ -3,6 +3,9 @@ package utils
 import (
 	"testing"
 
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/go-telegram/bot/models"
+	"github.com/jackc/pgtype"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -44,7 +47,33 @@ func TestParseQuote(t *testing.T) {
 		MakesErr: false,
 	}
 
-	testCases := []parseQuoteTestCase{normalTestCase, withoutTextTestCase, withoutSourceTestCase}
+	textBlockTestCase := parseQuoteTestCase{
+		Name: "textBlock",
+		Text: "\"\"\"\nYou have power over your mind - not outside events.\n\nRealize this, and you will find strength.\n\"\"\"\nsources: Meditations\npage: 42\nnote: read it again #later\nnote: compare with Epictetus\n#stoicism",
+		Quote: &Quote{
+			Text:       "You have power over your mind - not outside events.\n\nRealize this, and you will find strength.",
+			MainSource: "Meditations",
+			Sources:    []string{"Meditations"},
+			Tags:       []string{"stoicism"},
+			Location:   "42",
+			Note:       "read it again #later\ncompare with Epictetus",
+		},
+		MakesErr: false,
+	}
+
+	textBlockNotClosedTestCase := parseQuoteTestCase{
+		Name:     "textBlockNotClosed",
+		Text:     "\"\"\"\nYou have power over your mind - not outside events.\nsources: Meditations",
+		MakesErr: true,
+	}
+
+	emptyTextBlockTestCase := parseQuoteTestCase{
+		Name:     "emptyTextBlock",
+		Text:     "\"\"\"\n \n\"\"\"\nsources: Meditations",
+		MakesErr: true,
+	}
+
+	testCases := []parseQuoteTestCase{normalTestCase, withoutTextTestCase, withoutSourceTestCase, textBlockTestCase, textBlockNotClosedTestCase, emptyTextBlockTestCase}
 
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
@@ -59,7 +88,138 @@ func TestParseQuote(t *testing.T) {
 			assert.Equal(t, q.MainSource, tc.Quote.MainSource)
 			assert.ElementsMatch(t, q.Sources, tc.Quote.Sources)
 			assert.ElementsMatch(t, q.Tags, tc.Quote.Tags)
+			assert.Equal(t, q.Location, tc.Quote.Location)
+			assert.Equal(t, q.Note, tc.Quote.Note)
 		})
 	}
 
 }
+
+func TestForwardOrigin(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Message   models.Message
+		Origin    string
+		OriginURL string
+	}{
+		{Name: "notForwarded", Message: models.Message{Text: "quote"}},
+		{Name: "publicChannel", Message: models.Message{ForwardFromChat: &models.Chat{ID: -1001234567890, Title: "Stoic Quotes", Username: "stoicquotes"}, ForwardFromMessageID: 42}, Origin: "Stoic Quotes", OriginURL: "https://t.me/stoicquotes/42"},
+		{Name: "privateChannel", Message: models.Message{ForwardFromChat: &models.Chat{ID: -1001234567890, Title: "Stoic Quotes"}, ForwardFromMessageID: 42}, Origin: "Stoic Quotes", OriginURL: "https://t.me/c/1234567890/42"},
+		{Name: "user", Message: models.Message{ForwardFrom: &models.User{FirstName: "Marcus", LastName: "Aurelius"}}, Origin: "Marcus Aurelius"},
+		{Name: "hiddenUser", Message: models.Message{ForwardSenderName: "Seneca"}, Origin: "Seneca"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			origin, originURL := ForwardOrigin(&tc.Message)
+			assert.Equal(t, tc.Origin, origin)
+			assert.Equal(t, tc.OriginURL, originURL)
+		})
+	}
+}
+
+func TestParseQuoteWithEntities(t *testing.T) {
+	// the quote text starts after the empty line and the spaces, "—" is one UTF-16 code unit and "🔥" is two
+	text := "\n  🔥 Waste no more time — be one.\nsources: Meditations\n#stoicism"
+	entities := []db.TextEntity{
+		{Type: "bold", Offset: 1, Length: 7},
+		{Type: "italic", Offset: 27, Length: 6},
+		{Type: "underline", Offset: 36, Length: 20},
+	}
+
+	q, err := ParseQuoteWithEntities(text, entities)
+	assert.Nil(t, err)
+	assert.Equal(t, "🔥 Waste no more time — be one.", q.Text)
+	assert.Equal(t, "*🔥 Wa*ste no more time — _be one_\\.", EntitiesToMarkdown(q.Text, q.Entities))
+	assert.Equal(t, []db.TextEntity{
+		{Type: "bold", Offset: 0, Length: 5},
+		{Type: "italic", Offset: 24, Length: 6},
+	}, q.Entities)
+}
+
+func TestParseQuoteWithEntitiesTextBlock(t *testing.T) {
+	text := "\"\"\"\n  Waste no more time.\n\nBe one.\n\"\"\"\nsources: Meditations"
+	entities := []db.TextEntity{
+		{Type: "bold", Offset: 6, Length: 5},
+		{Type: "italic", Offset: 27, Length: 7},
+		{Type: "underline", Offset: 39, Length: 8},
+	}
+
+	q, err := ParseQuoteWithEntities(text, entities)
+	assert.Nil(t, err)
+	assert.Equal(t, "Waste no more time.\n\nBe one.", q.Text)
+	assert.Equal(t, []db.TextEntity{
+		{Type: "bold", Offset: 0, Length: 5},
+		{Type: "italic", Offset: 21, Length: 7},
+	}, q.Entities)
+
+	_, err = ParseQuote("\"\"\"\nWaste no more time.")
+	assert.ErrorIs(t, err, ErrQuoteTextBlockNotClosed)
+}
+
+func TestParseQuoteWithSyntax(t *testing.T) {
+	syntax := db.QuoteSyntax{SourcesPrefix: "منابع:", TagMarker: "+", PagePrefix: "صفحه:", NotePrefix: "یادداشت:"}
+	text := "Waste no more time arguing what a good man should be. Be one.\nمنابع: Meditations, Marcus Aurelius\nصفحه: 42\nیادداشت: read it again\n+stoicism #notATag"
+
+	q, err := ParseQuoteWithSyntax(text, nil, &syntax)
+	assert.Nil(t, err)
+	assert.Equal(t, "Waste no more time arguing what a good man should be. Be one.", q.Text)
+	assert.Equal(t, "Meditations", q.MainSource)
+	assert.ElementsMatch(t, []string{"Meditations", "Marcus Aurelius"}, q.Sources)
+	assert.Equal(t, []string{"stoicism"}, q.Tags)
+	assert.Equal(t, "42", q.Location)
+	assert.Equal(t, "read it again", q.Note)
+}
+
+func TestParseQuoteSyntax(t *testing.T) {
+	syntax, err := ParseQuoteSyntax(pgtype.JSON{Status: pgtype.Null})
+	assert.Nil(t, err)
+	assert.Equal(t, DEFAULT_QUOTE_SYNTAX, syntax)
+
+	// keywords which are not set are the default ones
+	syntax, err = ParseQuoteSyntax(pgtype.JSON{Bytes: []byte(`{"sourcesPrefix": "منابع:"}`), Status: pgtype.Present})
+	assert.Nil(t, err)
+	assert.Equal(t, db.QuoteSyntax{SourcesPrefix: "منابع:", TagMarker: "#", PagePrefix: "page:", NotePrefix: "note:"}, syntax)
+}
+
+func TestParseDraftSources(t *testing.T) {
+	assert.Equal(t, []string{"The social animal", "Elliot Aronson"}, ParseDraftSources(" The social animal,Elliot Aronson, ,The social animal"))
+	assert.Equal(t, []string{}, ParseDraftSources(" , "))
+}
+
+func TestParseDraftTags(t *testing.T) {
+	syntax := db.QuoteSyntax{TagMarker: "+"}
+	assert.Equal(t, []string{"sociology", "psychology", "#stoicism"}, ParseDraftTags("+sociology, psychology\n#stoicism +sociology +", &syntax))
+}
+
+func TestSetQuoteSyntaxKeyword(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Keyword string
+		Value   string
+		Syntax  db.QuoteSyntax
+		Err     error
+	}{
+		{Name: "sources", Keyword: QUOTE_SYNTAX_SOURCES, Value: " منابع: ", Syntax: db.QuoteSyntax{SourcesPrefix: "منابع:", TagMarker: "#", PagePrefix: "page:", NotePrefix: "note:"}},
+		{Name: "tag", Keyword: QUOTE_SYNTAX_TAG, Value: "+", Syntax: db.QuoteSyntax{SourcesPrefix: "sources:", TagMarker: "+", PagePrefix: "page:", NotePrefix: "note:"}},
+		{Name: "tagWithSpace", Keyword: QUOTE_SYNTAX_TAG, Value: "+ +", Err: ErrInvalidQuoteSyntaxKeyword},
+		{Name: "empty", Keyword: QUOTE_SYNTAX_NOTE, Value: " ", Err: ErrInvalidQuoteSyntaxKeyword},
+		{Name: "textBlockDelimiter", Keyword: QUOTE_SYNTAX_NOTE, Value: QUOTE_TEXT_BLOCK_DELIMITER, Err: ErrInvalidQuoteSyntaxKeyword},
+		{Name: "startsWithOtherPrefix", Keyword: QUOTE_SYNTAX_PAGE, Value: "note:s", Err: ErrInvalidQuoteSyntaxKeyword},
+		{Name: "unknown", Keyword: "author", Value: "by:", Err: ErrUnknownQuoteSyntaxKeyword},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			syntax := DEFAULT_QUOTE_SYNTAX
+			err := SetQuoteSyntaxKeyword(&syntax, tc.Keyword, tc.Value)
+			if tc.Err != nil {
+				assert.ErrorIs(t, err, tc.Err)
+				assert.Equal(t, DEFAULT_QUOTE_SYNTAX, syntax)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.Syntax, syntax)
+		})
+	}
+}
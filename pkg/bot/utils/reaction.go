@@ -10,6 +10,7 @@ import (
 type Reaction struct {
 	Messages     []bot.SendMessageParams
 	EditMessages []bot.EditMessageTextParams
+	Documents    []bot.SendDocumentParams
 }
 
 func (r Reaction) Do(ctx context.Context, bot *bot.Bot) error {
@@ -31,6 +32,15 @@ func (r Reaction) Do(ctx context.Context, bot *bot.Bot) error {
 		}
 	}
 
+	if r.Documents != nil {
+		for _, document := range r.Documents {
+			_, err := bot.SendDocument(ctx, &document)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aigic8/warmlight/internal/db"
+	m "github.com/aigic8/warmlight/pkg/bot/models"
+	"github.com/go-telegram/bot/models"
+)
+
+const REVIEW_GRADE_FORGOT = "forgot"
+const REVIEW_GRADE_HARD = "hard"
+const REVIEW_GRADE_GOOD = "good"
+const REVIEW_GRADE_EASY = "easy"
+
+const MIN_REVIEW_EASE_FACTOR = 1.3
+
+var ErrInvalidReviewGrade = errors.New("invalid review grade")
+
+// reviewGradeQualities maps the grades users pick to the SM-2 quality of response (0-5).
+var reviewGradeQualities = map[string]int{
+	REVIEW_GRADE_FORGOT: 1,
+	REVIEW_GRADE_HARD:   3,
+	REVIEW_GRADE_GOOD:   4,
+	REVIEW_GRADE_EASY:   5,
+}
+
+// ScheduleReview calculates the next state of a review card using the SM-2
+// algorithm. Forgotten cards start over from a one day interval.
+func ScheduleReview(card *db.ReviewCard, grade string, now time.Time) (db.ReviewSchedule, error) {
+	quality, ok := reviewGradeQualities[grade]
+	if !ok {
+		return db.ReviewSchedule{}, ErrInvalidReviewGrade
+	}
+
+	schedule := db.ReviewSchedule{Repetitions: card.Repetitions, EaseFactor: card.EaseFactor}
+	if quality < 3 {
+		schedule.Repetitions = 0
+		schedule.IntervalDays = 1
+	} else {
+		switch card.Repetitions {
+		case 0:
+			schedule.IntervalDays = 1
+		case 1:
+			schedule.IntervalDays = 6
+		default:
+			schedule.IntervalDays = int32(math.Round(float64(card.IntervalDays) * card.EaseFactor))
+		}
+		schedule.Repetitions++
+	}
+
+	diff := float64(5 - quality)
+	schedule.EaseFactor = math.Max(MIN_REVIEW_EASE_FACTOR, card.EaseFactor+0.1-diff*(0.08+diff*0.02))
+	schedule.DueAt = now.AddDate(0, 0, int(schedule.IntervalDays))
+	return schedule, nil
+}
+
+func ReviewReplyMarkup(quoteID int64) models.InlineKeyboardMarkup {
+	grades := []struct{ grade, label string }{
+		{REVIEW_GRADE_FORGOT, "Forgot"},
+		{REVIEW_GRADE_HARD, "Hard"},
+		{REVIEW_GRADE_GOOD, "Good"},
+		{REVIEW_GRADE_EASY, "Easy"},
+	}
+
+	buttons := []models.InlineKeyboardButton{}
+	for _, g := range grades {
+		callbackData := m.CallbackData{
+			Action: m.CALLBACK_COMMAND_REVIEW_QUOTE,
+			Data:   strconv.FormatInt(quoteID, 10) + "_" + g.grade,
+		}
+		buttons = append(buttons, models.InlineKeyboardButton{Text: g.label, CallbackData: callbackData.Marshal()})
+	}
+
+	return models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{buttons}}
+}
+
+// ParseReviewCallbackData parses data of callbacks created by ReviewReplyMarkup.
+func ParseReviewCallbackData(data string) (int64, string, error) {
+	quoteIDStr, grade, found := strings.Cut(data, "_")
+	if !found {
+		return 0, "", m.ErrMalformedCallbackString
+	}
+
+	quoteID, err := strconv.ParseInt(quoteIDStr, 10, 0)
+	if err != nil {
+		return 0, "", m.ErrMalformedCallbackString
+	}
+
+	if _, ok := reviewGradeQualities[grade]; !ok {
+		return 0, "", ErrInvalidReviewGrade
+	}
+
+	return quoteID, grade, nil
+}
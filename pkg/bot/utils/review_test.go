@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aigic8/warmlight/internal/db"
+	m "github.com/aigic8/warmlight/pkg/bot/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleReview(t *testing.T) {
+	now := time.Date(2023, 6, 10, 8, 0, 0, 0, time.UTC)
+	card := db.ReviewCard{EaseFactor: db.DEFAULT_REVIEW_EASE_FACTOR}
+
+	schedule, err := ScheduleReview(&card, REVIEW_GRADE_GOOD, now)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), schedule.Repetitions)
+	assert.Equal(t, int32(1), schedule.IntervalDays)
+	assert.InDelta(t, 2.5, schedule.EaseFactor, 0.0001)
+	assert.Equal(t, now.AddDate(0, 0, 1), schedule.DueAt)
+
+	card = db.ReviewCard{Repetitions: schedule.Repetitions, IntervalDays: schedule.IntervalDays, EaseFactor: schedule.EaseFactor}
+	schedule, err = ScheduleReview(&card, REVIEW_GRADE_EASY, now)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), schedule.Repetitions)
+	assert.Equal(t, int32(6), schedule.IntervalDays)
+	assert.InDelta(t, 2.6, schedule.EaseFactor, 0.0001)
+
+	card = db.ReviewCard{Repetitions: schedule.Repetitions, IntervalDays: schedule.IntervalDays, EaseFactor: schedule.EaseFactor}
+	schedule, err = ScheduleReview(&card, REVIEW_GRADE_HARD, now)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), schedule.Repetitions)
+	assert.Equal(t, int32(16), schedule.IntervalDays)
+	assert.InDelta(t, 2.46, schedule.EaseFactor, 0.0001)
+
+	card = db.ReviewCard{Repetitions: schedule.Repetitions, IntervalDays: schedule.IntervalDays, EaseFactor: 1.35}
+	schedule, err = ScheduleReview(&card, REVIEW_GRADE_FORGOT, now)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), schedule.Repetitions)
+	assert.Equal(t, int32(1), schedule.IntervalDays)
+	assert.Equal(t, MIN_REVIEW_EASE_FACTOR, schedule.EaseFactor)
+
+	_, err = ScheduleReview(&card, "perfect", now)
+	assert.ErrorIs(t, err, ErrInvalidReviewGrade)
+}
+
+func TestReviewReplyMarkup(t *testing.T) {
+	markup := ReviewReplyMarkup(42)
+	assert.Equal(t, 1, len(markup.InlineKeyboard))
+	assert.Equal(t, 4, len(markup.InlineKeyboard[0]))
+
+	for _, button := range markup.InlineKeyboard[0] {
+		callbackData, err := m.UnmarshalCallbackData(button.CallbackData)
+		assert.Nil(t, err)
+		assert.Equal(t, m.CALLBACK_COMMAND_REVIEW_QUOTE, callbackData.Action)
+
+		quoteID, _, err := ParseReviewCallbackData(callbackData.Data)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(42), quoteID)
+	}
+
+	_, _, err := ParseReviewCallbackData("42_perfect")
+	assert.ErrorIs(t, err, ErrInvalidReviewGrade)
+
+	_, _, err = ParseReviewCallbackData("42")
+	assert.ErrorIs(t, err, m.ErrMalformedCallbackString)
+}
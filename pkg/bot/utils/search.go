@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/aigic8/warmlight/internal/db"
+	m "github.com/aigic8/warmlight/pkg/bot/models"
+	"github.com/go-telegram/bot/models"
+)
+
+func MakeSearchResultKeyboardCallbacks(quoteID int64) (string, string, string) {
+	quoteIDStr := strconv.FormatInt(quoteID, 10)
+	viewCallbackData := m.CallbackData{Action: m.CALLBACK_COMMAND_QUOTE_VIEW, Data: quoteIDStr}
+	editCallbackData, deleteCallbackData := MakeQuoteKeyboardCallbacks(quoteID)
+	return viewCallbackData.Marshal(), editCallbackData, deleteCallbackData
+}
+
+// MakeSearchPageCallback creates the callback of the search page starting from offset.
+// The search itself is not kept in the callback, since it can be longer than the
+// limit of callback data, it is read from the message the results are replied to.
+func MakeSearchPageCallback(offset int32) string {
+	callbackData := m.CallbackData{
+		ReplaceMessageWith: m.CALLBACK_MSG_SEARCH_PAGE,
+		Data:               strconv.FormatInt(int64(offset), 10),
+	}
+	return callbackData.Marshal()
+}
+
+// SearchResultsReplyMarkup creates the keyboard of a page of search results. offset is
+// the number of results before the page and pageLimit is the number of results of each page.
+func SearchResultsReplyMarkup(quotes []db.QuoteWithData, offset int32, pageLimit int32, lastPage bool) models.InlineKeyboardMarkup {
+	inlineKeyboard := [][]models.InlineKeyboardButton{}
+
+	for i, quote := range quotes {
+		numStr := strconv.Itoa(int(offset) + i + 1)
+		viewCallbackData, editCallbackData, deleteCallbackData := MakeSearchResultKeyboardCallbacks(quote.ID)
+		inlineKeyboard = append(inlineKeyboard, []models.InlineKeyboardButton{
+			{Text: numStr + ". View", CallbackData: viewCallbackData},
+			{Text: numStr + ". Edit", CallbackData: editCallbackData},
+			{Text: numStr + ". Delete", CallbackData: deleteCallbackData},
+		})
+	}
+
+	pagesRow := []models.InlineKeyboardButton{}
+	if offset > 0 {
+		prevOffset := offset - pageLimit
+		if prevOffset < 0 {
+			prevOffset = 0
+		}
+		pagesRow = append(pagesRow, models.InlineKeyboardButton{Text: "⬅️", CallbackData: MakeSearchPageCallback(prevOffset)})
+	}
+	if !lastPage {
+		pagesRow = append(pagesRow, models.InlineKeyboardButton{Text: "➡️", CallbackData: MakeSearchPageCallback(offset + int32(len(quotes)))})
+	}
+	if len(pagesRow) != 0 {
+		inlineKeyboard = append(inlineKeyboard, pagesRow)
+	}
+
+	return models.InlineKeyboardMarkup{InlineKeyboard: inlineKeyboard}
+}
+
+// QuoteActionsReplyMarkup is the keyboard of quotes viewed from search results. It
+// starts with the buttons of QuoteReplyMarkup, so the quote can be found by QuoteIDFromReplyMarkup.
+func QuoteActionsReplyMarkup(quoteID int64) models.InlineKeyboardMarkup {
+	quoteIDStr := strconv.FormatInt(quoteID, 10)
+	forwardCallbackData := m.CallbackData{Action: m.CALLBACK_COMMAND_QUOTE_FORWARD, Data: quoteIDStr}
+	copyCallbackData := m.CallbackData{Action: m.CALLBACK_COMMAND_QUOTE_COPY, Data: quoteIDStr}
+
+	markup := QuoteReplyMarkup(quoteID)
+	markup.InlineKeyboard = append(markup.InlineKeyboard, []models.InlineKeyboardButton{
+		{Text: "Forward to output", CallbackData: forwardCallbackData.Marshal()},
+		{Text: "Copy", CallbackData: copyCallbackData.Marshal()},
+	})
+	return markup
+}
+
+func ForwardQuoteReplyMarkup(quoteID int64, outputs []db.Output) models.InlineKeyboardMarkup {
+	inlineKeyboard := [][]models.InlineKeyboardButton{}
+	for _, output := range outputs {
+		callbackData := m.CallbackData{
+			Action: m.CALLBACK_COMMAND_QUOTE_SEND_TO_OUTPUT,
+			Data:   strconv.FormatInt(quoteID, 10) + "_" + strconv.FormatInt(output.ChatID, 10),
+		}
+		inlineKeyboard = append(inlineKeyboard, []models.InlineKeyboardButton{{Text: output.Title, CallbackData: callbackData.Marshal()}})
+	}
+
+	return models.InlineKeyboardMarkup{InlineKeyboard: inlineKeyboard}
+}
+
+// ParseSendToOutputCallbackData parses data of the callbacks created by ForwardQuoteReplyMarkup
+// in the form of '<quoteID>_<outputChatID>'.
+func ParseSendToOutputCallbackData(data string) (int64, int64, error) {
+	quoteIDStr, outputChatIDStr, found := strings.Cut(data, "_")
+	if !found {
+		return 0, 0, m.ErrMalformedCallbackString
+	}
+
+	quoteID, err := strconv.ParseInt(quoteIDStr, 10, 0)
+	if err != nil {
+		return 0, 0, m.ErrMalformedCallbackString
+	}
+	outputChatID, err := strconv.ParseInt(outputChatIDStr, 10, 0)
+	if err != nil {
+		return 0, 0, m.ErrMalformedCallbackString
+	}
+
+	return quoteID, outputChatID, nil
+}
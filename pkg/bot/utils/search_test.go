@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/aigic8/warmlight/internal/db"
+	m "github.com/aigic8/warmlight/pkg/bot/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchResultsReplyMarkup(t *testing.T) {
+	quotes := []db.QuoteWithData{{ID: 1}, {ID: 4}}
+
+	markup := SearchResultsReplyMarkup(quotes, 0, 2, true)
+	assert.Equal(t, 2, len(markup.InlineKeyboard))
+	assert.Equal(t, "1. View", markup.InlineKeyboard[0][0].Text)
+
+	markup = SearchResultsReplyMarkup(quotes, 3, 2, false)
+	assert.Equal(t, 3, len(markup.InlineKeyboard))
+	assert.Equal(t, "4. View", markup.InlineKeyboard[0][0].Text)
+	assert.Equal(t, MakeSearchPageCallback(1), markup.InlineKeyboard[2][0].CallbackData)
+	assert.Equal(t, MakeSearchPageCallback(5), markup.InlineKeyboard[2][1].CallbackData)
+}
+
+func TestParseSendToOutputCallbackData(t *testing.T) {
+	markup := ForwardQuoteReplyMarkup(12, []db.Output{{ChatID: -1001234, Title: "quotes"}})
+	callbackData, err := m.UnmarshalCallbackData(markup.InlineKeyboard[0][0].CallbackData)
+	assert.Nil(t, err)
+
+	quoteID, outputChatID, err := ParseSendToOutputCallbackData(callbackData.Data)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), quoteID)
+	assert.Equal(t, int64(-1001234), outputChatID)
+
+	_, _, err = ParseSendToOutputCallbackData("12")
+	assert.ErrorIs(t, err, m.ErrMalformedCallbackString)
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/aigic8/warmlight/internal/db"
+	m "github.com/aigic8/warmlight/pkg/bot/models"
+	"github.com/go-telegram/bot/models"
+)
+
+// ParseTagName validates the name of a tag users send, the leading '#' is optional.
+// Tags are single words, since they are parsed from words of quotes starting with '#'.
+func ParseTagName(text string) (string, bool) {
+	name := strings.TrimPrefix(strings.TrimSpace(text), "#")
+	if name == "" || strings.ContainsAny(name, " \t\n#") {
+		return "", false
+	}
+	return name, true
+}
+
+func MakeTagKeyboardCallbacks(tagID int64) (string, string, string) {
+	tagIDStr := strconv.FormatInt(tagID, 10)
+	renameCallbackData := m.CallbackData{Action: m.CALLBACK_COMMAND_TAG_RENAME, Data: tagIDStr}
+	mergeCallbackData := m.CallbackData{Action: m.CALLBACK_COMMAND_TAG_MERGE, Data: tagIDStr}
+	deleteCallbackData := m.CallbackData{Action: m.CALLBACK_COMMAND_TAG_DELETE, Data: tagIDStr}
+	return renameCallbackData.Marshal(), mergeCallbackData.Marshal(), deleteCallbackData.Marshal()
+}
+
+func MakeTagKeyboardPagesCallbacks(firstTagID, lastTagID int64) (string, string) {
+	prevPageCallbackData := m.CallbackData{
+		Data:               strconv.FormatInt(firstTagID, 10),
+		ReplaceMessageWith: m.CALLBACK_MSG_PREV_TAG_PAGE,
+	}
+
+	nextPageCallbackData := m.CallbackData{
+		Data:               strconv.FormatInt(lastTagID, 10),
+		ReplaceMessageWith: m.CALLBACK_MSG_NEXT_TAG_PAGE,
+	}
+
+	return prevPageCallbackData.Marshal(), nextPageCallbackData.Marshal()
+}
+
+func TagsReplyMarkup(tags []db.TagWithCount, firstPage, lastPage bool) models.InlineKeyboardMarkup {
+	inlineKeyboard := [][]models.InlineKeyboardButton{}
+
+	if len(tags) == 0 {
+		return models.InlineKeyboardMarkup{InlineKeyboard: inlineKeyboard}
+	}
+
+	for i, tag := range tags {
+		numStr := strconv.Itoa(i + 1)
+		renameCallbackData, mergeCallbackData, deleteCallbackData := MakeTagKeyboardCallbacks(tag.ID)
+		inlineKeyboard = append(inlineKeyboard, []models.InlineKeyboardButton{
+			{Text: numStr + ". Rename", CallbackData: renameCallbackData},
+			{Text: numStr + ". Merge", CallbackData: mergeCallbackData},
+			{Text: numStr + ". Delete", CallbackData: deleteCallbackData},
+		})
+	}
+
+	prevPageCallbackData, nextPageCallbackData := MakeTagKeyboardPagesCallbacks(tags[0].ID, tags[len(tags)-1].ID)
+	pagesRow := []models.InlineKeyboardButton{}
+	if !firstPage {
+		pagesRow = append(pagesRow, models.InlineKeyboardButton{Text: "⬅️", CallbackData: prevPageCallbackData})
+	}
+	if !lastPage {
+		pagesRow = append(pagesRow, models.InlineKeyboardButton{Text: "➡️", CallbackData: nextPageCallbackData})
+	}
+	if len(pagesRow) != 0 {
+		inlineKeyboard = append(inlineKeyboard, pagesRow)
+	}
+
+	return models.InlineKeyboardMarkup{InlineKeyboard: inlineKeyboard}
+}
+
+func ConfirmDeleteTagReplyMarkup(tagID int64) models.InlineKeyboardMarkup {
+	confirmCallbackData := m.CallbackData{
+		Action: m.CALLBACK_COMMAND_TAG_CONFIRM_DELETE,
+		Data:   strconv.FormatInt(tagID, 10),
+	}
+
+	return models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{{Text: "Yes, delete it", CallbackData: confirmCallbackData.Marshal()}},
+		},
+	}
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/aigic8/warmlight/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTagName(t *testing.T) {
+	name, ok := ParseTagName(" #programming ")
+	assert.True(t, ok)
+	assert.Equal(t, "programming", name)
+
+	name, ok = ParseTagName("golang")
+	assert.True(t, ok)
+	assert.Equal(t, "golang", name)
+
+	for _, text := range []string{"", "#", "two words", "#tag#other"} {
+		_, ok = ParseTagName(text)
+		assert.False(t, ok, text)
+	}
+}
+
+func TestTagsReplyMarkup(t *testing.T) {
+	tags := []db.TagWithCount{{ID: 1, Name: "politics", QuoteCount: 2}, {ID: 3, Name: "animals", QuoteCount: 1}}
+
+	markup := TagsReplyMarkup(tags, true, true)
+	assert.Equal(t, 2, len(markup.InlineKeyboard))
+	assert.Equal(t, 3, len(markup.InlineKeyboard[0]))
+
+	prevPageCallbackData, nextPageCallbackData := MakeTagKeyboardPagesCallbacks(1, 3)
+	markup = TagsReplyMarkup(tags, false, false)
+	assert.Equal(t, 3, len(markup.InlineKeyboard))
+	assert.Equal(t, prevPageCallbackData, markup.InlineKeyboard[2][0].CallbackData)
+	assert.Equal(t, nextPageCallbackData, markup.InlineKeyboard[2][1].CallbackData)
+
+	markup = TagsReplyMarkup([]db.TagWithCount{}, true, true)
+	assert.Equal(t, 0, len(markup.InlineKeyboard))
+}
@@ -72,7 +72,14 @@ func OutputsReplyMarkup(outputs []db.Output) (models.InlineKeyboardMarkup, error
 		if err != nil {
 			return models.InlineKeyboardMarkup{}, err
 		}
-		inlineKeyboard = append(inlineKeyboard, []models.InlineKeyboardButton{{Text: output.Title + " - " + outputState, CallbackData: callbackData}})
+		filtersCallbackData := m.CallbackData{
+			Action: m.CALLBACK_COMMAND_OUTPUT_FILTERS,
+			Data:   strconv.FormatInt(output.ChatID, 10),
+		}
+		inlineKeyboard = append(inlineKeyboard, []models.InlineKeyboardButton{
+			{Text: output.Title + " - " + outputState, CallbackData: callbackData},
+			{Text: "Filters", CallbackData: filtersCallbackData.Marshal()},
+		})
 	}
 
 	return models.InlineKeyboardMarkup{InlineKeyboard: inlineKeyboard}, nil
@@ -94,20 +101,235 @@ func MakeSourceKeyboardCallbacks(sourceID int64) (string, string) {
 	return infoCallbackData.Marshal(), editCallbackData.Marshal()
 }
 
-func MakeSourceKeyboardPagesCallbacks(firstSourceID, lastSourceID int64) (string, string) {
+func MakeSourceChangeKeyboardCallbacks(sourceID int64) (string, string) {
+	mergeCallbackData := m.CallbackData{
+		Action: m.CALLBACK_COMMAND_SOURCE_MERGE,
+		Data:   strconv.FormatInt(sourceID, 10),
+	}
+
+	deleteCallbackData := m.CallbackData{
+		Action: m.CALLBACK_COMMAND_SOURCE_DELETE,
+		Data:   strconv.FormatInt(sourceID, 10),
+	}
+
+	return mergeCallbackData.Marshal(), deleteCallbackData.Marshal()
+}
+
+// SOURCE_FILTER_TEXT_MAX_LEN is the longest name filter of /getsources in bytes. Filters
+// are kept in the callback data of page buttons, which telegram limits to 64 bytes.
+const SOURCE_FILTER_TEXT_MAX_LEN = 64 - len(m.CALLBACK_MSG_NEXT_SOURCE_PAGE+"--") - len("-9223372036854775808") - len("_"+base.SourceKindUnknown+"_")
+
+func MakeSourceKeyboardPagesCallbacks(firstSourceID, lastSourceID int64, filter m.SourceFilter) (string, string) {
+	filterData := "_" + filter.SourceKind + "_" + filter.Text
 	prevPageCallbackData := m.CallbackData{
-		Data:               strconv.FormatInt(firstSourceID, 10),
+		Data:               strconv.FormatInt(firstSourceID, 10) + filterData,
 		ReplaceMessageWith: m.CALLBACK_MSG_PREV_SOURCE_PAGE,
 	}
 
 	nextPageCallbackData := m.CallbackData{
-		Data:               strconv.FormatInt(lastSourceID, 10),
+		Data:               strconv.FormatInt(lastSourceID, 10) + filterData,
 		ReplaceMessageWith: m.CALLBACK_MSG_NEXT_SOURCE_PAGE,
 	}
 
 	return prevPageCallbackData.Marshal(), nextPageCallbackData.Marshal()
 }
 
+// ParseSourcePageCallbackData parses data of source page callbacks in the form of
+// '<baseID>_<kind>_<text>', which returns the base ID and the filter of the page.
+func ParseSourcePageCallbackData(data string) (int64, m.SourceFilter, error) {
+	parts := strings.SplitN(data, "_", 3)
+	if len(parts) != 3 {
+		return 0, m.SourceFilter{}, m.ErrMalformedCallbackString
+	}
+
+	baseID, err := strconv.ParseInt(parts[0], 10, 0)
+	if err != nil {
+		return 0, m.SourceFilter{}, m.ErrMalformedCallbackString
+	}
+
+	return baseID, m.SourceFilter{SourceKind: parts[1], Text: parts[2]}, nil
+}
+
+func MakeQuoteKeyboardCallbacks(quoteID int64) (string, string) {
+	editCallbackData := m.CallbackData{
+		Action: m.CALLBACK_COMMAND_QUOTE_EDIT,
+		Data:   strconv.FormatInt(quoteID, 10),
+	}
+
+	deleteCallbackData := m.CallbackData{
+		Action: m.CALLBACK_COMMAND_QUOTE_DELETE,
+		Data:   strconv.FormatInt(quoteID, 10),
+	}
+
+	return editCallbackData.Marshal(), deleteCallbackData.Marshal()
+}
+
+func QuoteReplyMarkup(quoteID int64) models.InlineKeyboardMarkup {
+	editCallbackData, deleteCallbackData := MakeQuoteKeyboardCallbacks(quoteID)
+	return models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{
+				{Text: "Edit", CallbackData: editCallbackData},
+				{Text: "Delete", CallbackData: deleteCallbackData},
+			},
+		},
+	}
+}
+
+// QuoteDraftReplyMarkup returns the buttons of the draft made from the message with messageID
+func QuoteDraftReplyMarkup(messageID int) models.InlineKeyboardMarkup {
+	button := func(text string, action string) models.InlineKeyboardButton {
+		callbackData := m.CallbackData{Action: action, Data: strconv.Itoa(messageID)}
+		return models.InlineKeyboardButton{Text: text, CallbackData: callbackData.Marshal()}
+	}
+
+	return models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{button("Save", m.CALLBACK_COMMAND_DRAFT_SAVE), button("Discard", m.CALLBACK_COMMAND_DRAFT_DISCARD)},
+			{button("Edit sources", m.CALLBACK_COMMAND_DRAFT_SOURCES), button("Edit tags", m.CALLBACK_COMMAND_DRAFT_TAGS)},
+			{button("Change main source", m.CALLBACK_COMMAND_DRAFT_MAIN_SOURCE)},
+		},
+	}
+}
+
+func ConfirmDeleteQuoteReplyMarkup(quoteID int64) models.InlineKeyboardMarkup {
+	confirmCallbackData := m.CallbackData{
+		Action: m.CALLBACK_COMMAND_QUOTE_CONFIRM_DELETE,
+		Data:   strconv.FormatInt(quoteID, 10),
+	}
+
+	return models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{{Text: "Yes, delete it", CallbackData: confirmCallbackData.Marshal()}},
+		},
+	}
+}
+
+// QuoteIDFromReplyMarkup finds the quote ID in the keyboard created by QuoteReplyMarkup.
+// Reply markups of received messages are not typed, so the markup is re-decoded.
+func QuoteIDFromReplyMarkup(replyMarkup models.ReplyMarkup) (int64, bool) {
+	if replyMarkup == nil {
+		return 0, false
+	}
+
+	markupBytes, err := json.Marshal(replyMarkup)
+	if err != nil {
+		return 0, false
+	}
+
+	var markup models.InlineKeyboardMarkup
+	if err = json.Unmarshal(markupBytes, &markup); err != nil {
+		return 0, false
+	}
+
+	for _, row := range markup.InlineKeyboard {
+		for _, button := range row {
+			callbackData, err := m.UnmarshalCallbackData(button.CallbackData)
+			if err != nil || callbackData.Action != m.CALLBACK_COMMAND_QUOTE_EDIT {
+				continue
+			}
+
+			quoteID, err := strconv.ParseInt(callbackData.Data, 10, 0)
+			if err != nil {
+				return 0, false
+			}
+			return quoteID, true
+		}
+	}
+
+	return 0, false
+}
+
+func QuoteFromDB(quote *db.QuoteWithData) *Quote {
+	q := Quote{
+		Text:      quote.Text,
+		Tags:      quote.Tags,
+		OriginURL: quote.OriginURL.String,
+		Entities:  quote.TextEntities,
+		Location:  quote.Location.String,
+		Note:      quote.Note.String,
+	}
+	if quote.MainSource.Valid {
+		q.MainSource = quote.MainSource.String
+		q.Sources = append(q.Sources, quote.MainSource.String)
+	}
+
+	for _, source := range quote.Sources {
+		if source != q.MainSource {
+			q.Sources = append(q.Sources, source)
+		}
+	}
+
+	return &q
+}
+
+func ParseOutputFilters(filters pgtype.JSON) (db.OutputFilters, error) {
+	var data db.OutputFilters
+	if filters.Status != pgtype.Present {
+		return data, nil
+	}
+
+	if err := json.Unmarshal(filters.Bytes, &data); err != nil {
+		return data, err
+	}
+	return data, nil
+}
+
+// ParseListOption splits a comma separated option value, dropping empty items
+// and the '#' prefix of tags.
+func ParseListOption(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimPrefix(strings.TrimSpace(item), "#")
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// OutputAcceptsQuote reports whether a quote with given tags and sources passes the filters of an output.
+func OutputAcceptsQuote(filters *db.OutputFilters, tags []string, sources []db.Source) bool {
+	sourceNames := make([]string, 0, len(sources))
+	sourceKinds := make([]string, 0, len(sources))
+	for _, source := range sources {
+		sourceNames = append(sourceNames, source.Name)
+		sourceKinds = append(sourceKinds, string(source.Kind))
+	}
+
+	if len(filters.IncludeTags) != 0 && !containsAny(tags, filters.IncludeTags) {
+		return false
+	}
+	if containsAny(tags, filters.ExcludeTags) {
+		return false
+	}
+	if len(filters.IncludeSources) != 0 && !containsAny(sourceNames, filters.IncludeSources) {
+		return false
+	}
+	if containsAny(sourceNames, filters.ExcludeSources) {
+		return false
+	}
+	if len(filters.IncludeSourceKinds) != 0 && !containsAny(sourceKinds, filters.IncludeSourceKinds) {
+		return false
+	}
+	if containsAny(sourceKinds, filters.ExcludeSourceKinds) {
+		return false
+	}
+
+	return true
+}
+
+func containsAny(items []string, targets []string) bool {
+	for _, item := range items {
+		for _, target := range targets {
+			if strings.EqualFold(item, target) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func IsValidSourceKind(sourceKind string) bool {
 	for _, validSourceKind := range db.VALID_SOURCE_KINDS {
 		if sourceKind == validSourceKind {
@@ -233,7 +455,7 @@ func ParseSourceData(sourceKind db.SourceKind, sourceData pgtype.JSON) (any, err
 	}
 }
 
-func SourcesReplyMarkup(sources []db.Source, firstPage, lastPage bool) models.InlineKeyboardMarkup {
+func SourcesReplyMarkup(sources []db.Source, filter m.SourceFilter, firstPage, lastPage bool) models.InlineKeyboardMarkup {
 	inlineKeyboard := [][]models.InlineKeyboardButton{}
 
 	if len(sources) == 0 {
@@ -244,15 +466,18 @@ func SourcesReplyMarkup(sources []db.Source, firstPage, lastPage bool) models.In
 		num := i + 1
 		numStr := strconv.Itoa(num)
 		infoCallbackData, editCallbackData := MakeSourceKeyboardCallbacks(source.ID)
+		mergeCallbackData, deleteCallbackData := MakeSourceChangeKeyboardCallbacks(source.ID)
 		inlineKeyboard = append(inlineKeyboard, []models.InlineKeyboardButton{
 			{Text: numStr + ". Info", CallbackData: infoCallbackData},
 			{Text: numStr + ". Edit", CallbackData: editCallbackData},
+			{Text: numStr + ". Merge", CallbackData: mergeCallbackData},
+			{Text: numStr + ". Delete", CallbackData: deleteCallbackData},
 		})
 	}
 
 	firstSourceID := sources[0].ID
 	lastSourceID := sources[len(sources)-1].ID
-	prevPageCallbackData, nextPageCallbackData := MakeSourceKeyboardPagesCallbacks(firstSourceID, lastSourceID)
+	prevPageCallbackData, nextPageCallbackData := MakeSourceKeyboardPagesCallbacks(firstSourceID, lastSourceID, filter)
 
 	if !firstPage && !lastPage {
 		inlineKeyboard = append(inlineKeyboard, []models.InlineKeyboardButton{
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/aigic8/warmlight/internal/db"
+	m "github.com/aigic8/warmlight/pkg/bot/models"
+	"github.com/go-telegram/bot/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoteIDFromReplyMarkup(t *testing.T) {
+	var quoteID int64 = 12
+
+	// reply markups of received messages are decoded to maps
+	markupBytes, err := json.Marshal(QuoteReplyMarkup(quoteID))
+	if err != nil {
+		panic(err)
+	}
+	var receivedMarkup models.ReplyMarkup
+	if err = json.Unmarshal(markupBytes, &receivedMarkup); err != nil {
+		panic(err)
+	}
+
+	resID, ok := QuoteIDFromReplyMarkup(receivedMarkup)
+	assert.True(t, ok)
+	assert.Equal(t, quoteID, resID)
+
+	_, ok = QuoteIDFromReplyMarkup(nil)
+	assert.False(t, ok)
+
+	_, ok = QuoteIDFromReplyMarkup(MergeOrDeleteCurrentLibraryReplyMarkup)
+	assert.False(t, ok)
+}
+
+func TestOutputAcceptsQuote(t *testing.T) {
+	sources := []db.Source{{Name: "Animal Farm", Kind: db.SourceKindBook}, {Name: "George Orwell", Kind: db.SourceKindPerson}}
+	tags := []string{"politics", "fiction"}
+
+	testCases := []struct {
+		Name     string
+		Filters  db.OutputFilters
+		Expected bool
+	}{
+		{Name: "no filters", Filters: db.OutputFilters{}, Expected: true},
+		{Name: "included tag", Filters: db.OutputFilters{IncludeTags: []string{"work", "Politics"}}, Expected: true},
+		{Name: "not included tag", Filters: db.OutputFilters{IncludeTags: []string{"work"}}, Expected: false},
+		{Name: "excluded tag", Filters: db.OutputFilters{ExcludeTags: []string{"fiction"}}, Expected: false},
+		{Name: "included source", Filters: db.OutputFilters{IncludeSources: []string{"George Orwell"}}, Expected: true},
+		{Name: "excluded source", Filters: db.OutputFilters{ExcludeSources: []string{"animal farm"}}, Expected: false},
+		{Name: "not included source kind", Filters: db.OutputFilters{IncludeSourceKinds: []string{"article"}}, Expected: false},
+		{Name: "excluded source kind", Filters: db.OutputFilters{ExcludeSourceKinds: []string{"person"}}, Expected: false},
+		{Name: "mixed", Filters: db.OutputFilters{IncludeTags: []string{"fiction"}, IncludeSourceKinds: []string{"book"}, ExcludeTags: []string{"work"}}, Expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, OutputAcceptsQuote(&tc.Filters, tags, sources))
+		})
+	}
+}
+
+func TestParseListOption(t *testing.T) {
+	assert.Equal(t, []string{"work", "productivity"}, ParseListOption(" #work, productivity ,, "))
+	assert.Equal(t, []string{}, ParseListOption(""))
+}
+
+func TestSourcePageCallbacks(t *testing.T) {
+	filter := m.SourceFilter{Text: strings.Repeat("_", SOURCE_FILTER_TEXT_MAX_LEN), SourceKind: string(db.SourceKindUnknown)}
+	prev, next := MakeSourceKeyboardPagesCallbacks(math.MinInt64, math.MaxInt64, filter)
+	// telegram rejects callback data longer than 64 bytes
+	assert.LessOrEqual(t, len(prev), 64)
+	assert.LessOrEqual(t, len(next), 64)
+
+	callbackData, err := m.UnmarshalCallbackData(next)
+	assert.Nil(t, err)
+	assert.Equal(t, m.CALLBACK_MSG_NEXT_SOURCE_PAGE, callbackData.ReplaceMessageWith)
+	baseID, resFilter, err := ParseSourcePageCallbackData(callbackData.Data)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(math.MaxInt64), baseID)
+	assert.Equal(t, filter, resFilter)
+
+	_, _, err = ParseSourcePageCallbackData("12")
+	assert.ErrorIs(t, err, m.ErrMalformedCallbackString)
+}
@@ -21,13 +21,16 @@ type (
 
 	BotConfig struct {
 		Token                          string `toml:"token" validate:"required"`
-		WebhookURL                     string `toml:"webhookUrl" validate:"required"`
-		CertFilePath                   string `toml:"certFile" validate:"required"`
-		PrivKeyFilePath                string `toml:"privKeyFile" validate:"required"`
+		Mode                           string `toml:"mode" validate:"oneof=webhook httpWebhook polling"`
+		WebhookURL                     string `toml:"webhookUrl" validate:"required_unless=Mode polling"`
+		WebhookSecret                  string `toml:"webhookSecret" validate:"max=256"`
+		CertFilePath                   string `toml:"certFile" validate:"required_if=Mode webhook"`
+		PrivKeyFilePath                string `toml:"privKeyFile" validate:"required_if=Mode webhook"`
 		LogPath                        string `toml:"logPath" validate:"required"`
 		IsDev                          bool   `toml:"isDev"`
 		DefaultActiveSourceTimeoutMins int    `toml:"defaultActiveSourceTimeoutMins" validate:"gte=0"`
 		DeactivatorIntervalMins        int    `toml:"deactivatorIntervalMins" validate:"gte=0"`
+		ListenAddress                  string `toml:"listenAddress"`
 		Port                           int    `toml:"port" validate:"gte=0"`
 		LibraryTokenExpireMins         int    `toml:"libraryTokenExpireMins" validate:"gte=0"`
 	}
@@ -38,6 +41,7 @@ const DEFAULT_DEACTIVATOR_INTERVAL_MINS = 10
 const DEFAULT_DB_TIMEOUT_MS = 5000
 const DEFAULT_LIBRARY_TOKEN_EXPIRE_MINS = 30
 const DEFAULT_PORT = 443
+const DEFAULT_BOT_MODE = "webhook"
 
 func LoadConfig(configPath string) (*Config, error) {
 	// TODO: test LoadConfig
@@ -57,6 +61,11 @@ func LoadConfig(configPath string) (*Config, error) {
 		panic(err)
 	}
 
+	// mode should be set before validation, since webhook options are required based on it
+	if config.Bot != nil && config.Bot.Mode == "" {
+		config.Bot.Mode = DEFAULT_BOT_MODE
+	}
+
 	v := validator.New()
 	if err := v.Struct(&config); err != nil {
 		return nil, err
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type loadConfigModeTestCase struct {
+	Name         string
+	BotConfig    string
+	ExpectedMode string
+	IsValid      bool
+}
+
+func TestLoadConfigMode(t *testing.T) {
+	testCases := []loadConfigModeTestCase{
+		{Name: "default mode", BotConfig: "webhookUrl = \"https://mysite.com/hook\"\ncertFile = \"cert\"\nprivKeyFile = \"key\"", ExpectedMode: "webhook", IsValid: true},
+		{Name: "webhook without certificates", BotConfig: "mode = \"webhook\"\nwebhookUrl = \"https://mysite.com/hook\"", IsValid: false},
+		{Name: "http webhook", BotConfig: "mode = \"httpWebhook\"\nwebhookUrl = \"https://mysite.com/hook\"", ExpectedMode: "httpWebhook", IsValid: true},
+		{Name: "http webhook without url", BotConfig: "mode = \"httpWebhook\"", IsValid: false},
+		{Name: "polling", BotConfig: "mode = \"polling\"", ExpectedMode: "polling", IsValid: true},
+		{Name: "unknown mode", BotConfig: "mode = \"carrierPigeon\"", IsValid: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			configText := "[db]\nurl = \"postgresql://localhost/warmlight\"\n[bot]\ntoken = \"token\"\nlogPath = \"warmlight.log\"\n" + tc.BotConfig
+			configPath := path.Join(t.TempDir(), "warmlight.toml")
+			if err := os.WriteFile(configPath, []byte(configText), 0644); err != nil {
+				panic(err)
+			}
+
+			config, err := LoadConfig(configPath)
+			if !tc.IsValid {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.ExpectedMode, config.Bot.Mode)
+		})
+	}
+}